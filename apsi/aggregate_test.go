@@ -0,0 +1,47 @@
+package apsi
+
+import "testing"
+
+func TestAggregateRoundTrip(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 3}}
+	_, aggregate, err := authority.AuthorizeAggregate(set, ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signatures, err := authority.AuthorizeSet(set, ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !AggregateSignatures(pairing, signatures).Equals(aggregate) {
+		t.Error("AuthorizeAggregate differs from AggregateSignatures")
+	}
+	if ok, err := VerifyAggregate(pairing, pk, set, aggregate, ServerParty); err != nil || !ok {
+		t.Errorf("VerifyAggregate = %v, %v", ok, err)
+	}
+	if ok, _ := VerifyAggregate(pairing, pk, set[1:], aggregate, ServerParty); ok {
+		t.Error("aggregate verifies for a subset")
+	}
+	if ok, _ := VerifyAggregate(pairing, pk, set, aggregate, ClientParty); ok {
+		t.Error("server aggregate verifies for the client")
+	}
+}
+
+func TestAggregateRejectsDuplicates(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 1}}
+	if _, _, err := authority.AuthorizeAggregate(set, ClientParty); err != ErrDuplicateElement {
+		t.Errorf("AuthorizeAggregate = %v, want %v", err, ErrDuplicateElement)
+	}
+
+	// The verifier refuses repeated elements before checking anything.
+	_, aggregate, err := authority.AuthorizeAggregate(set[:2], ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyAggregate(pairing, pk, set, aggregate, ClientParty); err != ErrDuplicateElement {
+		t.Errorf("VerifyAggregate = %v, want %v", err, ErrDuplicateElement)
+	}
+}
@@ -0,0 +1,562 @@
+package apsi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An audit log records every authorization an Authority signs, whether
+// one element at a time, as an aggregate or blindly, every one it revokes,
+// and every session a Client, Server or interaction runs. It is a file of
+// JSON lines, one entry each, and every entry carries the hash of the one
+// before it, so changing or dropping an entry breaks every hash after it.
+// Dropping entries off the end leaves a shorter but valid chain, so the
+// log keeps its head, the last sequence number and hash, in a .head file
+// next to it; copying the head somewhere else from time to time lets
+// truncation of both be caught as well.
+//
+// Authorizations and revocations are recorded by a salted commitment to the
+// element, as in an AuthorizationCertificate, which lets an auditor check
+// the log against a set. It does not hide the elements: the salt is in the
+// entry and an element is only 32 bits, so anyone who can read the log can
+// find every element in it by trying them all. Keep the log as carefully
+// as the sets. A blind authorization is recorded by a commitment to the
+// blinded point, the only thing the authority saw.
+
+var (
+	// ErrAuditModified is returned when an audit log entry does not match
+	// its hash or its predecessor's.
+	ErrAuditModified = errors.New("apsi: audit log has been modified")
+
+	// ErrAuditTruncated is returned when an audit log ends before its head.
+	ErrAuditTruncated = errors.New("apsi: audit log has been truncated")
+
+	// ErrAuditLocked is returned when an audit log is already open in
+	// another process.
+	ErrAuditLocked = errors.New("apsi: audit log is open in another process")
+)
+
+// AuditError locates the entry an audit log failed to verify at.
+type AuditError struct {
+	Line int
+	Err  error
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("apsi: audit log line %d: %v", e.Line, e.Err)
+}
+
+// AuditEvent is what an audit log entry records.
+type AuditEvent string
+
+// The events an audit log records.
+const (
+	AuditAuthorize   AuditEvent = "authorize"
+	AuditRevoke      AuditEvent = "revoke"
+	AuditAggregate   AuditEvent = "aggregate"
+	AuditBlind       AuditEvent = "blind"
+	AuditSession     AuditEvent = "session"
+	AuditInteraction AuditEvent = "interaction"
+)
+
+// AuditEntry is one entry of an audit log. Commitment and Salt are set for
+// an authorization of any kind or a revocation. ClientSize, ServerSize and
+// Matches are the sizes of the two sets and of the intersection as far as
+// the side recording a session knows them; a server never learns the last
+// two. Party is not
+// used for an AuditInteraction, which runs both sides.
+type AuditEntry struct {
+	Seq   uint64
+	Time  time.Time
+	Event AuditEvent
+	Party Party
+	KeyID KeyID
+
+	ClientID   ClientID
+	Commitment [32]byte
+	Salt       [16]byte
+	ClientSize int
+	ServerSize int
+	Matches    int
+
+	Prev [32]byte
+	Hash [32]byte
+}
+
+// contents encodes the hashed fields of the entry:
+//
+//	seq (8) | time (8) | len(event) (2) | event | party (1) | key ID (8) |
+//	len(client ID) (2) | client ID | commitment (32) | salt (16) |
+//	client size (8) | server size (8) | matches (8)
+//
+// with the time in Unix nanoseconds.
+func (entry *AuditEntry) contents() []byte {
+	b := appendUint64(nil, entry.Seq)
+	b = appendUint64(b, uint64(entry.Time.UnixNano()))
+	b = appendBytes16(b, []byte(entry.Event))
+	b = append(b, byte(entry.Party))
+	b = append(b, entry.KeyID[:]...)
+	b = appendBytes16(b, []byte(entry.ClientID))
+	b = append(b, entry.Commitment[:]...)
+	b = append(b, entry.Salt[:]...)
+	b = appendUint64(b, uint64(entry.ClientSize))
+	b = appendUint64(b, uint64(entry.ServerSize))
+	return appendUint64(b, uint64(entry.Matches))
+}
+
+// hash computes SHA-256("apsi-audit-v1" || prev || contents).
+func (entry *AuditEntry) hash() [32]byte {
+	h := sha256.New()
+	h.Write([]byte("apsi-audit-v1"))
+	h.Write(entry.Prev[:])
+	h.Write(entry.contents())
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// auditRecord is the JSON form of an AuditEntry.
+type auditRecord struct {
+	Seq        uint64 `json:"seq"`
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	Party      string `json:"party,omitempty"`
+	KeyID      string `json:"key"`
+	ClientID   string `json:"client_id,omitempty"`
+	Commitment string `json:"commitment,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	ClientSize int    `json:"client_size,omitempty"`
+	ServerSize int    `json:"server_size,omitempty"`
+	Matches    int    `json:"matches,omitempty"`
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash"`
+}
+
+func (entry *AuditEntry) record() auditRecord {
+	rec := auditRecord{
+		Seq:        entry.Seq,
+		Time:       entry.Time.Format(time.RFC3339Nano),
+		Event:      string(entry.Event),
+		KeyID:      entry.KeyID.String(),
+		ClientID:   string(entry.ClientID),
+		ClientSize: entry.ClientSize,
+		ServerSize: entry.ServerSize,
+		Matches:    entry.Matches,
+		Prev:       hex.EncodeToString(entry.Prev[:]),
+		Hash:       hex.EncodeToString(entry.Hash[:]),
+	}
+	if entry.Event != AuditInteraction {
+		rec.Party = entry.Party.String()
+	}
+	if entry.Event.committed() {
+		rec.Commitment = hex.EncodeToString(entry.Commitment[:])
+		rec.Salt = hex.EncodeToString(entry.Salt[:])
+	}
+	return rec
+}
+
+// committed reports whether entries for the event carry a commitment.
+func (event AuditEvent) committed() bool {
+	switch event {
+	case AuditAuthorize, AuditRevoke, AuditAggregate, AuditBlind:
+		return true
+	}
+	return false
+}
+
+func decodeHexInto(dst []byte, s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(dst) {
+		return ErrAuditModified
+	}
+	copy(dst, b)
+	return nil
+}
+
+func (rec *auditRecord) entry() (*AuditEntry, error) {
+	entry := &AuditEntry{
+		Seq:        rec.Seq,
+		Event:      AuditEvent(rec.Event),
+		ClientID:   ClientID(rec.ClientID),
+		ClientSize: rec.ClientSize,
+		ServerSize: rec.ServerSize,
+		Matches:    rec.Matches,
+	}
+	var err error
+	if entry.Time, err = time.Parse(time.RFC3339Nano, rec.Time); err != nil {
+		return nil, ErrAuditModified
+	}
+	switch rec.Party {
+	case "":
+	case ClientParty.String():
+		entry.Party = ClientParty
+	case ServerParty.String():
+		entry.Party = ServerParty
+	default:
+		return nil, ErrAuditModified
+	}
+	if err := decodeHexInto(entry.KeyID[:], rec.KeyID); err != nil {
+		return nil, err
+	}
+	if rec.Commitment != "" || rec.Salt != "" {
+		if err := decodeHexInto(entry.Commitment[:], rec.Commitment); err != nil {
+			return nil, err
+		}
+		if err := decodeHexInto(entry.Salt[:], rec.Salt); err != nil {
+			return nil, err
+		}
+	}
+	if err := decodeHexInto(entry.Prev[:], rec.Prev); err != nil {
+		return nil, err
+	}
+	if err := decodeHexInto(entry.Hash[:], rec.Hash); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// AuditHead is the last entry of an audit log, by sequence number and
+// hash. The head of an empty log is zero.
+type AuditHead struct {
+	Seq  uint64
+	Hash [32]byte
+}
+
+// String formats the head as seq:hash, as ParseAuditHead reads it.
+func (head AuditHead) String() string {
+	return fmt.Sprintf("%d:%x", head.Seq, head.Hash)
+}
+
+// ParseAuditHead parses a head formatted by String.
+func ParseAuditHead(s string) (AuditHead, error) {
+	var head AuditHead
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return head, fmt.Errorf("apsi: audit head %q is not seq:hash", s)
+	}
+	seq, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return head, fmt.Errorf("apsi: audit head %q is not seq:hash", s)
+	}
+	head.Seq = seq
+	if decodeHexInto(head.Hash[:], s[i+1:]) != nil {
+		return head, fmt.Errorf("apsi: audit head %q is not seq:hash", s)
+	}
+	return head, nil
+}
+
+// VerifyAuditLog checks every entry of the log in r against its hash and
+// its predecessor and returns the log's head. If expected is given, it
+// also checks that the log reaches it and that the entry there matches,
+// failing with ErrAuditTruncated or ErrAuditModified. Errors about an
+// entry come as an *AuditError.
+func VerifyAuditLog(r io.Reader, expected *AuditHead) (AuditHead, error) {
+	var head AuditHead
+	var matched bool
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec auditRecord
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return head, &AuditError{line, ErrAuditModified}
+		}
+		entry, err := rec.entry()
+		if err != nil {
+			return head, &AuditError{line, err}
+		}
+		if entry.Seq != head.Seq+1 || entry.Prev != head.Hash || entry.hash() != entry.Hash {
+			return head, &AuditError{line, ErrAuditModified}
+		}
+		head = AuditHead{Seq: entry.Seq, Hash: entry.Hash}
+		if expected != nil && head.Seq == expected.Seq {
+			if head.Hash != expected.Hash {
+				return head, &AuditError{line, ErrAuditModified}
+			}
+			matched = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return head, err
+	}
+	if expected != nil && expected.Seq > 0 && !matched {
+		return head, ErrAuditTruncated
+	}
+	return head, nil
+}
+
+// AuditLog appends entries to an audit log file. It is safe for use by
+// several goroutines, so one log can be shared by an Authority and any
+// number of sessions, but only one process may have a log open at a time:
+// it holds a lock on the log's .lock file until Close.
+type AuditLog struct {
+	path string
+	lock *fileLock
+
+	mu   sync.Mutex
+	f    *os.File
+	head AuditHead
+	size int64
+}
+
+// AuditHeadPath returns the path of the head file kept for the log at
+// path.
+func AuditHeadPath(path string) string {
+	return path + ".head"
+}
+
+// ReadAuditHead reads a head file.
+func ReadAuditHead(path string) (AuditHead, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return AuditHead{}, err
+	}
+	return ParseAuditHead(strings.TrimSpace(string(data)))
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if
+// it does not exist, and fails with ErrAuditLocked if another process has
+// it open. An existing log is verified against its head file first, and
+// is not opened if it fails. The log may run past the head, which is
+// written after each Record.
+//
+// A last line without its newline was cut short in the middle of a Record,
+// before the head could cover it, and is dropped so that later entries do
+// not chain onto it.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	lock, err := lockFile(path+".lock", false)
+	if err == errFileLocked {
+		return nil, ErrAuditLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	log, err := openAuditLog(path)
+	if err != nil {
+		lock.unlock()
+		return nil, err
+	}
+	log.lock = lock
+	return log, nil
+}
+
+func openAuditLog(path string) (*AuditLog, error) {
+	var expected *AuditHead
+	head, err := ReadAuditHead(AuditHeadPath(path))
+	if err == nil {
+		expected = &head
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	size, err := completeLines(f)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		head, err = VerifyAuditLog(io.NewSectionReader(f, 0, size), expected)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &AuditLog{path: path, f: f, head: head, size: size}, nil
+}
+
+// completeLines returns the length of f up to the end of its last
+// newline.
+func completeLines(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	buf := make([]byte, 4096)
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return end - n + int64(i) + 1, nil
+		}
+		end -= n
+	}
+	return 0, nil
+}
+
+// Head returns the log's current head.
+func (log *AuditLog) Head() AuditHead {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.head
+}
+
+// Close closes the log file and lets another process open it.
+func (log *AuditLog) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	err := log.f.Close()
+	if unlockErr := log.lock.unlock(); err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
+// Record appends entries to the log in order, filling in their sequence
+// numbers, times and hashes. They are written and synced together, and
+// the head is written once after the last of them. If they cannot all be
+// written, the log is cut back to where it was.
+func (log *AuditLog) Record(entries ...AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	head := log.head
+	now := time.Now()
+	var lines []byte
+	for _, entry := range entries {
+		entry.Seq = head.Seq + 1
+		entry.Time = now
+		entry.Prev = head.Hash
+		entry.Hash = entry.hash()
+		line, err := json.Marshal(entry.record())
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+		head = AuditHead{Seq: entry.Seq, Hash: entry.Hash}
+	}
+	_, err := log.f.Write(lines)
+	if err == nil {
+		err = log.f.Sync()
+	}
+	if err != nil {
+		log.f.Truncate(log.size)
+		return err
+	}
+	log.size += int64(len(lines))
+	log.head = head
+	return log.writeHead()
+}
+
+// writeHead replaces the head file, so that it never holds a partial head.
+func (log *AuditLog) writeHead() error {
+	return replaceFile(AuditHeadPath(log.path), []byte(log.head.String()+"\n"))
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// over path, so that readers see either the old contents or the new.
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordElements records that every element of set was authorized or
+// revoked for party under key, as one Record.
+func (log *AuditLog) recordElements(event AuditEvent, party Party, key KeyID, set RawElementSlice) error {
+	entries := make([]AuditEntry, len(set))
+	for i, elt := range set {
+		entries[i] = AuditEntry{Event: event, Party: party, KeyID: key}
+		if _, err := io.ReadFull(rand.Reader, entries[i].Salt[:]); err != nil {
+			return err
+		}
+		entries[i].Commitment = commitElement(entries[i].Salt, elt)
+	}
+	return log.Record(entries...)
+}
+
+// recordBlinded records that every request was signed blindly for party
+// under key, as one Record.
+func (log *AuditLog) recordBlinded(party Party, key KeyID, requests []*BlindRequest) error {
+	entries := make([]AuditEntry, len(requests))
+	for i, request := range requests {
+		entries[i] = AuditEntry{Event: AuditBlind, Party: party, KeyID: key}
+		if _, err := io.ReadFull(rand.Reader, entries[i].Salt[:]); err != nil {
+			return err
+		}
+		entries[i].Commitment = commitBlinded(entries[i].Salt, request.Blinded.Bytes())
+	}
+	return log.Record(entries...)
+}
+
+// commitBlinded computes SHA-256("apsi-blind-commitment-v1" || salt || B).
+func commitBlinded(salt [16]byte, blinded []byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("apsi-blind-commitment-v1"))
+	h.Write(salt[:])
+	h.Write(blinded)
+	var commitment [32]byte
+	copy(commitment[:], h.Sum(nil))
+	return commitment
+}
+
+// WithAuditLog records every session of a Client or Server, or the
+// interaction run by a *Interaction method, in log.
+func WithAuditLog(log *AuditLog) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.audit = log
+	}
+}
+
+// recordSession records a session in the config's audit log, if it has
+// one.
+func (cfg *interactionConfig) recordSession(entry AuditEntry) error {
+	if cfg.audit == nil {
+		return nil
+	}
+	if entry.Event == "" {
+		entry.Event = AuditSession
+	}
+	return cfg.audit.Record(entry)
+}
+
+// recordInteraction records an interaction run by a *Interaction method.
+func (cfg *interactionConfig) recordInteraction(pk PublicKey, clientSet, serverSet, intersection RawElementSlice) error {
+	return cfg.recordSession(AuditEntry{
+		Event:      AuditInteraction,
+		KeyID:      pk.ID(),
+		ClientID:   cfg.clientID,
+		ClientSize: len(clientSet),
+		ServerSize: len(serverSet),
+		Matches:    len(intersection),
+	})
+}
@@ -0,0 +1,146 @@
+package apsi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAuditLog returns the path of a log in a fresh directory holding n
+// authorization entries.
+func testAuditLog(t *testing.T, n int) string {
+	dir, err := ioutil.TempDir("", "apsi-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := make(RawElementSlice, n)
+	for i := range set {
+		set[i] = RawElement{0, 0, 0, byte(i)}
+	}
+	if err := log.recordElements(AuditAuthorize, ClientParty, KeyID{1}, set); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func verifyAuditFile(t *testing.T, path string) (AuditHead, error) {
+	head, err := ReadAuditHead(AuditHeadPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return VerifyAuditLog(f, &head)
+}
+
+func TestAuditLogVerifies(t *testing.T) {
+	path := testAuditLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	head, err := verifyAuditFile(t, path)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("head.Seq = %d, want 3", head.Seq)
+	}
+}
+
+func TestAuditLogModified(t *testing.T) {
+	path := testAuditLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"party":"client"`), []byte(`"party":"server"`), 1)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyAuditFile(t, path)
+	if e, ok := err.(*AuditError); !ok || e.Line != 1 || e.Err != ErrAuditModified {
+		t.Errorf("VerifyAuditLog = %v, want line 1 modified", err)
+	}
+	if _, err := OpenAuditLog(path); err == nil {
+		t.Errorf("OpenAuditLog opened a modified log")
+	}
+}
+
+func TestAuditLogTruncated(t *testing.T) {
+	path := testAuditLog(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := ioutil.WriteFile(path, []byte(lines[0]+lines[1]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyAuditFile(t, path); err != ErrAuditTruncated {
+		t.Errorf("VerifyAuditLog = %v, want %v", err, ErrAuditTruncated)
+	}
+}
+
+func TestAuditLogTornLine(t *testing.T) {
+	path := testAuditLog(t, 2)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":`)
+	f.Close()
+
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenAuditLog: %v", err)
+	}
+	if err := log.Record(AuditEntry{Event: AuditSession, Party: ServerParty}); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	head, err := verifyAuditFile(t, path)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("head.Seq = %d, want 3", head.Seq)
+	}
+}
+
+func TestAuditLogLocked(t *testing.T) {
+	path := testAuditLog(t, 1)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenAuditLog(path); err != ErrAuditLocked {
+		t.Errorf("second OpenAuditLog = %v, want %v", err, ErrAuditLocked)
+	}
+	log.Close()
+	again, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("OpenAuditLog after Close: %v", err)
+	}
+	again.Close()
+}
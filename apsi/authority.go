@@ -0,0 +1,187 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Authority is the judge. It is the only role that holds SK_J = (x, y), and
+// it authorizes elements for the client and the server.
+type Authority struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	pk PublicKey
+	sk SecretKey
+
+	audit *AuditLog
+
+	// blindPeriod is the epoch period blind requests are signed for, or 0
+	// while blind issuance is disabled.
+	blindPeriod time.Duration
+}
+
+// NewAuthority runs the Setup phase and returns the time it took along with
+// the new authority.
+func NewAuthority() (time.Duration, *Authority) {
+	// The Setup phase generates public parameters:
+	//
+	//  - e : G x G -> G_T
+	//  - PK_J = (P, xP, yP)
+	//  - SK_J = (x, y)
+	//
+	// along with the document key z and zP.
+	startSetup := time.Now()
+
+	params := pbc.GenerateA(160, 512)
+	pairing := params.NewPairing()
+
+	P := pairing.NewG1()
+	xP := pairing.NewG1()
+	yP := pairing.NewG1()
+	zP := pairing.NewG1()
+	x := pairing.NewZr()
+	y := pairing.NewZr()
+	z := pairing.NewZr()
+
+	P.Rand()
+	x.Rand()
+	y.Rand()
+	z.Rand()
+	xP.MulZn(P, x)
+	yP.MulZn(P, y)
+	zP.MulZn(P, z)
+
+	setupTime := time.Since(startSetup)
+	return setupTime, &Authority{
+		params:  params,
+		pairing: pairing,
+		pk:      PublicKey{P, xP, yP, zP},
+		sk:      SecretKey{x, y, z},
+	}
+}
+
+// Params returns the pairing parameters generated during Setup.
+func (authority *Authority) Params() *pbc.Params {
+	return authority.params
+}
+
+// Pairing returns the pairing the authority's keys live in.
+func (authority *Authority) Pairing() *pbc.Pairing {
+	return authority.pairing
+}
+
+// PublicKey returns PK_J.
+func (authority *Authority) PublicKey() PublicKey {
+	return authority.pk
+}
+
+// SetAuditLog makes the authority record every authorization it signs or
+// revokes in log. Authorities derived from it, by ForClient or Rotate, record in the
+// same log.
+func (authority *Authority) SetAuditLog(log *AuditLog) {
+	authority.audit = log
+}
+
+// hashElement computes H(elt) in G1.
+func hashElement(pairing *pbc.Pairing, elt RawElement) *pbc.Element {
+	hashed := sha256.Sum256(elt[:])
+	return pairing.NewG1().SetFromHash(hashed[:])
+}
+
+// Authorize signs elt for the given party, returning xH(elt) for the client
+// and yH(elt) for the server. Options such as AtEpoch bind the signature to
+// more than elt.
+func (authority *Authority) Authorize(elt RawElement, party Party, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	secretKey, err := authority.sk.forParty(party)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	startTime := time.Now()
+
+	xH_elt := authority.sign(elt, secretKey, opts)
+
+	totalTime := time.Since(startTime)
+	if authority.audit != nil {
+		if err := authority.audit.recordElements(AuditAuthorize, party, authority.pk.ID(), RawElementSlice{elt}); err != nil {
+			return 0, nil, err
+		}
+	}
+	return totalTime, xH_elt, nil
+}
+
+// sign computes the authorization of elt under secretKey without recording
+// it.
+func (authority *Authority) sign(elt RawElement, secretKey *pbc.Element, opts []AuthorizeOption) *pbc.Element {
+	// Signature = xH(elt)
+	H_elt := newBinding(opts).hash(authority.pairing, elt)
+	xH_elt := authority.pairing.NewG1()
+	xH_elt.MulZn(H_elt, secretKey)
+	return xH_elt
+}
+
+// AuthorizeSet signs every element of elements for the given party, as
+// Authorize does, and records them in the audit log together. The returned
+// duration is the total signing time.
+func (authority *Authority) AuthorizeSet(elements RawElementSlice, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	secretKey, err := authority.sk.forParty(party)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	startTime := time.Now()
+
+	signatures := make([]*pbc.Element, len(elements))
+	for i, element := range elements {
+		signatures[i] = authority.sign(element, secretKey, opts)
+	}
+
+	totalTime := time.Since(startTime)
+	if authority.audit != nil {
+		if err := authority.audit.recordElements(AuditAuthorize, party, authority.pk.ID(), elements); err != nil {
+			return 0, nil, err
+		}
+	}
+	return totalTime, signatures, nil
+}
+
+// signDocument signs a domain-separated digest of contents, such as a
+// revocation list, with the document key z. Authorizations are made with x
+// and y on points anyone can choose, through blind issuance in particular,
+// so a document signed with either could be forged by asking for the right
+// authorization; z signs nothing else. The domain keeps one kind of
+// document from passing for another.
+func (authority *Authority) signDocument(domain string, contents []byte) *pbc.Element {
+	return authority.pairing.NewG1().MulZn(documentHash(authority.pairing, domain, contents), authority.sk.Z)
+}
+
+// verifyDocument checks a signature made by signDocument:
+// e(sig, P) == e(H(domain || contents), zP). Threshold and joint keys have
+// no zP, as nothing signs documents for them, so it fails under those.
+func verifyDocument(pairing *pbc.Pairing, pk PublicKey, domain string, contents []byte, signature *pbc.Element) bool {
+	if pk.ZP == nil {
+		return false
+	}
+	return verifyDocumentUnder(pairing, pk.P, pk.ZP, domain, contents, signature)
+}
+
+// verifyDocumentUnder checks a document signature made with the secret
+// behind key: e(sig, P) == e(H(domain || contents), key).
+func verifyDocumentUnder(pairing *pbc.Pairing, P, key *pbc.Element, domain string, contents []byte, signature *pbc.Element) bool {
+	if signature == nil {
+		return false
+	}
+	e_sig_P := pairing.NewGT().Pair(signature, P)
+	e_H_key := pairing.NewGT().Pair(documentHash(pairing, domain, contents), key)
+	return e_sig_P.Equals(e_H_key)
+}
+
+func documentHash(pairing *pbc.Pairing, domain string, contents []byte) *pbc.Element {
+	h := sha256.New()
+	h.Write([]byte(domain))
+	h.Write(contents)
+	return pairing.NewG1().SetFromHash(h.Sum(nil))
+}
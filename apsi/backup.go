@@ -0,0 +1,295 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A backup splits SK_J and the document key with Shamir's scheme, as Split
+// does, but the shares are only ever combined back into the authority's
+// key; they never sign. Each share carries Feldman commitments to every
+// polynomial, so recovery can check every share on its own against the
+// published xP, yP and zP before the secrets are rebuilt, and can name the
+// custodian whose share is wrong.
+//
+// A share is written as a PEM "APSI BACKUP SHARE" block, short enough to
+// print and type back in:
+//
+//	key ID (8) | t (2) | n (2) | i (2) | x_i | y_i | z_i |
+//	t commitments for x | t commitments for y | t commitments for z |
+//	checksum (4)
+//
+// where the checksum is the start of a SHA-256 digest of everything before
+// it and catches transcription errors. The block's headers repeat the key
+// ID and the share's position for whoever reads the paper copy.
+const pemBackupShare = "APSI BACKUP SHARE"
+
+var (
+	// ErrBackupChecksum is returned when a backup share does not match its
+	// checksum, usually because it was mistyped.
+	ErrBackupChecksum = errors.New("apsi: backup share checksum mismatch")
+
+	// ErrBackupKey is returned when a backup share was made for another
+	// key than the one being recovered.
+	ErrBackupKey = errors.New("apsi: backup share belongs to another key")
+
+	// ErrBackupMismatch is returned when a backup share comes from another
+	// backup of the key than the shares added before it.
+	ErrBackupMismatch = errors.New("apsi: backup share comes from another backup")
+
+	// ErrBackupShare is returned when a backup share does not match its
+	// commitments, or the commitments do not match the public key.
+	ErrBackupShare = errors.New("apsi: backup share does not match the public key")
+
+	// ErrBackupDuplicate is returned when the same share is added twice.
+	ErrBackupDuplicate = errors.New("apsi: backup share already added")
+)
+
+// BackupShare is one custodian's share of SK_J.
+type BackupShare struct {
+	KeyID     KeyID
+	Threshold int
+	Shares    int
+	Index     int
+
+	share                     SecretKey
+	commitX, commitY, commitZ []*pbc.Element
+}
+
+// Backup splits SK_J into shares of which any threshold recover it. The
+// shares should go to separate custodians, offline.
+func (authority *Authority) Backup(threshold, shares int) ([]*BackupShare, error) {
+	if err := checkThreshold(threshold, shares); err != nil {
+		return nil, err
+	}
+	pairing := authority.pairing
+	f := randomPolynomial(pairing, authority.sk.X, threshold-1)
+	g := randomPolynomial(pairing, authority.sk.Y, threshold-1)
+	commitX := f.commit(pairing, authority.pk.P)
+	commitY := g.commit(pairing, authority.pk.P)
+	h := randomPolynomial(pairing, authority.sk.Z, threshold-1)
+	commitZ := h.commit(pairing, authority.pk.P)
+
+	backup := make([]*BackupShare, shares)
+	for i := range backup {
+		backup[i] = &BackupShare{
+			KeyID:     authority.pk.ID(),
+			Threshold: threshold,
+			Shares:    shares,
+			Index:     i + 1,
+			share:     SecretKey{X: f.eval(pairing, i+1), Y: g.eval(pairing, i+1), Z: h.eval(pairing, i+1)},
+			commitX:   commitX,
+			commitY:   commitY,
+			commitZ:   commitZ,
+		}
+	}
+	return backup, nil
+}
+
+// Write writes the share as a PEM block laid out as above.
+func (share *BackupShare) Write(w io.Writer) error {
+	b := append([]byte(nil), share.KeyID[:]...)
+	b = appendUint16(b, uint16(share.Threshold))
+	b = appendUint16(b, uint16(share.Shares))
+	b = appendUint16(b, uint16(share.Index))
+	b = append(b, share.share.encode()...)
+	b = append(b, encodeElements(share.commitX...)...)
+	b = append(b, encodeElements(share.commitY...)...)
+	b = append(b, encodeElements(share.commitZ...)...)
+	checksum := sha256.Sum256(b)
+	return pem.Encode(w, &pem.Block{
+		Type: pemBackupShare,
+		Headers: map[string]string{
+			"Key-ID": share.KeyID.String(),
+			"Share":  fmt.Sprintf("%d of %d, any %d recover the key", share.Index, share.Shares, share.Threshold),
+		},
+		Bytes: append(b, checksum[:4]...),
+	})
+}
+
+// Recovery rebuilds SK_J from backup shares, checking each one against the
+// published public key as it is added.
+type Recovery struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+	pk      PublicKey
+
+	threshold, shares         int
+	commitX, commitY, commitZ []*pbc.Element
+	added                     map[int]SecretKey
+}
+
+// NewRecovery starts recovering the key whose public key file, as written
+// by WritePublicKey, is read from r.
+func NewRecovery(r io.Reader) (*Recovery, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, pairing, pk, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	if pk.ZP == nil {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemDocumentKey)
+	}
+	return &Recovery{
+		params:  params,
+		pairing: pairing,
+		pk:      pk,
+		added:   make(map[int]SecretKey),
+	}, nil
+}
+
+// Add reads a share written by BackupShare.Write from r and checks it. The
+// first share fixes the threshold and the commitments, whose constant terms
+// must be xP, yP and zP; every later share must come from the same backup, and
+// each x_iP and y_iP must match the commitments.
+func (rec *Recovery) Add(r io.Reader) (*BackupShare, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemBackupShare {
+		return nil, fmt.Errorf("apsi: no %s block", pemBackupShare)
+	}
+	share, err := rec.decode(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.commitX == nil {
+		if !share.commitX[0].Equals(rec.pk.XP) || !share.commitY[0].Equals(rec.pk.YP) ||
+			!share.commitZ[0].Equals(rec.pk.ZP) {
+			return nil, ErrBackupShare
+		}
+	} else if share.Threshold != rec.threshold || share.Shares != rec.shares ||
+		!equalElements(share.commitX, rec.commitX) || !equalElements(share.commitY, rec.commitY) ||
+		!equalElements(share.commitZ, rec.commitZ) {
+		return nil, ErrBackupMismatch
+	}
+	if _, dup := rec.added[share.Index]; dup {
+		return nil, ErrBackupDuplicate
+	}
+	xP := rec.pairing.NewG1().MulZn(rec.pk.P, share.share.X)
+	yP := rec.pairing.NewG1().MulZn(rec.pk.P, share.share.Y)
+	zP := rec.pairing.NewG1().MulZn(rec.pk.P, share.share.Z)
+	if !xP.Equals(evalCommitment(rec.pairing, share.commitX, share.Index)) ||
+		!yP.Equals(evalCommitment(rec.pairing, share.commitY, share.Index)) ||
+		!zP.Equals(evalCommitment(rec.pairing, share.commitZ, share.Index)) {
+		return nil, ErrBackupShare
+	}
+	rec.threshold, rec.shares = share.Threshold, share.Shares
+	rec.commitX, rec.commitY, rec.commitZ = share.commitX, share.commitY, share.commitZ
+	rec.added[share.Index] = share.share
+	return share, nil
+}
+
+func (rec *Recovery) decode(data []byte) (*BackupShare, error) {
+	if len(data) < 4 {
+		return nil, ErrMalformedMessage
+	}
+	body, checksum := data[:len(data)-4], data[len(data)-4:]
+	digest := sha256.Sum256(body)
+	if !bytes.Equal(digest[:4], checksum) {
+		return nil, ErrBackupChecksum
+	}
+
+	br := &wireReader{buf: body}
+	share := &BackupShare{}
+	copy(share.KeyID[:], br.next(len(share.KeyID)))
+	share.Threshold, share.Shares, share.Index = int(br.uint16()), int(br.uint16()), int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if share.KeyID != rec.pk.ID() {
+		return nil, ErrBackupKey
+	}
+	if err := checkThreshold(share.Threshold, share.Shares); err != nil {
+		return nil, err
+	}
+	if share.Index < 1 || share.Index > share.Shares {
+		return nil, ErrSignerIndex
+	}
+	elements, err := decodeElements(br.buf, rec.backupFields(share.Threshold)...)
+	if err != nil {
+		return nil, err
+	}
+	share.share = SecretKey{X: elements[0], Y: elements[1], Z: elements[2]}
+	commitments := elements[3:]
+	share.commitX = commitments[:share.Threshold]
+	share.commitY = commitments[share.Threshold : 2*share.Threshold]
+	share.commitZ = commitments[2*share.Threshold:]
+	return share, nil
+}
+
+// backupFields lists the elements of a share: x_i, y_i and z_i, then the
+// commitments for each.
+func (rec *Recovery) backupFields(threshold int) []func() *pbc.Element {
+	var fields []func() *pbc.Element
+	for k := 0; k < 3; k++ {
+		fields = append(fields, rec.pairing.NewZr)
+	}
+	for k := 0; k < 3*threshold; k++ {
+		fields = append(fields, rec.pairing.NewG1)
+	}
+	return fields
+}
+
+func equalElements(a, b []*pbc.Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Needed returns how many more shares Authority needs.
+func (rec *Recovery) Needed() int {
+	if rec.commitX == nil {
+		return 1
+	}
+	if len(rec.added) >= rec.threshold {
+		return 0
+	}
+	return rec.threshold - len(rec.added)
+}
+
+// Authority interpolates x, y and z from the shares added so far and returns
+// the recovered authority once xP, yP and zP match.
+func (rec *Recovery) Authority() (*Authority, error) {
+	if needed := rec.Needed(); needed > 0 {
+		return nil, fmt.Errorf("apsi: %d more backup shares needed", needed)
+	}
+	indices := make([]int, 0, rec.threshold)
+	for index := range rec.added {
+		if len(indices) == rec.threshold {
+			break
+		}
+		indices = append(indices, index)
+	}
+	coefficients := lagrangeAtZero(rec.pairing, indices)
+	sk := SecretKey{X: rec.pairing.NewZr().Set0(), Y: rec.pairing.NewZr().Set0(), Z: rec.pairing.NewZr().Set0()}
+	for k, index := range indices {
+		share := rec.added[index]
+		sk.X.Add(sk.X, rec.pairing.NewZr().Mul(share.X, coefficients[k]))
+		sk.Y.Add(sk.Y, rec.pairing.NewZr().Mul(share.Y, coefficients[k]))
+		sk.Z.Add(sk.Z, rec.pairing.NewZr().Mul(share.Z, coefficients[k]))
+	}
+	if err := checkKeyPair(rec.pairing, rec.pk, sk); err != nil {
+		return nil, err
+	}
+	return &Authority{params: rec.params, pairing: rec.pairing, pk: rec.pk, sk: sk}, nil
+}
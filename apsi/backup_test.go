@@ -0,0 +1,105 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/pem"
+	"testing"
+)
+
+func writeShare(t *testing.T, share *BackupShare) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := share.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func testRecovery(t *testing.T, authority *Authority) *Recovery {
+	t.Helper()
+	var public bytes.Buffer
+	if err := authority.WritePublicKey(&public); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewRecovery(&public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestBackupRecoversWithBadShare(t *testing.T) {
+	_, authority := NewAuthority()
+	shares, err := authority.Backup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := testRecovery(t, authority)
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, shares[0]))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, shares[0]))); err != ErrBackupDuplicate {
+		t.Errorf("Add of the same share = %v, want %v", err, ErrBackupDuplicate)
+	}
+
+	// The second custodian's share was altered, checksum and all: it is
+	// named as bad and the third one is used instead.
+	bad := *shares[1]
+	bad.share.Y = authority.Pairing().NewZr().Rand()
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, &bad))); err != ErrBackupShare {
+		t.Errorf("Add of an altered share = %v, want %v", err, ErrBackupShare)
+	}
+	if rec.Needed() != 1 {
+		t.Errorf("Needed = %d after a bad share, want 1", rec.Needed())
+	}
+
+	// A mistyped paper copy fails its checksum.
+	block, _ := pem.Decode(writeShare(t, shares[2]))
+	block.Bytes[len(block.Bytes)/2] ^= 1
+	if _, err := rec.Add(bytes.NewReader(pem.EncodeToMemory(block))); err != ErrBackupChecksum {
+		t.Errorf("Add of a mistyped share = %v, want %v", err, ErrBackupChecksum)
+	}
+
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, shares[2]))); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := rec.Authority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recovered.sk.X.Equals(authority.sk.X) || !recovered.sk.Y.Equals(authority.sk.Y) ||
+		!recovered.sk.Z.Equals(authority.sk.Z) {
+		t.Error("recovered key differs from the original")
+	}
+}
+
+func TestBackupRejectsForeignShares(t *testing.T) {
+	_, authority := NewAuthority()
+	first, err := authority.Backup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := authority.Backup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other := NewAuthority()
+	foreign, err := other.Backup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := testRecovery(t, authority)
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, foreign[0]))); err != ErrBackupKey {
+		t.Errorf("Add of another key's share = %v, want %v", err, ErrBackupKey)
+	}
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, first[0]))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Add(bytes.NewReader(writeShare(t, second[1]))); err != ErrBackupMismatch {
+		t.Errorf("Add of another backup's share = %v, want %v", err, ErrBackupMismatch)
+	}
+	if _, err := rec.Authority(); err == nil {
+		t.Error("Authority succeeded with one share of two")
+	}
+}
@@ -0,0 +1,112 @@
+package apsi
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sort"
+
+	"github.com/Nik-U/pbc"
+)
+
+// batchWeightBits is the size of the random weights used by BatchVerify. A
+// batch containing an invalid signature passes with probability at most
+// 2^-batchWeightBits.
+const batchWeightBits = 64
+
+// BatchVerify verifies a whole set of authorizations at once and returns the
+// indices of the invalid ones, in increasing order.
+//
+// With random weights d_i it checks the single equation
+//
+//	e(sum d_i sig_i, P) == e(sum d_i H(elt_i), xP)
+//
+// which costs two pairings regardless of the size of the set. If the check
+// fails, the set is bisected and each half is checked the same way until the
+// bad signatures are isolated, so k bad signatures cost O(k log n) pairings.
+func BatchVerify(pairing *pbc.Pairing, pk PublicKey, set RawElementSlice, signatures []*pbc.Element, party Party, opts ...AuthorizeOption) ([]int, error) {
+	if len(set) != len(signatures) {
+		return nil, ErrSignatureCount
+	}
+	partyKey, err := pk.forParty(party)
+	if err != nil {
+		return nil, err
+	}
+
+	// Weight every signature and hash once up front; the checks below only
+	// need sums of these.
+	b := newBinding(opts)
+	var invalid []int
+	var indices []int
+	weightedSignatures := make([]*pbc.Element, len(set))
+	weightedHashes := make([]*pbc.Element, len(set))
+	for i, elt := range set {
+		if signatures[i] == nil {
+			invalid = append(invalid, i)
+			continue
+		}
+		weight, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), batchWeightBits))
+		if err != nil {
+			return nil, err
+		}
+		weightedSignatures[i] = pairing.NewG1().MulBig(signatures[i], weight)
+		weightedHashes[i] = pairing.NewG1().MulBig(b.hash(pairing, elt), weight)
+		indices = append(indices, i)
+	}
+
+	batch := &verificationBatch{
+		pairing:            pairing,
+		P:                  pk.P,
+		partyKey:           partyKey,
+		weightedSignatures: weightedSignatures,
+		weightedHashes:     weightedHashes,
+	}
+	if !batch.check(indices) {
+		invalid = append(invalid, batch.bisect(indices)...)
+	}
+	sort.Ints(invalid)
+	return invalid, nil
+}
+
+type verificationBatch struct {
+	pairing  *pbc.Pairing
+	P        *pbc.Element
+	partyKey *pbc.Element
+
+	weightedSignatures []*pbc.Element
+	weightedHashes     []*pbc.Element
+}
+
+// check runs the batch equation over the signatures at indices.
+func (batch *verificationBatch) check(indices []int) bool {
+	sumSignatures := batch.pairing.NewG1().Set0()
+	sumHashes := batch.pairing.NewG1().Set0()
+	for _, i := range indices {
+		sumSignatures.Add(sumSignatures, batch.weightedSignatures[i])
+		sumHashes.Add(sumHashes, batch.weightedHashes[i])
+	}
+
+	e_sig_P := batch.pairing.NewGT().Pair(sumSignatures, batch.P)
+	e_H_xP := batch.pairing.NewGT().Pair(sumHashes, batch.partyKey)
+	return e_sig_P.Equals(e_H_xP)
+}
+
+// bisect returns the indices whose signatures are invalid, given that the
+// batch over indices is already known to fail. When the left half passes,
+// the right half must be the one failing and is not checked again.
+func (batch *verificationBatch) bisect(indices []int) []int {
+	if len(indices) == 1 {
+		return indices
+	}
+	mid := len(indices) / 2
+	left, right := indices[:mid], indices[mid:]
+
+	var invalid []int
+	if !batch.check(left) {
+		invalid = batch.bisect(left)
+		if !batch.check(right) {
+			invalid = append(invalid, batch.bisect(right)...)
+		}
+		return invalid
+	}
+	return batch.bisect(right)
+}
@@ -0,0 +1,44 @@
+package apsi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchVerifyBisects(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	set := make(RawElementSlice, 9)
+	for i := range set {
+		set[i] = RawElement{0, 0, 0, byte(i)}
+	}
+	_, signatures, err := authority.AuthorizeSet(set, ClientParty, AtEpoch(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := BatchVerify(pairing, pk, set, signatures, ClientParty, AtEpoch(3))
+	if err != nil || invalid != nil {
+		t.Fatalf("BatchVerify of a valid set = %v, %v", invalid, err)
+	}
+	if invalid, _ := BatchVerify(pairing, pk, set, signatures, ClientParty); len(invalid) != len(set) {
+		t.Errorf("BatchVerify without the epoch found %d invalid, want %d", len(invalid), len(set))
+	}
+
+	// Swapped signatures are each invalid for their element, even though
+	// the batch's unweighted sums would still balance.
+	signatures[1], signatures[2] = signatures[2], signatures[1]
+	signatures[5] = pairing.NewG1().Rand()
+	signatures[7] = nil
+	signatures[8] = pairing.NewG1().Rand()
+	invalid, err = BatchVerify(pairing, pk, set, signatures, ClientParty, AtEpoch(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 5, 7, 8}; !reflect.DeepEqual(invalid, want) {
+		t.Errorf("invalid = %v, want %v", invalid, want)
+	}
+
+	if _, err := BatchVerify(pairing, pk, set, signatures[1:], ClientParty); err != ErrSignatureCount {
+		t.Errorf("BatchVerify with a signature missing = %v, want %v", err, ErrSignatureCount)
+	}
+}
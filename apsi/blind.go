@@ -0,0 +1,372 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Blind issuance lets a client obtain an authorization without the
+// authority learning its element. The requester picks a random b and sends
+// B = bH(elt); the authority answers with kB = bkH(elt), and the requester
+// multiplies by b^-1 to get kH(elt).
+//
+// Nothing about a request can be proven to the authority. G1 has prime
+// order, so every point other than the identity is bH(elt) for some b, for
+// every elt: any request is well-formed, and anything that tied B to elt
+// would let the authority test guesses for elt and undo the blinding. Nor
+// can the epoch a requester binds inside B be checked. The authority
+// therefore never signs blind requests with x, and only signs them at all
+// once EnableBlindIssuance has been called. It signs with a blind key k
+// derived from x for the current epoch and the context the request names in
+// the clear, and publishes kP in a BlindKey signed with its document key.
+// A blindly issued authorization verifies under kP alone: a party given the
+// BlindKey with WithBlindKey runs under it, and refuses to unless it is in
+// the key's epoch and context. Whoever may send blind requests can still
+// obtain a client authorization for any element they like, but only for the
+// current epoch. The server's key is never used this way; server
+// authorizations are only issued by Authorize, where the authority sees what
+// it signs.
+//
+// A blind key replaces the client's xP, so it cannot be combined with a
+// client registry, which lists each client under the key derived from x.
+
+var (
+	// ErrBlindServer is returned when a blind request asks for a server
+	// authorization.
+	ErrBlindServer = errors.New("apsi: blind issuance is only available to clients")
+
+	// ErrBlindSignature is returned when a blind signature does not unblind
+	// to a valid authorization.
+	ErrBlindSignature = errors.New("apsi: blind signature does not unblind to a valid authorization")
+
+	// ErrBlindDisabled is returned by an authority asked to sign blind
+	// requests without EnableBlindIssuance.
+	ErrBlindDisabled = errors.New("apsi: blind issuance is not enabled")
+
+	// ErrBlindEpoch is returned by Blind when no epoch is bound. Blind
+	// signatures are made with a key for one epoch and are useless without.
+	ErrBlindEpoch = errors.New("apsi: blind requests must be bound to an epoch")
+
+	// ErrBlindContext is returned when the requests signed together name
+	// different contexts.
+	ErrBlindContext = errors.New("apsi: blind requests name different contexts")
+
+	// ErrBlindKey is returned for a blind key that is not signed by the
+	// authority or does not match the epoch and context it is used in.
+	ErrBlindKey = errors.New("apsi: blind key is invalid for this epoch and context")
+)
+
+const blindKeyDomain = "apsi-blind-key-v1"
+
+// BlindKey is the public half of the key an authority signs blind requests
+// with for one epoch and context, signed with its document key.
+type BlindKey struct {
+	KeyID   KeyID
+	Epoch   Epoch
+	Context ContextDigest
+	XP      *pbc.Element // kP
+
+	signature *pbc.Element
+}
+
+// contents encodes what a blind key's signature covers:
+//
+//	key ID (8) | epoch (8) | context digest (32) | len(kP) (2) | kP
+func (bk *BlindKey) contents() []byte {
+	b := appendUint64(append([]byte(nil), bk.KeyID[:]...), uint64(bk.Epoch))
+	b = append(b, bk.Context[:]...)
+	return appendBytes16(b, bk.XP.Bytes())
+}
+
+// Verify reports whether bk is signed by the document key of pk, the key
+// version it names.
+func (bk *BlindKey) Verify(pairing *pbc.Pairing, pk PublicKey) bool {
+	return bk.KeyID == pk.ID() && verifyDocument(pairing, pk, blindKeyDomain, bk.contents(), bk.signature)
+}
+
+// PublicKey returns pk with xP replaced by kP, which is the key blindly
+// issued authorizations verify under.
+func (bk *BlindKey) PublicKey(pk PublicKey) PublicKey {
+	pk.XP = bk.XP
+	return pk
+}
+
+// WithBlindKey gives a Client or an interaction the blind key its client
+// authorizations were issued under. It is checked against the authority's
+// public key and the interaction's epoch and context, failing with
+// ErrBlindKey, and the client then runs under it. Blind keys for other key
+// versions are ignored.
+func WithBlindKey(bk *BlindKey) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.blindKeys = append(cfg.blindKeys, bk)
+	}
+}
+
+// blindKey checks cfg's blind keys for pk and returns pk with their key in
+// place.
+func (cfg *interactionConfig) blindKey(pairing *pbc.Pairing, pk PublicKey) (PublicKey, error) {
+	blind := pk
+	for _, bk := range cfg.blindKeys {
+		if bk.KeyID != pk.ID() {
+			continue
+		}
+		if !bk.Verify(pairing, pk) || bk.Epoch != cfg.epoch || bk.Context != cfg.context {
+			return PublicKey{}, ErrBlindKey
+		}
+		blind = bk.PublicKey(blind)
+	}
+	return blind, nil
+}
+
+// EnableBlindIssuance lets the authority sign blind requests, with a key for
+// the epoch of the given period that contains the time of signing.
+// Authorities derived from it, by ForClient or Rotate, keep it enabled. Like
+// EpochOf, it panics if period is not positive.
+func (authority *Authority) EnableBlindIssuance(period time.Duration) {
+	if period <= 0 {
+		panic("apsi: non-positive epoch period")
+	}
+	authority.blindPeriod = period
+}
+
+// blindKey derives the blind key k = H("apsi-blind-key-v1" || x || epoch ||
+// context) for epoch and context and returns it with its signed BlindKey.
+func (authority *Authority) blindKey(epoch Epoch, context ContextDigest) (*pbc.Element, *BlindKey) {
+	h := sha256.New()
+	h.Write([]byte(blindKeyDomain))
+	h.Write(authority.sk.X.Bytes())
+	h.Write(appendUint64(nil, uint64(epoch)))
+	h.Write(context[:])
+	k := authority.pairing.NewZr().SetFromHash(h.Sum(nil))
+
+	bk := &BlindKey{
+		KeyID:   authority.pk.ID(),
+		Epoch:   epoch,
+		Context: context,
+		XP:      authority.pairing.NewG1().MulZn(authority.pk.P, k),
+	}
+	bk.signature = authority.signDocument(blindKeyDomain, bk.contents())
+	return k, bk
+}
+
+// BlindRequest is what the authority sees of a blind authorization request.
+type BlindRequest struct {
+	Blinded *pbc.Element  // B = bH(elt)
+	Context ContextDigest // the context bound inside B
+}
+
+// BlindingFactor is the requester's secret for one request. It must be kept
+// until the blind signature arrives and never sent to the authority.
+type BlindingFactor struct {
+	Element RawElement
+	Factor  *pbc.Element // b
+}
+
+// Blind prepares a blind authorization request for elt. opts must bind an
+// epoch, the one the authority will sign in, or Blind fails with
+// ErrBlindEpoch.
+func Blind(pairing *pbc.Pairing, elt RawElement, opts ...AuthorizeOption) (time.Duration, *BlindRequest, *BlindingFactor, error) {
+	binding := newBinding(opts)
+	if binding.epoch == NoEpoch {
+		return 0, nil, nil, ErrBlindEpoch
+	}
+
+	startTime := time.Now()
+
+	b := pairing.NewZr().Rand()
+	for b.Is0() {
+		b.Rand()
+	}
+	B := pairing.NewG1().MulZn(binding.hash(pairing, elt), b)
+
+	totalTime := time.Since(startTime)
+	request := &BlindRequest{Blinded: B, Context: binding.context}
+	return totalTime, request, &BlindingFactor{Element: elt, Factor: b}, nil
+}
+
+// BlindSet runs Blind for every element of set. The returned duration is
+// the total blinding time.
+func BlindSet(pairing *pbc.Pairing, set RawElementSlice, opts ...AuthorizeOption) (time.Duration, []*BlindRequest, []*BlindingFactor, error) {
+	var totalTime time.Duration
+	requests := make([]*BlindRequest, len(set))
+	factors := make([]*BlindingFactor, len(set))
+	for i, elt := range set {
+		blindTime, request, factor, err := Blind(pairing, elt, opts...)
+		if err != nil {
+			return totalTime, nil, nil, err
+		}
+		requests[i], factors[i] = request, factor
+		totalTime += blindTime
+	}
+	return totalTime, requests, factors, nil
+}
+
+// AuthorizeBlinded signs a blinded client request with the blind key for
+// the current epoch and the request's context, returning kB and the
+// BlindKey it verifies under. It fails with ErrBlindDisabled unless
+// EnableBlindIssuance was called, and requests for the server are refused
+// with ErrBlindServer. An audit log records an AuditBlind entry committing
+// to B.
+//
+// The epoch bound inside B is hidden from the authority. One other than the
+// current epoch yields an authorization that verifies under no key.
+func (authority *Authority) AuthorizeBlinded(request *BlindRequest, party Party) (time.Duration, *BlindKey, *pbc.Element, error) {
+	signingTime, bk, blindSignatures, err := authority.AuthorizeBlindedSet([]*BlindRequest{request}, party)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return signingTime, bk, blindSignatures[0], nil
+}
+
+// AuthorizeBlindedSet runs AuthorizeBlinded for every request, recording
+// them in the audit log together. The requests must name one context, or
+// it fails with ErrBlindContext. The returned duration is the total signing
+// time.
+func (authority *Authority) AuthorizeBlindedSet(requests []*BlindRequest, party Party) (time.Duration, *BlindKey, []*pbc.Element, error) {
+	if _, err := authority.sk.forParty(party); err != nil {
+		return 0, nil, nil, err
+	}
+	if party != ClientParty {
+		return 0, nil, nil, ErrBlindServer
+	}
+	if authority.blindPeriod <= 0 {
+		return 0, nil, nil, ErrBlindDisabled
+	}
+	if len(requests) == 0 {
+		return 0, nil, nil, ErrMalformedMessage
+	}
+	for _, request := range requests {
+		if request.Blinded == nil || request.Blinded.Is0() {
+			return 0, nil, nil, ErrMalformedMessage
+		}
+		if request.Context != requests[0].Context {
+			return 0, nil, nil, ErrBlindContext
+		}
+	}
+
+	startTime := time.Now()
+
+	k, bk := authority.blindKey(EpochOf(startTime, authority.blindPeriod), requests[0].Context)
+	blindSignatures := make([]*pbc.Element, len(requests))
+	for i, request := range requests {
+		blindSignatures[i] = authority.pairing.NewG1().MulZn(request.Blinded, k)
+	}
+
+	totalTime := time.Since(startTime)
+	if authority.audit != nil {
+		if err := authority.audit.recordBlinded(party, authority.pk.ID(), requests); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return totalTime, bk, blindSignatures, nil
+}
+
+// Unblind turns the authority's answer to a blind request into kH(elt) and
+// verifies it under bk, so that a bad answer is caught before the signature
+// is used in an interaction. bk must be signed under pk and be for the
+// epoch and context of opts, or Unblind fails with ErrBlindKey.
+func Unblind(pairing *pbc.Pairing, pk PublicKey, bk *BlindKey, factor *BlindingFactor, blindSignature *pbc.Element, party Party, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	if blindSignature == nil {
+		return 0, nil, ErrBlindSignature
+	}
+	binding := newBinding(opts)
+	if bk == nil || !bk.Verify(pairing, pk) || bk.Epoch != binding.epoch || bk.Context != binding.context {
+		return 0, nil, ErrBlindKey
+	}
+
+	startTime := time.Now()
+
+	bInverse := pairing.NewZr().Invert(factor.Factor)
+	signature := pairing.NewG1().MulZn(blindSignature, bInverse)
+
+	ok, err := Verify(pairing, bk.PublicKey(pk), factor.Element, signature, party, opts...)
+	totalTime := time.Since(startTime)
+	if err != nil {
+		return totalTime, nil, err
+	}
+	if !ok {
+		return totalTime, nil, ErrBlindSignature
+	}
+	return totalTime, signature, nil
+}
+
+// UnblindSet runs Unblind for every factor and the matching blind
+// signature, all under bk. The returned duration is the total unblinding
+// time.
+func UnblindSet(pairing *pbc.Pairing, pk PublicKey, bk *BlindKey, factors []*BlindingFactor, blindSignatures []*pbc.Element, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	if len(factors) != len(blindSignatures) {
+		return 0, nil, ErrSignatureCount
+	}
+	var totalTime time.Duration
+	signatures := make([]*pbc.Element, len(factors))
+	for i, factor := range factors {
+		unblindTime, signature, err := Unblind(pairing, pk, bk, factor, blindSignatures[i], party, opts...)
+		totalTime += unblindTime
+		if err != nil {
+			return totalTime, nil, err
+		}
+		signatures[i] = signature
+	}
+	return totalTime, signatures, nil
+}
+
+// MarshalBinary encodes the request:
+//
+//	len(B) (2) | B | context digest (32)
+func (request *BlindRequest) MarshalBinary() ([]byte, error) {
+	if request.Blinded == nil {
+		return nil, ErrMalformedMessage
+	}
+	return append(appendBytes16(nil, request.Blinded.Bytes()), request.Context[:]...), nil
+}
+
+// UnmarshalBlindRequest decodes a request produced by MarshalBinary.
+func UnmarshalBlindRequest(pairing *pbc.Pairing, data []byte) (*BlindRequest, error) {
+	r := &wireReader{buf: data}
+	blinded := r.bytes16()
+	var context ContextDigest
+	copy(context[:], r.next(len(context)))
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	B, err := decodeG1(pairing, blinded)
+	if err != nil {
+		return nil, err
+	}
+	return &BlindRequest{Blinded: B, Context: context}, nil
+}
+
+// MarshalBinary encodes the blind key as its signed contents followed by
+// len(signature) (2) | signature.
+func (bk *BlindKey) MarshalBinary() ([]byte, error) {
+	if bk.XP == nil || bk.signature == nil {
+		return nil, ErrMalformedMessage
+	}
+	return appendBytes16(bk.contents(), bk.signature.Bytes()), nil
+}
+
+// UnmarshalBlindKey decodes a blind key encoded by MarshalBinary. It does
+// not check the signature; Verify does.
+func UnmarshalBlindKey(pairing *pbc.Pairing, data []byte) (*BlindKey, error) {
+	r := &wireReader{buf: data}
+	bk := &BlindKey{}
+	copy(bk.KeyID[:], r.next(len(bk.KeyID)))
+	bk.Epoch = Epoch(r.uint64())
+	copy(bk.Context[:], r.next(len(bk.Context)))
+	key := r.bytes16()
+	signature := r.bytes16()
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	var err error
+	if bk.XP, err = decodeG1(pairing, key); err != nil {
+		return nil, err
+	}
+	if bk.signature, err = decodeG1(pairing, signature); err != nil {
+		return nil, err
+	}
+	return bk, nil
+}
@@ -0,0 +1,137 @@
+package apsi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// blindIssue runs Blind and AuthorizeBlindedSet for set at epoch.
+func blindIssue(t *testing.T, authority *Authority, set RawElementSlice, epoch Epoch) (*BlindKey, []*BlindingFactor, []*pbc.Element) {
+	t.Helper()
+	_, requests, factors, err := BlindSet(authority.Pairing(), set, AtEpoch(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bk, blindSignatures, err := authority.AuthorizeBlindedSet(requests, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bk, factors, blindSignatures
+}
+
+func TestBlindRoundTrip(t *testing.T) {
+	_, authority := NewAuthority()
+	authority.EnableBlindIssuance(time.Hour)
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	epoch := EpochOf(time.Now(), time.Hour)
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}}
+
+	bk, factors, blindSignatures := blindIssue(t, authority, set, epoch)
+	data, err := bk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bk, err = UnmarshalBlindKey(pairing, data); err != nil {
+		t.Fatal(err)
+	}
+	_, signatures, err := UnblindSet(pairing, pk, bk, factors, blindSignatures, ClientParty, AtEpoch(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Verify(pairing, pk, set[0], signatures[0], ClientParty, AtEpoch(epoch)); err != nil || ok {
+		t.Errorf("blind signature verifies under x: %v, %v", ok, err)
+	}
+
+	_, serverSignatures, err := authority.AuthorizeSet(set[1:], ServerParty, AtEpoch(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, set[1:], serverSignatures, WithEpoch(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pairing, pk, set, signatures, WithEpoch(epoch), WithBlindKey(bk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intersection, err := runSession(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := set[1:]; !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+
+	// Without the blind key, the client drops every signature as invalid.
+	client, err = NewClient(pairing, pk, set, signatures, WithEpoch(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intersection, err = runSession(client, server); err != nil || len(intersection) != 0 {
+		t.Errorf("intersection without the blind key = %x, %v", intersection, err)
+	}
+}
+
+func TestBlindIssuanceDisabled(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing := authority.Pairing()
+	elt := RawElement{0, 0, 0, 1}
+	if _, _, _, err := Blind(pairing, elt); err != ErrBlindEpoch {
+		t.Errorf("Blind without an epoch = %v, want %v", err, ErrBlindEpoch)
+	}
+	_, request, _, err := Blind(pairing, elt, AtEpoch(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := authority.AuthorizeBlinded(request, ClientParty); err != ErrBlindDisabled {
+		t.Errorf("AuthorizeBlinded = %v, want %v", err, ErrBlindDisabled)
+	}
+
+	authority.EnableBlindIssuance(time.Hour)
+	if _, _, _, err := authority.AuthorizeBlinded(request, ServerParty); err != ErrBlindServer {
+		t.Errorf("AuthorizeBlinded for the server = %v, want %v", err, ErrBlindServer)
+	}
+	_, other, _, err := Blind(pairing, elt, AtEpoch(1), InContext(Context{Case: "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := authority.AuthorizeBlindedSet([]*BlindRequest{request, other}, ClientParty); err != ErrBlindContext {
+		t.Errorf("AuthorizeBlindedSet across contexts = %v, want %v", err, ErrBlindContext)
+	}
+}
+
+func TestBlindKeyBoundToEpoch(t *testing.T) {
+	_, authority := NewAuthority()
+	authority.EnableBlindIssuance(time.Hour)
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	epoch := EpochOf(time.Now(), time.Hour)
+	set := RawElementSlice{{0, 0, 0, 1}}
+
+	// A requester binding a later epoch still gets the current epoch's key,
+	// which does not unblind its request.
+	bk, factors, blindSignatures := blindIssue(t, authority, set, epoch+1)
+	if bk.Epoch != epoch {
+		t.Errorf("blind key epoch = %d, want %d", bk.Epoch, epoch)
+	}
+	if _, _, err := UnblindSet(pairing, pk, bk, factors, blindSignatures, ClientParty, AtEpoch(epoch+1)); err != ErrBlindKey {
+		t.Errorf("UnblindSet in another epoch = %v, want %v", err, ErrBlindKey)
+	}
+	if _, err := NewClient(pairing, pk, nil, nil, WithEpoch(epoch+1), WithBlindKey(bk)); err != ErrBlindKey {
+		t.Errorf("NewClient in another epoch = %v, want %v", err, ErrBlindKey)
+	}
+	if _, err := NewClient(pairing, pk, nil, nil, WithEpoch(epoch), WithContext(Context{Case: "1"}), WithBlindKey(bk)); err != ErrBlindKey {
+		t.Errorf("NewClient in another context = %v, want %v", err, ErrBlindKey)
+	}
+
+	forged := *bk
+	forged.XP = pairing.NewG1().Rand()
+	if forged.Verify(pairing, pk) {
+		t.Error("a blind key with another kP verifies")
+	}
+	if _, err := NewClient(pairing, pk, nil, nil, WithEpoch(epoch), WithBlindKey(&forged)); err != ErrBlindKey {
+		t.Errorf("NewClient with a forged blind key = %v, want %v", err, ErrBlindKey)
+	}
+}
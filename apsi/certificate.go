@@ -0,0 +1,284 @@
+package apsi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// An authorization certificate wraps the bare signature from Authorize with
+// what it was issued for: a commitment to the element, the party, the key
+// version, the epoch and context it is bound to, who issued it and when,
+// and when it expires. The authority seals all of it with its document
+// signature, so none of it can be changed by whoever holds the
+// certificate. The element itself is left out, since the holder already
+// has it, and the commitment lets whoever is given the element check that
+// it is the certified one. The commitment does not hide the element: the
+// salt travels in the certificate and elements are only 32 bits, so trying
+// every element finds it, as checking the signature against every element
+// would. A certificate reveals its element to anyone who holds it.
+
+var (
+	// ErrCertificate is returned for a certificate whose seal or
+	// authorization does not verify.
+	ErrCertificate = errors.New("apsi: authorization certificate is invalid")
+
+	// ErrCertificateExpired is returned for a certificate past its expiry.
+	ErrCertificateExpired = errors.New("apsi: authorization certificate has expired")
+
+	// ErrCertificateElement is returned when a certificate's commitment does
+	// not open to the given element.
+	ErrCertificateElement = errors.New("apsi: authorization certificate is for another element")
+)
+
+// CertificateInfo is what the authority states about an authorization in
+// its certificate. Epoch and Context are also what the authorization is
+// bound to. A zero Expires never expires.
+type CertificateInfo struct {
+	Issuer  string
+	Epoch   Epoch
+	Context Context
+	Expires time.Time
+}
+
+// authorizeOptions returns the options the certified authorization was
+// issued with.
+func (info CertificateInfo) authorizeOptions() []AuthorizeOption {
+	var opts []AuthorizeOption
+	if info.Epoch != NoEpoch {
+		opts = append(opts, AtEpoch(info.Epoch))
+	}
+	if info.Context != (Context{}) {
+		opts = append(opts, InContext(info.Context))
+	}
+	return opts
+}
+
+// AuthorizationCertificate is a sealed authorization of one element.
+type AuthorizationCertificate struct {
+	CertificateInfo
+
+	Commitment [32]byte
+	Salt       [16]byte
+	Party      Party
+	KeyID      KeyID
+	Issued     time.Time
+	Signature  *pbc.Element
+
+	seal *pbc.Element
+}
+
+// commitElement computes SHA-256("apsi-element-commitment-v1" || salt || elt).
+func commitElement(salt [16]byte, elt RawElement) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("apsi-element-commitment-v1"))
+	h.Write(salt[:])
+	h.Write(elt[:])
+	var commitment [32]byte
+	copy(commitment[:], h.Sum(nil))
+	return commitment
+}
+
+// Certify seals signature, an authorization of elt for party issued with
+// the binding info names, into a certificate. It checks the authorization
+// first and fails with ErrCertificate if it is not the authority's.
+func (authority *Authority) Certify(elt RawElement, signature *pbc.Element, party Party, info CertificateInfo) (*AuthorizationCertificate, error) {
+	ok, err := Verify(authority.pairing, authority.pk, elt, signature, party, info.authorizeOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrCertificate
+	}
+	if len(info.Issuer) > 0xffff {
+		return nil, errors.New("apsi: certificate issuer is too long")
+	}
+	cert := &AuthorizationCertificate{
+		CertificateInfo: info,
+		Party:           party,
+		KeyID:           authority.pk.ID(),
+		Issued:          time.Now().Truncate(time.Second),
+		Signature:       signature,
+	}
+	if !info.Expires.IsZero() {
+		cert.Expires = info.Expires.Truncate(time.Second)
+	}
+	if _, err := io.ReadFull(rand.Reader, cert.Salt[:]); err != nil {
+		return nil, err
+	}
+	cert.Commitment = commitElement(cert.Salt, elt)
+	cert.seal = authority.signDocument(certificateDomain, cert.contents())
+	return cert, nil
+}
+
+// IssueCertificate authorizes elt for party as info says and returns the
+// time it took along with the certificate.
+func (authority *Authority) IssueCertificate(elt RawElement, party Party, info CertificateInfo) (time.Duration, *AuthorizationCertificate, error) {
+	signingTime, signature, err := authority.Authorize(elt, party, info.authorizeOptions()...)
+	if err != nil {
+		return 0, nil, err
+	}
+	cert, err := authority.Certify(elt, signature, party, info)
+	return signingTime, cert, err
+}
+
+// Opens reports whether the certificate is for elt.
+func (cert *AuthorizationCertificate) Opens(elt RawElement) bool {
+	return commitElement(cert.Salt, elt) == cert.Commitment
+}
+
+// AuthorizeOptions returns the options to verify or use the certified
+// authorization with.
+func (cert *AuthorizationCertificate) AuthorizeOptions() []AuthorizeOption {
+	return cert.authorizeOptions()
+}
+
+// Verify checks that the certificate is for elt, that it was sealed under
+// pk, that it has not expired at now and that the authorization inside is
+// valid.
+func (cert *AuthorizationCertificate) Verify(pairing *pbc.Pairing, pk PublicKey, elt RawElement, now time.Time) error {
+	if !cert.Opens(elt) {
+		return ErrCertificateElement
+	}
+	if cert.KeyID != pk.ID() {
+		return ErrUnknownKey
+	}
+	if !verifyDocument(pairing, pk, certificateDomain, cert.contents(), cert.seal) {
+		return ErrCertificate
+	}
+	if !cert.Expires.IsZero() && !now.Before(cert.Expires) {
+		return ErrCertificateExpired
+	}
+	ok, err := Verify(pairing, pk, elt, cert.Signature, cert.Party, cert.AuthorizeOptions()...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCertificate
+	}
+	return nil
+}
+
+const certificateDomain = "apsi-authorization-certificate-v1"
+
+// contents encodes what the seal covers:
+//
+//	commitment (32) | salt (16) | party (1) | key ID (8) | epoch (8) |
+//	len(case) (2) | case | len(purpose) (2) | purpose |
+//	len(counterparty) (2) | counterparty | len(issuer) (2) | issuer |
+//	issued (8) | expires (8) | len(signature) (2) | signature
+//
+// with times in Unix seconds and a zero expiry for none.
+func (cert *AuthorizationCertificate) contents() []byte {
+	b := append(append([]byte(nil), cert.Commitment[:]...), cert.Salt[:]...)
+	b = append(b, byte(cert.Party))
+	b = append(b, cert.KeyID[:]...)
+	b = appendUint64(b, uint64(cert.Epoch))
+	b = appendBytes16(b, []byte(cert.Context.Case))
+	b = appendBytes16(b, []byte(cert.Context.Purpose))
+	b = appendBytes16(b, []byte(cert.Context.Counterparty))
+	b = appendBytes16(b, []byte(cert.Issuer))
+	b = appendUint64(b, uint64(cert.Issued.Unix()))
+	var expires uint64
+	if !cert.Expires.IsZero() {
+		expires = uint64(cert.Expires.Unix())
+	}
+	b = appendUint64(b, expires)
+	return appendBytes16(b, cert.Signature.Bytes())
+}
+
+// MarshalBinary encodes the certificate as its sealed contents followed by
+// len(seal) (2) | seal.
+func (cert *AuthorizationCertificate) MarshalBinary() ([]byte, error) {
+	if cert.Signature == nil || cert.seal == nil {
+		return nil, ErrCertificate
+	}
+	return appendBytes16(cert.contents(), cert.seal.Bytes()), nil
+}
+
+// UnmarshalAuthorizationCertificate decodes a certificate encoded by
+// MarshalBinary. It does not check the seal; Verify does.
+func UnmarshalAuthorizationCertificate(pairing *pbc.Pairing, data []byte) (*AuthorizationCertificate, error) {
+	r := &wireReader{buf: data}
+	cert := &AuthorizationCertificate{}
+	copy(cert.Commitment[:], r.next(len(cert.Commitment)))
+	copy(cert.Salt[:], r.next(len(cert.Salt)))
+	party := r.next(1)
+	copy(cert.KeyID[:], r.next(len(cert.KeyID)))
+	cert.Epoch = Epoch(r.uint64())
+	cert.Context.Case = string(r.bytes16())
+	cert.Context.Purpose = string(r.bytes16())
+	cert.Context.Counterparty = string(r.bytes16())
+	cert.Issuer = string(r.bytes16())
+	issued, expires := r.uint64(), r.uint64()
+	signature, seal := r.bytes16(), r.bytes16()
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	cert.Party = Party(party[0])
+	if cert.Party != ClientParty && cert.Party != ServerParty {
+		return nil, ErrUnknownParty
+	}
+	cert.Issued = time.Unix(int64(issued), 0)
+	if expires != 0 {
+		cert.Expires = time.Unix(int64(expires), 0)
+	}
+	var err error
+	if cert.Signature, err = decodeG1(pairing, signature); err != nil {
+		return nil, err
+	}
+	if cert.seal, err = decodeG1(pairing, seal); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// A certificate file holds one "APSI AUTHORIZATION CERTIFICATE" block per
+// certificate.
+const pemCertificate = "APSI AUTHORIZATION CERTIFICATE"
+
+// WriteCertificates writes certs one block each.
+func WriteCertificates(w io.Writer, certs []*AuthorizationCertificate) error {
+	for _, cert := range certs {
+		data, err := cert.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := pemEncode(w, pemCertificate, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadCertificates reads every certificate block in a file written by
+// WriteCertificates, skipping blocks of other types.
+func ReadCertificates(r io.Reader, pairing *pbc.Pairing) ([]*AuthorizationCertificate, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*AuthorizationCertificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != pemCertificate {
+			continue
+		}
+		cert, err := UnmarshalAuthorizationCertificate(pairing, block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
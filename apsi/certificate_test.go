@@ -0,0 +1,70 @@
+package apsi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertificateRoundTrip(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	elt := RawElement{0, 0, 0, 1}
+	expires := time.Now().Add(time.Hour)
+	info := CertificateInfo{Issuer: "court", Epoch: 4, Context: Context{Case: "17"}, Expires: expires}
+	_, cert, err := authority.IssueCertificate(elt, ClientParty, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cert.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = UnmarshalAuthorizationCertificate(pairing, data); err != nil {
+		t.Fatal(err)
+	}
+	if cert.Issuer != info.Issuer || cert.Epoch != info.Epoch || cert.Context != info.Context ||
+		cert.Expires.Unix() != expires.Unix() || cert.KeyID != pk.ID() {
+		t.Errorf("certificate read back = %+v", cert.CertificateInfo)
+	}
+	if err := cert.Verify(pairing, pk, elt, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Verify(pairing, pk, elt, cert.Signature, ClientParty, cert.AuthorizeOptions()...); err != nil || !ok {
+		t.Errorf("certified authorization does not verify with its options: %v, %v", ok, err)
+	}
+
+	if err := cert.Verify(pairing, pk, RawElement{0, 0, 0, 2}, time.Now()); err != ErrCertificateElement {
+		t.Errorf("Verify for another element = %v, want %v", err, ErrCertificateElement)
+	}
+	if err := cert.Verify(pairing, pk, elt, expires.Add(time.Second)); err != ErrCertificateExpired {
+		t.Errorf("Verify after expiry = %v, want %v", err, ErrCertificateExpired)
+	}
+	_, other := NewAuthority()
+	if err := cert.Verify(other.Pairing(), other.PublicKey(), elt, time.Now()); err != ErrUnknownKey {
+		t.Errorf("Verify under another key = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// The seal covers the metadata: a later epoch breaks it.
+	cert.Epoch++
+	if err := cert.Verify(pairing, pk, elt, time.Now()); err != ErrCertificate {
+		t.Errorf("Verify of an altered certificate = %v, want %v", err, ErrCertificate)
+	}
+}
+
+func TestCertifyChecksAuthorization(t *testing.T) {
+	_, authority := NewAuthority()
+	elt := RawElement{0, 0, 0, 1}
+	_, signature, err := authority.Authorize(elt, ServerParty, AtEpoch(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authority.Certify(elt, signature, ServerParty, CertificateInfo{Epoch: 5}); err != ErrCertificate {
+		t.Errorf("Certify for another epoch = %v, want %v", err, ErrCertificate)
+	}
+	if _, err := authority.Certify(elt, signature, ClientParty, CertificateInfo{Epoch: 4}); err != ErrCertificate {
+		t.Errorf("Certify for another party = %v, want %v", err, ErrCertificate)
+	}
+	if _, err := authority.Certify(elt, signature, ServerParty, CertificateInfo{Epoch: 4}); err != nil {
+		t.Errorf("Certify = %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package apsi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Client holds the client's set, its authorizations xH(c_i) and, while a
+// session is in progress, the ephemeral r. A client may hold authorizations
+// under several key versions, one set per version.
+type Client struct {
+	pairing *pbc.Pairing
+	cfg     *interactionConfig
+	keys    []clientKey
+
+	epoch      Epoch
+	revocation uint64
+
+	// Timeout bounds the reading or writing of each frame in a session run
+	// by Query, so an unresponsive server does not hang the client. Zero
+	// means DefaultFrameTimeout; a negative Timeout disables it.
+	Timeout time.Duration
+}
+
+// clientKey is the client's set under one key version.
+type clientKey struct {
+	id         KeyID
+	pk         PublicKey
+	set        RawElementSlice
+	signatures []*pbc.Element
+
+	ryP *pbc.Element
+}
+
+// NewClient returns a client for set, where signatures[i] authorizes set[i].
+// Options apply to the client's own signatures as they would in an
+// interaction; WithEpoch and WithContext also make every session announce
+// the epoch and the context.
+func NewClient(pairing *pbc.Pairing, pk PublicKey, set RawElementSlice, signatures []*pbc.Element, opts ...InteractionOption) (*Client, error) {
+	cfg := newInteractionConfig(opts)
+	if cfg.clientID != "" {
+		if err := cfg.clientID.check(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.checkRevocationList(pairing, pk); err != nil {
+		return nil, err
+	}
+	client := &Client{
+		pairing:    pairing,
+		cfg:        cfg,
+		epoch:      cfg.epoch,
+		revocation: cfg.revocationVersion(),
+	}
+	if err := client.AddKeyVersion(pk, set, signatures); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// AddKeyVersion gives the client set authorized under another version of
+// the authority's keys, such as the one it is moving to during a rotation.
+// Sessions offer the versions in the order they were added and run under
+// the first one the server also holds.
+func (client *Client) AddKeyVersion(pk PublicKey, set RawElementSlice, signatures []*pbc.Element) error {
+	pk, set, signatures, err := client.cfg.prepareParty(client.pairing, pk, set, signatures, ClientParty)
+	if err != nil {
+		return err
+	}
+	id := pk.ID()
+	for _, key := range client.keys {
+		if key.id == id {
+			return fmt.Errorf("apsi: client already holds key version %s", id)
+		}
+	}
+	client.keys = append(client.keys, clientKey{id: id, pk: pk, set: set, signatures: signatures})
+	return nil
+}
+
+// Blind starts a session by picking a fresh r for every key version and
+// returning rxP for each of them, with a proof of each under AsClient.
+// Calling Blind again abandons any session in progress.
+func (client *Client) Blind() (time.Duration, *BlindingMessage) {
+	startTime := time.Now()
+
+	msg := &BlindingMessage{Epoch: client.epoch, Context: client.cfg.context, RevocationVersion: client.revocation}
+	for i := range client.keys {
+		key := &client.keys[i]
+		r := client.pairing.NewZr()
+		rxP := client.pairing.NewG1()
+		ryP := client.pairing.NewG1()
+
+		r.Rand()
+		rxP.MulZn(key.pk.XP, r)
+		ryP.MulZn(key.pk.YP, r)
+
+		key.ryP = ryP
+		var proof *KeyProof
+		if client.cfg.clientID != "" {
+			proof = proveKey(client.pairing, client.cfg.clientID, key.pk.XP, rxP, r)
+		}
+		if i == 0 {
+			msg.RxP, msg.KeyID, msg.Proof = rxP, key.id, proof
+		} else {
+			msg.Alternates = append(msg.Alternates, KeyBlinding{KeyID: key.id, RxP: rxP, Proof: proof})
+		}
+	}
+	msg.ClientID = client.cfg.clientID
+
+	totalTime := time.Since(startTime)
+	return totalTime, msg
+}
+
+// Intersect finishes the session started by Blind. It computes
+// u_i = e(xH(c_i), ryP) for every client element under the key version msg
+// names and returns the elements whose tag appears in msg.
+func (client *Client) Intersect(msg *TagSetMessage) (time.Duration, RawElementSlice, error) {
+	if client.keys[0].ryP == nil {
+		return 0, nil, ErrNoSession
+	}
+	if msg == nil || msg.KeyID == (KeyID{}) {
+		client.endSession()
+		return 0, nil, ErrMalformedMessage
+	}
+	key := &client.keys[0]
+	for i := range client.keys {
+		if client.keys[i].id == msg.KeyID {
+			key = &client.keys[i]
+		}
+	}
+	if key.id != msg.KeyID {
+		client.endSession()
+		return 0, nil, ErrUnknownKey
+	}
+	ryP := key.ryP
+	client.endSession()
+
+	startTime := time.Now()
+
+	serverTags := make(map[Tag]bool, len(msg.Tags))
+	for _, tag := range msg.Tags {
+		serverTags[tag] = true
+	}
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+	var intersection RawElementSlice
+	e_sig_ryP := client.pairing.NewGT()
+	for i, signature := range key.signatures {
+		e_sig_ryP.Pair(signature, ryP)
+		if serverTags[tagOf(e_sig_ryP)] {
+			intersection = append(intersection, key.set[i])
+		}
+	}
+
+	totalTime := time.Since(startTime)
+	err := client.cfg.recordSession(AuditEntry{
+		Party:      ClientParty,
+		KeyID:      key.id,
+		ClientID:   client.cfg.clientID,
+		ClientSize: len(key.set),
+		ServerSize: len(msg.Tags),
+		Matches:    len(intersection),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
+
+func (client *Client) endSession() {
+	for i := range client.keys {
+		client.keys[i].ryP = nil
+	}
+}
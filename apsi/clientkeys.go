@@ -0,0 +1,296 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/Nik-U/pbc"
+)
+
+// By default every client is authorized under the one x, so cutting off a
+// client means rotating everyone's keys. With per-client keys, client i is
+// authorized under its own x_i, derived from x and i, and holds the public
+// key (P, x_iP, yP). The authority publishes a signed registry of the
+// x_iP, and a server given the registry answers only clients in it. A
+// client names itself in every session and proves that its rxP is a
+// multiple of its own x_iP, so a client removed from the registry cannot
+// borrow another client's entry.
+
+var (
+	// ErrClientID is returned for an empty or overlong client ID.
+	ErrClientID = errors.New("apsi: client ID must be 1 to 255 bytes")
+
+	// ErrUnknownClient is returned when a client is not in the registry.
+	ErrUnknownClient = errors.New("apsi: client is not registered")
+
+	// ErrClientProof is returned when a client's proof that rxP was
+	// computed from its own key fails.
+	ErrClientProof = errors.New("apsi: client key proof is invalid")
+
+	// ErrRegistrySignature is returned when a client registry is not signed
+	// by the authority.
+	ErrRegistrySignature = errors.New("apsi: client registry signature is invalid")
+)
+
+// ClientID names a client with its own authorization key.
+type ClientID string
+
+func (id ClientID) check() error {
+	if len(id) == 0 || len(id) > 255 {
+		return ErrClientID
+	}
+	return nil
+}
+
+// ForClient returns the authority as client id sees it: the same y, with x
+// replaced by x_i = H(x || id). Its Authorize and AuthorizeSet issue client
+// authorizations that only match under id's key, and its PublicKey is the
+// key to hand to that client.
+func (authority *Authority) ForClient(id ClientID) (*Authority, error) {
+	if err := id.check(); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte("apsi-client-key-v1"))
+	h.Write(authority.sk.X.Bytes())
+	h.Write([]byte(id))
+	x := authority.pairing.NewZr().SetFromHash(h.Sum(nil))
+	return &Authority{
+		params:  authority.params,
+		pairing: authority.pairing,
+		pk: PublicKey{
+			P:  authority.pk.P,
+			XP: authority.pairing.NewG1().MulZn(authority.pk.P, x),
+			YP: authority.pk.YP,
+			ZP: authority.pk.ZP,
+		},
+		sk:    SecretKey{X: x, Y: authority.sk.Y, Z: authority.sk.Z},
+		audit: authority.audit,
+
+		blindPeriod: authority.blindPeriod,
+	}, nil
+}
+
+// AsClient makes a Client name itself as id in every session and prove
+// that its blindings come from its own key. In a *Interaction method it runs
+// the client side under id's key.
+func AsClient(id ClientID) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.clientID = id
+	}
+}
+
+// WithClientRegistry makes a Server answer only the clients in registry.
+// Give one registry per key version the server holds; a client that
+// negotiates a version without one is refused.
+func WithClientRegistry(registry *ClientRegistry) InteractionOption {
+	return func(cfg *interactionConfig) {
+		if cfg.registries == nil {
+			cfg.registries = make(map[KeyID]*ClientRegistry)
+		}
+		cfg.registries[registry.KeyID] = registry
+	}
+}
+
+// ClientRegistry is the authority's signed list of client keys x_iP for one
+// key version.
+type ClientRegistry struct {
+	KeyID     KeyID
+	Version   uint64
+	keys      map[ClientID]*pbc.Element
+	signature *pbc.Element
+}
+
+// NewClientRegistry returns an empty registry for the authority's key
+// version, signed at version 0.
+func (authority *Authority) NewClientRegistry() *ClientRegistry {
+	registry := &ClientRegistry{
+		KeyID: authority.pk.ID(),
+		keys:  make(map[ClientID]*pbc.Element),
+	}
+	authority.signRegistry(registry)
+	return registry
+}
+
+// Lookup returns client id's key x_iP.
+func (registry *ClientRegistry) Lookup(id ClientID) (*pbc.Element, error) {
+	key, ok := registry.keys[id]
+	if !ok {
+		return nil, ErrUnknownClient
+	}
+	return key, nil
+}
+
+// Clients returns the registered client IDs in sorted order.
+func (registry *ClientRegistry) Clients() []ClientID {
+	ids := make([]ClientID, 0, len(registry.keys))
+	for id := range registry.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids
+}
+
+// RegisterClient adds client id's key to registry and signs the new
+// version.
+func (authority *Authority) RegisterClient(registry *ClientRegistry, id ClientID) error {
+	if registry.KeyID != authority.pk.ID() {
+		return ErrKeyMismatch
+	}
+	client, err := authority.ForClient(id)
+	if err != nil {
+		return err
+	}
+	registry.keys[id] = client.pk.XP
+	registry.Version++
+	authority.signRegistry(registry)
+	return nil
+}
+
+// RemoveClient takes client id out of registry and signs the new version.
+// Other clients' keys are untouched.
+func (authority *Authority) RemoveClient(registry *ClientRegistry, id ClientID) error {
+	if registry.KeyID != authority.pk.ID() {
+		return ErrKeyMismatch
+	}
+	if _, ok := registry.keys[id]; !ok {
+		return ErrUnknownClient
+	}
+	delete(registry.keys, id)
+	registry.Version++
+	authority.signRegistry(registry)
+	return nil
+}
+
+const clientRegistryDomain = "apsi-client-registry-v1"
+
+func (authority *Authority) signRegistry(registry *ClientRegistry) {
+	registry.signature = authority.signDocument(clientRegistryDomain, registry.contents())
+}
+
+// Verify checks that the authority signed registry under pk, which must be
+// the key version the registry names.
+func (registry *ClientRegistry) Verify(pairing *pbc.Pairing, pk PublicKey) bool {
+	return registry.KeyID == pk.ID() &&
+		verifyDocument(pairing, pk, clientRegistryDomain, registry.contents(), registry.signature)
+}
+
+// contents encodes what the signature covers:
+//
+//	key ID (8) | version (8) | count (4) | entries
+//
+// with each entry len(id) (1) | id | len(x_iP) (2) | x_iP, in order of id.
+func (registry *ClientRegistry) contents() []byte {
+	b := append([]byte(nil), registry.KeyID[:]...)
+	b = appendUint64(b, registry.Version)
+	b = appendUint32(b, uint32(len(registry.keys)))
+	for _, id := range registry.Clients() {
+		b = append(append(b, byte(len(id))), id...)
+		b = appendBytes16(b, registry.keys[id].Bytes())
+	}
+	return b
+}
+
+// A registry file holds one "APSI CLIENT REGISTRY" block: the signed
+// contents followed by the signature as a 2-byte length and PBC encoding.
+const pemClientRegistry = "APSI CLIENT REGISTRY"
+
+// Write writes the signed registry.
+func (registry *ClientRegistry) Write(w io.Writer) error {
+	return pemEncode(w, pemClientRegistry, appendBytes16(registry.contents(), registry.signature.Bytes()))
+}
+
+// ReadClientRegistry reads a registry written by Write. It does not check
+// the signature, since the reader may not hold the key version the registry
+// names; Verify does, and so does a Server taking the registry on.
+func ReadClientRegistry(r io.Reader, pairing *pbc.Pairing) (*ClientRegistry, error) {
+	data, err := readSingleBlock(r, pemClientRegistry)
+	if err != nil {
+		return nil, err
+	}
+	br := &wireReader{buf: data}
+	registry := &ClientRegistry{keys: make(map[ClientID]*pbc.Element)}
+	copy(registry.KeyID[:], br.next(len(registry.KeyID)))
+	registry.Version = br.uint64()
+	count := br.uint32()
+	var last ClientID
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		idLen := br.next(1)
+		if br.err != nil {
+			break
+		}
+		id := ClientID(br.next(int(idLen[0])))
+		key := br.bytes16()
+		if br.err != nil {
+			break
+		}
+		if id.check() != nil || (i > 0 && id <= last) {
+			return nil, ErrMalformedMessage
+		}
+		if registry.keys[id], err = decodeG1(pairing, key); err != nil {
+			return nil, err
+		}
+		last = id
+	}
+	signature := br.bytes16()
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+	if registry.signature, err = decodeG1(pairing, signature); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// KeyProof shows that rxP is a multiple of the client's x_iP without
+// revealing r: a Schnorr proof of knowledge of r, bound to the client ID.
+type KeyProof struct {
+	T *pbc.Element
+	S *pbc.Element
+}
+
+// proveKey proves knowledge of r with rxP = r * xP.
+func proveKey(pairing *pbc.Pairing, id ClientID, xP, rxP, r *pbc.Element) *KeyProof {
+	k := pairing.NewZr().Rand()
+	T := pairing.NewG1().MulZn(xP, k)
+	c := keyChallenge(pairing, id, xP, rxP, T)
+	S := pairing.NewZr().Add(k, pairing.NewZr().Mul(c, r))
+	return &KeyProof{T: T, S: S}
+}
+
+// verify checks S * xP == T + c * rxP.
+func (proof *KeyProof) verify(pairing *pbc.Pairing, id ClientID, xP, rxP *pbc.Element) bool {
+	if proof == nil || proof.T == nil || proof.S == nil {
+		return false
+	}
+	c := keyChallenge(pairing, id, xP, rxP, proof.T)
+	lhs := pairing.NewG1().MulZn(xP, proof.S)
+	rhs := pairing.NewG1().Add(proof.T, pairing.NewG1().MulZn(rxP, c))
+	return lhs.Equals(rhs)
+}
+
+func keyChallenge(pairing *pbc.Pairing, id ClientID, xP, rxP, T *pbc.Element) *pbc.Element {
+	h := sha256.New()
+	h.Write([]byte("apsi-key-proof-v1"))
+	h.Write(append([]byte{byte(len(id))}, id...))
+	h.Write(encodeElements(xP, rxP, T))
+	return pairing.NewZr().SetFromHash(h.Sum(nil))
+}
+
+// checkClient makes sure the client registry for the negotiated key version
+// lists id and that proof ties rxP to its key.
+func checkClient(pairing *pbc.Pairing, registry *ClientRegistry, id ClientID, rxP *pbc.Element, proof *KeyProof) error {
+	if registry == nil {
+		return ErrUnknownClient
+	}
+	xP, err := registry.Lookup(id)
+	if err != nil {
+		return err
+	}
+	if !proof.verify(pairing, id, xP, rxP) {
+		return ErrClientProof
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package apsi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestClientRegistrySession(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	registry := authority.NewClientRegistry()
+	for _, id := range []ClientID{"alice", "bob"} {
+		if err := authority.RegisterClient(registry, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var written bytes.Buffer
+	if err := registry.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := ReadClientRegistry(&written, pairing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registry.Verify(pairing, pk) {
+		t.Fatal("registry read back does not verify")
+	}
+
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}}
+	alice, err := authority.ForClient("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientSignatures, err := alice.AuthorizeSet(set, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverSignatures, err := authority.AuthorizeSet(set[1:], ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, set[1:], serverSignatures, WithClientRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pairing, alice.PublicKey(), set, clientSignatures, AsClient("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intersection, err := runSession(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := set[1:]; !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+
+	// Alice's key cannot pass for bob's: her proof is for her own x_iP.
+	impostor, err := NewClient(pairing, alice.PublicKey(), set, clientSignatures, AsClient("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSession(impostor, server); err != ErrClientProof {
+		t.Errorf("session as bob with alice's key = %v, want %v", err, ErrClientProof)
+	}
+
+	if err := authority.RemoveClient(registry, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	server, err = NewServer(pairing, pk, set[1:], serverSignatures, WithClientRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSession(client, server); err != ErrUnknownClient {
+		t.Errorf("session after removal = %v, want %v", err, ErrUnknownClient)
+	}
+}
+
+func TestKeyProof(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing := authority.Pairing()
+	alice, err := authority.ForClient("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xP := alice.PublicKey().XP
+	r := pairing.NewZr().Rand()
+	rxP := pairing.NewG1().MulZn(xP, r)
+	proof := proveKey(pairing, "alice", xP, rxP, r)
+	if !proof.verify(pairing, "alice", xP, rxP) {
+		t.Fatal("proof does not verify")
+	}
+	if proof.verify(pairing, "bob", xP, rxP) {
+		t.Error("proof verifies for another client ID")
+	}
+	if proof.verify(pairing, "alice", xP, pairing.NewG1().Rand()) {
+		t.Error("proof verifies for another rxP")
+	}
+	if proof.verify(pairing, "alice", authority.PublicKey().XP, rxP) {
+		t.Error("proof verifies under another key")
+	}
+	var missing *KeyProof
+	if missing.verify(pairing, "alice", xP, rxP) {
+		t.Error("a missing proof verifies")
+	}
+}
@@ -0,0 +1,82 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// A context ties an authorization to the case it was granted for, the
+// purpose it serves and the counterparty it may be used with. An
+// authorization issued InContext(ctx) signs a hash that covers the context's
+// digest, so it only matches counterparts issued for the same context and is
+// useless against any other server or investigation.
+
+// ErrContextMismatch is returned when the two sides of a session hold
+// authorizations for different contexts.
+var ErrContextMismatch = errors.New("apsi: client and server are in different contexts")
+
+// Context says what an authorization was granted for. Every field is
+// optional, but the zero Context means no context at all.
+type Context struct {
+	// Case identifies the investigation or matter, such as a case number.
+	Case string
+
+	// Purpose says what the authorization may be used for.
+	Purpose string
+
+	// Counterparty names the party on the other side of the session,
+	// typically the server the client may query.
+	Counterparty string
+}
+
+// ContextDigest is the digest of a Context that authorizations sign and
+// sessions announce.
+type ContextDigest [32]byte
+
+func (digest ContextDigest) String() string {
+	return hex.EncodeToString(digest[:])
+}
+
+// Digest returns the digest of ctx, which is zero for the zero Context:
+//
+//	SHA-256("apsi-context-v1" || len(case) (4) | case || ... purpose ... || ... counterparty ...)
+func (ctx Context) Digest() ContextDigest {
+	if ctx == (Context{}) {
+		return ContextDigest{}
+	}
+	h := sha256.New()
+	h.Write([]byte("apsi-context-v1"))
+	for _, field := range []string{ctx.Case, ctx.Purpose, ctx.Counterparty} {
+		h.Write(appendUint32(nil, uint32(len(field))))
+		h.Write([]byte(field))
+	}
+	var digest ContextDigest
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// InContext binds an authorization to ctx.
+func InContext(ctx Context) AuthorizeOption {
+	return inContextDigest(ctx.Digest())
+}
+
+func inContextDigest(digest ContextDigest) AuthorizeOption {
+	return func(b *binding) {
+		b.context = digest
+	}
+}
+
+// WithContext runs the interaction in ctx. Only authorizations issued
+// InContext(ctx) take part, and as with WithEpoch the others are dropped
+// unless WithVerification asks for something else. A Client announces the
+// context's digest and a Server refuses clients in any other context.
+func WithContext(ctx Context) InteractionOption {
+	return withContextDigest(ctx.Digest())
+}
+
+func withContextDigest(digest ContextDigest) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.context = digest
+	}
+}
@@ -0,0 +1,539 @@
+package apsi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Delegation hands issuance for part of the element space to a
+// sub-authority without handing out x or y. The sub-authority draws its own
+// secret d for one party, and the authority signs a certificate for dP with
+// its document key, naming the scope it may issue in: a prefix every element must start with,
+// and an expiry. A sub-authority can delegate further within its own scope,
+// so a chain of certificates leads from the root public key to the key that
+// signed; each link below the first is signed by the previous delegate's d.
+// Its authorizations dH(elt) are ordinary ones under (P, dP, yP) for
+// the client or (P, xP, dP) for the server. A party given the chain with
+// WithDelegation checks it, drops every authorization outside its scope and
+// runs under that key.
+
+var (
+	// ErrDelegationScope is returned for an element or a sub-delegation
+	// outside a delegation's scope.
+	ErrDelegationScope = errors.New("apsi: outside the delegation's scope")
+
+	// ErrDelegationExpired is returned once a delegation has expired.
+	ErrDelegationExpired = errors.New("apsi: delegation has expired")
+
+	// ErrDelegationChain is returned for a delegation chain that does not
+	// lead back to the root public key.
+	ErrDelegationChain = errors.New("apsi: delegation chain is invalid")
+)
+
+// DelegationScope is what a delegation covers: elements whose bytes start
+// with Prefix, until Expires. A zero Expires never expires.
+type DelegationScope struct {
+	Prefix  []byte
+	Expires time.Time
+}
+
+// Allows reports whether the scope covers elt at now.
+func (scope DelegationScope) Allows(elt RawElement, now time.Time) bool {
+	return bytes.HasPrefix(elt[:], scope.Prefix) && scope.activeAt(now)
+}
+
+func (scope DelegationScope) activeAt(now time.Time) bool {
+	return scope.Expires.IsZero() || now.Before(scope.Expires)
+}
+
+// within reports whether scope is no wider than parent.
+func (scope DelegationScope) within(parent DelegationScope) bool {
+	if len(scope.Prefix) > len(RawElement{}) || !bytes.HasPrefix(scope.Prefix, parent.Prefix) {
+		return false
+	}
+	return parent.Expires.IsZero() || (!scope.Expires.IsZero() && !scope.Expires.After(parent.Expires))
+}
+
+// DelegationCertificate is one link of a delegation chain: the delegate's
+// key for Party and its scope, signed by the previous link's key.
+type DelegationCertificate struct {
+	Party Party
+	Key   *pbc.Element
+	Scope DelegationScope
+
+	signature *pbc.Element
+}
+
+const delegationDomain = "apsi-delegation-v2"
+
+// contents encodes what a certificate's signature covers:
+//
+//	root key ID (8) | party (1) | len(key) (2) | key | len(prefix) (1) | prefix | expiry (8)
+//
+// with the expiry in Unix seconds, 0 for none.
+func (cert *DelegationCertificate) contents(root KeyID) []byte {
+	b := append(append([]byte(nil), root[:]...), byte(cert.Party))
+	b = appendBytes16(b, cert.Key.Bytes())
+	b = append(append(b, byte(len(cert.Scope.Prefix))), cert.Scope.Prefix...)
+	var expires uint64
+	if !cert.Scope.Expires.IsZero() {
+		expires = uint64(cert.Scope.Expires.Unix())
+	}
+	return appendUint64(b, expires)
+}
+
+// DelegationChain leads from the root key version Root to a sub-authority,
+// the root's own delegate first.
+type DelegationChain struct {
+	Root         KeyID
+	Certificates []*DelegationCertificate
+}
+
+// Party returns the party the chain delegates issuance for.
+func (chain *DelegationChain) Party() Party {
+	return chain.Certificates[0].Party
+}
+
+// Key returns the key of the chain's last delegate.
+func (chain *DelegationChain) Key() *pbc.Element {
+	return chain.Certificates[len(chain.Certificates)-1].Key
+}
+
+// Scope returns the scope of the chain's last delegate. Every link is
+// within the one before it, so this is what the whole chain covers.
+func (chain *DelegationChain) Scope() DelegationScope {
+	return chain.Certificates[len(chain.Certificates)-1].Scope
+}
+
+// PublicKey returns root with the delegated party's key replaced by the
+// last delegate's, which is the key its authorizations verify under.
+func (chain *DelegationChain) PublicKey(root PublicKey) PublicKey {
+	if chain.Party() == ClientParty {
+		root.XP = chain.Key()
+	} else {
+		root.YP = chain.Key()
+	}
+	return root
+}
+
+// Verify checks that every certificate in the chain is signed by the one
+// before it, the first by root's document key, that each scope is within the one before it
+// and that the chain has not expired at now.
+func (chain *DelegationChain) Verify(pairing *pbc.Pairing, root PublicKey, now time.Time) error {
+	if err := chain.verifySignatures(pairing, root); err != nil {
+		return err
+	}
+	if !chain.Scope().activeAt(now) {
+		return ErrDelegationExpired
+	}
+	return nil
+}
+
+func (chain *DelegationChain) verifySignatures(pairing *pbc.Pairing, root PublicKey) error {
+	if len(chain.Certificates) == 0 || chain.Root != root.ID() {
+		return ErrDelegationChain
+	}
+	party := chain.Party()
+	if _, err := root.forParty(party); err != nil {
+		return err
+	}
+	signer := root.ZP
+	if signer == nil {
+		return ErrDelegationChain
+	}
+	var scope DelegationScope
+	for _, cert := range chain.Certificates {
+		if cert.Party != party || !cert.Scope.within(scope) ||
+			!verifyDocumentUnder(pairing, root.P, signer, delegationDomain, cert.contents(chain.Root), cert.signature) {
+			return ErrDelegationChain
+		}
+		signer, scope = cert.Key, cert.Scope
+	}
+	return nil
+}
+
+// extend returns a copy of chain with cert appended.
+func (chain *DelegationChain) extend(cert *DelegationCertificate) *DelegationChain {
+	certificates := append([]*DelegationCertificate(nil), chain.Certificates...)
+	return &DelegationChain{Root: chain.Root, Certificates: append(certificates, cert)}
+}
+
+// WithDelegation gives a Client, a Server or an interaction a delegation
+// chain rooted at its key. Authorizations of the chain's party are checked
+// under the chain's key, and those outside its scope are dropped as invalid;
+// unless WithVerification asks for something else, invalid ones are
+// dropped. Give the chain to both sides: the client also needs the key of a
+// server's delegate to compute its tags. Chains rooted at other key versions
+// are ignored.
+func WithDelegation(chain *DelegationChain) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.delegations = append(cfg.delegations, chain)
+	}
+}
+
+// delegatedKey checks cfg's delegation chains rooted at pk and returns pk
+// with their keys in place.
+func (cfg *interactionConfig) delegatedKey(pairing *pbc.Pairing, pk PublicKey) (PublicKey, error) {
+	delegated := pk
+	now := time.Now()
+	for _, chain := range cfg.delegations {
+		if chain.Root != pk.ID() {
+			continue
+		}
+		if err := chain.Verify(pairing, pk, now); err != nil {
+			return PublicKey{}, err
+		}
+		delegated = chain.PublicKey(delegated)
+	}
+	return delegated, nil
+}
+
+// outOfScope returns the indices of the elements of set that a chain for
+// party rooted at root does not cover.
+func (cfg *interactionConfig) outOfScope(root KeyID, set RawElementSlice, party Party) []int {
+	var outside []int
+	now := time.Now()
+	for _, chain := range cfg.delegations {
+		if chain.Root != root || chain.Party() != party {
+			continue
+		}
+		scope := chain.Scope()
+		for i, elt := range set {
+			if !scope.Allows(elt, now) {
+				outside = append(outside, i)
+			}
+		}
+		return outside
+	}
+	return nil
+}
+
+// SubAuthority issues authorizations for one party within the scope of its
+// delegation chain.
+type SubAuthority struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	root   PublicKey
+	chain  *DelegationChain
+	secret *pbc.Element
+}
+
+// Delegate creates a sub-authority that issues for party within scope.
+func (authority *Authority) Delegate(party Party, scope DelegationScope) (*SubAuthority, error) {
+	if _, err := authority.sk.forParty(party); err != nil {
+		return nil, err
+	}
+	if !scope.within(DelegationScope{}) {
+		return nil, ErrDelegationScope
+	}
+	parent := &SubAuthority{
+		params:  authority.params,
+		pairing: authority.pairing,
+		root:    authority.pk,
+		chain:   &DelegationChain{Root: authority.pk.ID()},
+		secret:  authority.sk.Z,
+	}
+	return parent.delegate(party, scope), nil
+}
+
+// Delegate creates a sub-authority below sub for a scope within sub's own.
+func (sub *SubAuthority) Delegate(scope DelegationScope) (*SubAuthority, error) {
+	if !scope.within(sub.chain.Scope()) {
+		return nil, ErrDelegationScope
+	}
+	return sub.delegate(sub.chain.Party(), scope), nil
+}
+
+func (sub *SubAuthority) delegate(party Party, scope DelegationScope) *SubAuthority {
+	secret := sub.pairing.NewZr().Rand()
+	cert := &DelegationCertificate{
+		Party: party,
+		Key:   sub.pairing.NewG1().MulZn(sub.root.P, secret),
+		Scope: DelegationScope{Prefix: append([]byte(nil), scope.Prefix...), Expires: scope.Expires},
+	}
+	digest := documentHash(sub.pairing, delegationDomain, cert.contents(sub.chain.Root))
+	cert.signature = sub.pairing.NewG1().MulZn(digest, sub.secret)
+	return &SubAuthority{
+		params:  sub.params,
+		pairing: sub.pairing,
+		root:    sub.root,
+		chain:   sub.chain.extend(cert),
+		secret:  secret,
+	}
+}
+
+// Pairing returns the pairing the sub-authority's keys live in.
+func (sub *SubAuthority) Pairing() *pbc.Pairing {
+	return sub.pairing
+}
+
+// Chain returns the sub-authority's delegation chain, which holders of its
+// authorizations need to use them.
+func (sub *SubAuthority) Chain() *DelegationChain {
+	return sub.chain
+}
+
+// RootPublicKey returns the public key the chain leads back to.
+func (sub *SubAuthority) RootPublicKey() PublicKey {
+	return sub.root
+}
+
+// PublicKey returns the key the sub-authority's authorizations verify
+// under.
+func (sub *SubAuthority) PublicKey() PublicKey {
+	return sub.chain.PublicKey(sub.root)
+}
+
+// WritePublicKey writes the root's public key file, which is what clients
+// and servers holding the sub-authority's authorizations are given along
+// with its chain.
+func (sub *SubAuthority) WritePublicKey(w io.Writer) error {
+	return writePublicBlocks(w, sub.params, sub.root)
+}
+
+// Authorize signs elt as Authority.Authorize does. party must be the one the
+// sub-authority issues for, and elt must be within its scope.
+func (sub *SubAuthority) Authorize(elt RawElement, party Party, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	if party != sub.chain.Party() {
+		return 0, nil, ErrWrongParty
+	}
+	scope := sub.chain.Scope()
+	if !scope.activeAt(time.Now()) {
+		return 0, nil, ErrDelegationExpired
+	}
+	if !bytes.HasPrefix(elt[:], scope.Prefix) {
+		return 0, nil, ErrDelegationScope
+	}
+
+	startTime := time.Now()
+
+	signature := sub.pairing.NewG1().MulZn(newBinding(opts).hash(sub.pairing, elt), sub.secret)
+
+	totalTime := time.Since(startTime)
+	return totalTime, signature, nil
+}
+
+// AuthorizeSet signs every element of elements for the given party. The
+// returned duration is the total signing time.
+func (sub *SubAuthority) AuthorizeSet(elements RawElementSlice, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	var totalTime time.Duration
+	signatures := make([]*pbc.Element, len(elements))
+	for i, element := range elements {
+		signingTime, signature, err := sub.Authorize(element, party, opts...)
+		if err != nil {
+			return totalTime, nil, err
+		}
+		signatures[i] = signature
+		totalTime += signingTime
+	}
+	return totalTime, signatures, nil
+}
+
+// A chain is stored in an "APSI DELEGATION CHAIN" block:
+//
+//	root key ID (8) | count (1) | certificates
+//
+// with each certificate encoded as its signed contents without the root key
+// ID, followed by len(signature) (2) | signature. A sub-authority key file
+// holds the root's public key file, its chain and its secret d in an
+// "APSI SECRET KEY" block, or in an encrypted one as for
+// Authority.WriteEncryptedKeys.
+const pemDelegationChain = "APSI DELEGATION CHAIN"
+
+// MarshalBinary encodes the chain as the contents of its PEM block.
+func (chain *DelegationChain) MarshalBinary() ([]byte, error) {
+	if len(chain.Certificates) == 0 || len(chain.Certificates) > 255 {
+		return nil, ErrDelegationChain
+	}
+	b := append(append([]byte(nil), chain.Root[:]...), byte(len(chain.Certificates)))
+	for _, cert := range chain.Certificates {
+		b = append(b, cert.contents(chain.Root)[len(chain.Root):]...)
+		b = appendBytes16(b, cert.signature.Bytes())
+	}
+	return b, nil
+}
+
+// UnmarshalDelegationChain decodes a chain encoded by MarshalBinary. It does
+// not check the signatures; Verify does.
+func UnmarshalDelegationChain(pairing *pbc.Pairing, data []byte) (*DelegationChain, error) {
+	r := &wireReader{buf: data}
+	chain := &DelegationChain{}
+	copy(chain.Root[:], r.next(len(chain.Root)))
+	count := r.next(1)
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := 0; i < int(count[0]) && r.err == nil; i++ {
+		party := r.next(1)
+		key := r.bytes16()
+		prefixLen := r.next(1)
+		if r.err != nil {
+			break
+		}
+		prefix := r.next(int(prefixLen[0]))
+		expires := r.uint64()
+		signature := r.bytes16()
+		if r.err != nil {
+			break
+		}
+		cert := &DelegationCertificate{
+			Party: Party(party[0]),
+			Scope: DelegationScope{Prefix: append([]byte(nil), prefix...)},
+		}
+		if cert.Party != ClientParty && cert.Party != ServerParty {
+			return nil, ErrUnknownParty
+		}
+		if expires != 0 {
+			cert.Scope.Expires = time.Unix(int64(expires), 0)
+		}
+		var err error
+		if cert.Key, err = decodeG1(pairing, key); err != nil {
+			return nil, err
+		}
+		if cert.signature, err = decodeG1(pairing, signature); err != nil {
+			return nil, err
+		}
+		chain.Certificates = append(chain.Certificates, cert)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	if len(chain.Certificates) == 0 {
+		return nil, ErrDelegationChain
+	}
+	return chain, nil
+}
+
+// Write writes the chain for distribution to the holders of the
+// sub-authority's authorizations.
+func (chain *DelegationChain) Write(w io.Writer) error {
+	data, err := chain.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return pemEncode(w, pemDelegationChain, data)
+}
+
+// ReadDelegationChain reads a chain written by DelegationChain.Write.
+func ReadDelegationChain(r io.Reader, pairing *pbc.Pairing) (*DelegationChain, error) {
+	data, err := readSingleBlock(r, pemDelegationChain)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalDelegationChain(pairing, data)
+}
+
+// writePublic writes the public part of a sub-authority key file.
+func (sub *SubAuthority) writePublic(w io.Writer) error {
+	if err := sub.WritePublicKey(w); err != nil {
+		return err
+	}
+	return sub.chain.Write(w)
+}
+
+// WriteKeys writes the root's public key, the chain and the sub-authority's
+// secret in the clear. The output must be stored like an authority key
+// file.
+func (sub *SubAuthority) WriteKeys(w io.Writer) error {
+	if err := sub.writePublic(w); err != nil {
+		return err
+	}
+	return pemEncode(w, pemSecretKey, encodeElements(sub.secret))
+}
+
+// WriteEncryptedKeys is WriteKeys with the secret encrypted under
+// passphrase, in the same format as Authority.WriteEncryptedKeys.
+func (sub *SubAuthority) WriteEncryptedKeys(w io.Writer, passphrase []byte, kdf KDFParams) error {
+	var public bytes.Buffer
+	if err := sub.writePublic(&public); err != nil {
+		return err
+	}
+	block, err := sealBlock(encodeElements(sub.secret), public.Bytes(), passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(public.Bytes()); err != nil {
+		return err
+	}
+	return pemEncode(w, pemEncryptedSecretKey, block)
+}
+
+// ReadSubAuthority reads a file written by SubAuthority.WriteKeys. It checks
+// the chain's signatures and that the secret matches the chain's last key,
+// but not whether the chain has expired.
+func ReadSubAuthority(r io.Reader) (*SubAuthority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemSecretKey]
+	if _, encrypted := blocks[pemEncryptedSecretKey]; !ok && encrypted {
+		return nil, ErrKeyEncrypted
+	}
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemSecretKey)
+	}
+	return decodeSubAuthority(blocks, func([]byte) ([]byte, error) { return data, nil })
+}
+
+// ReadEncryptedSubAuthority reads a file written by
+// SubAuthority.WriteEncryptedKeys and decrypts the secret with passphrase.
+func ReadEncryptedSubAuthority(r io.Reader, passphrase []byte) (*SubAuthority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blocks[pemEncryptedSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemEncryptedSecretKey)
+	}
+	return decodeSubAuthority(blocks, func(public []byte) ([]byte, error) {
+		return openBlock(block, public, passphrase)
+	})
+}
+
+// decodeSubAuthority decodes the public blocks of a sub-authority key file
+// and gets the secret from secret, which is given the public part as it was
+// written.
+func decodeSubAuthority(blocks map[string][]byte, secret func(public []byte) ([]byte, error)) (*SubAuthority, error) {
+	params, pairing, root, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemDelegationChain]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemDelegationChain)
+	}
+	chain, err := UnmarshalDelegationChain(pairing, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.verifySignatures(pairing, root); err != nil {
+		return nil, err
+	}
+	sub := &SubAuthority{params: params, pairing: pairing, root: root, chain: chain}
+
+	var public bytes.Buffer
+	if err := sub.writePublic(&public); err != nil {
+		return nil, err
+	}
+	plaintext, err := secret(public.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	elements, err := decodeElements(plaintext, pairing.NewZr)
+	if err != nil {
+		return nil, err
+	}
+	if !pairing.NewG1().MulZn(root.P, elements[0]).Equals(chain.Key()) {
+		return nil, ErrKeyMismatch
+	}
+	sub.secret = elements[0]
+	return sub, nil
+}
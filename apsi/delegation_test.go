@@ -0,0 +1,99 @@
+package apsi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDelegationSession(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	east, err := authority.Delegate(ClientParty, DelegationScope{Prefix: []byte{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := east.Delegate(DelegationScope{Prefix: []byte{1}}); err != ErrDelegationScope {
+		t.Errorf("Delegate outside the scope = %v, want %v", err, ErrDelegationScope)
+	}
+	office, err := east.Delegate(DelegationScope{Prefix: []byte{0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := office.Authorize(RawElement{0, 1}, ClientParty); err != ErrDelegationScope {
+		t.Errorf("Authorize outside the scope = %v, want %v", err, ErrDelegationScope)
+	}
+	if _, _, err := office.Authorize(RawElement{0, 0}, ServerParty); err != ErrWrongParty {
+		t.Errorf("Authorize for the server = %v, want %v", err, ErrWrongParty)
+	}
+
+	data, err := office.Chain().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := UnmarshalDelegationChain(pairing, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Verify(pairing, pk, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}}
+	_, clientSignatures, err := office.AuthorizeSet(set, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverSignatures, err := authority.AuthorizeSet(set[1:], ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, set[1:], serverSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pairing, pk, set, clientSignatures, WithDelegation(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intersection, err := runSession(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := set[1:]; !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+
+	// Widening a certificate's scope breaks its signature.
+	chain.Certificates[1].Scope.Prefix = []byte{0}
+	if err := chain.Verify(pairing, pk, time.Now()); err != ErrDelegationChain {
+		t.Errorf("Verify of a widened chain = %v, want %v", err, ErrDelegationChain)
+	}
+	_, other := NewAuthority()
+	if err := east.Chain().Verify(other.Pairing(), other.PublicKey(), time.Now()); err != ErrDelegationChain {
+		t.Errorf("Verify under another root = %v, want %v", err, ErrDelegationChain)
+	}
+}
+
+func TestDelegationExpires(t *testing.T) {
+	_, authority := NewAuthority()
+	expires := time.Now().Add(time.Hour)
+	sub, err := authority.Delegate(ServerParty, DelegationScope{Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Delegate(DelegationScope{}); err != ErrDelegationScope {
+		t.Errorf("Delegate without an expiry = %v, want %v", err, ErrDelegationScope)
+	}
+	if err := sub.Chain().Verify(authority.Pairing(), authority.PublicKey(), expires); err != ErrDelegationExpired {
+		t.Errorf("Verify at expiry = %v, want %v", err, ErrDelegationExpired)
+	}
+
+	past, err := authority.Delegate(ServerParty, DelegationScope{Expires: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := past.Authorize(RawElement{}, ServerParty); err != ErrDelegationExpired {
+		t.Errorf("Authorize after expiry = %v, want %v", err, ErrDelegationExpired)
+	}
+}
@@ -0,0 +1,32 @@
+package apsi
+
+// RawElement is a single set element as it is fed into H.
+type RawElement [4]byte
+
+// RawElementSlice is a set of elements. It implements sort.Interface,
+// ordering elements lexicographically by their bytes.
+type RawElementSlice []RawElement
+
+func (p RawElementSlice) Len() int {
+	return len(p)
+}
+
+func (p RawElementSlice) Less(x, y int) bool {
+	a := p[x]
+	b := p[y]
+
+	for i, byteVal := range a {
+		if byteVal < b[i] {
+			return true
+		} else if byteVal > b[i] {
+			return false
+		}
+	}
+	return false
+}
+
+func (p RawElementSlice) Swap(i, j int) {
+	temp := p[i]
+	p[i] = p[j]
+	p[j] = temp
+}
@@ -0,0 +1,92 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Epoch numbers a validity period for authorizations. An authorization
+// issued AtEpoch(e) signs H(elt || e) instead of H(elt), so it only matches
+// counterparts issued for the same epoch and lapses once sessions move on to
+// the next one.
+type Epoch uint64
+
+// NoEpoch marks an authorization that is valid forever, as issued by
+// Authorize without options.
+const NoEpoch Epoch = 0
+
+var (
+	// ErrEpochMismatch is returned when the two sides of a session hold
+	// authorizations for different epochs.
+	ErrEpochMismatch = errors.New("apsi: client and server are in different epochs")
+
+	// ErrEpochOver is returned by a server whose authorizations belong to
+	// an epoch that has ended, or not yet begun.
+	ErrEpochOver = errors.New("apsi: the server's epoch is not the current one")
+)
+
+// EpochOf returns the epoch containing t when epochs are consecutive periods
+// of the given length counted from the Unix epoch. Like time.NewTicker, it
+// panics if period is not positive.
+func EpochOf(t time.Time, period time.Duration) Epoch {
+	if period <= 0 {
+		panic("apsi: non-positive epoch period")
+	}
+	return Epoch(t.UnixNano() / int64(period))
+}
+
+// AuthorizeOption binds an authorization to more than its element. The same
+// options must be given when the authorization is verified.
+type AuthorizeOption func(*binding)
+
+// AtEpoch binds an authorization to epoch.
+func AtEpoch(epoch Epoch) AuthorizeOption {
+	return func(b *binding) {
+		b.epoch = epoch
+	}
+}
+
+// binding is everything an authorization signs besides the element.
+type binding struct {
+	epoch   Epoch
+	context ContextDigest
+}
+
+func newBinding(opts []AuthorizeOption) binding {
+	var b binding
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// hash computes the point an authorization of elt signs: H(elt) when
+// unbound, and otherwise
+//
+//	H("apsi-binding-v1" || elt || epoch (8))
+//	H("apsi-binding-v2" || elt || epoch (8) || context digest (32))
+//
+// depending on whether a context is bound.
+func (b binding) hash(pairing *pbc.Pairing, elt RawElement) *pbc.Element {
+	if b == (binding{}) {
+		return hashElement(pairing, elt)
+	}
+	h := sha256.New()
+	if b.context == (ContextDigest{}) {
+		h.Write([]byte("apsi-binding-v1"))
+	} else {
+		h.Write([]byte("apsi-binding-v2"))
+	}
+	h.Write(elt[:])
+	var epoch [8]byte
+	binary.BigEndian.PutUint64(epoch[:], uint64(b.epoch))
+	h.Write(epoch[:])
+	if b.context != (ContextDigest{}) {
+		h.Write(b.context[:])
+	}
+	return pairing.NewG1().SetFromHash(h.Sum(nil))
+}
@@ -0,0 +1,31 @@
+package apsi
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnknownParty is returned when a Party is neither ClientParty nor
+	// ServerParty.
+	ErrUnknownParty = errors.New("apsi: unknown party")
+
+	// ErrSignatureCount is returned when a set and its signatures do not
+	// have the same length.
+	ErrSignatureCount = errors.New("apsi: set and signatures differ in length")
+
+	// ErrThreadCount is returned when a threaded interaction is asked to run
+	// with fewer than one thread.
+	ErrThreadCount = errors.New("apsi: thread count must be positive")
+
+	// ErrNoSession is returned when a client is asked to finish a session
+	// it never started with Blind.
+	ErrNoSession = errors.New("apsi: no session in progress")
+
+	// ErrMalformedMessage is returned when a protocol message is missing
+	// fields.
+	ErrMalformedMessage = errors.New("apsi: malformed protocol message")
+)
+
+// errFileLocked is returned by lockFile when another process holds the
+// lock and the caller would rather not wait.
+var errFileLocked = errors.New("apsi: file is locked by another process")
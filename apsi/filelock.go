@@ -0,0 +1,50 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package apsi
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLock is an exclusive lock on a file shared by several processes.
+// The kernel drops it when the process holding it exits, however it
+// exits.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile locks the file at path, creating it if it does not exist. It
+// waits for another process to let go of the lock if wait is set, and
+// fails with errFileLocked otherwise.
+func lockFile(path string, wait bool) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, errFileLocked
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// unlock lets go of the lock. The file stays behind for the next holder.
+func (l *fileLock) unlock() error {
+	return l.f.Close()
+}
@@ -0,0 +1,40 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package apsi
+
+import (
+	"os"
+	"time"
+)
+
+// fileLock is an exclusive lock on a file shared by several processes.
+// Without flock, the file is created exclusively and removed on unlock,
+// so one left behind by a process that crashed has to be removed by hand.
+type fileLock struct {
+	path string
+}
+
+// lockFile locks the file at path. It waits for another process to let go
+// of the lock if wait is set, and fails with errFileLocked otherwise.
+func lockFile(path string, wait bool) (*fileLock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return &fileLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !wait {
+			return nil, errFileLocked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// unlock lets go of the lock.
+func (l *fileLock) unlock() error {
+	return os.Remove(l.path)
+}
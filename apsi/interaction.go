@@ -0,0 +1,553 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// checkSets makes sure every element has exactly one signature.
+func checkSets(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element) error {
+
+	if len(clientSet) != len(clientSignatures) || len(serverSet) != len(serverSignatures) {
+		return ErrSignatureCount
+	}
+	return nil
+}
+
+// The *Interaction methods below all take InteractionOptions, applied
+// before the timed part of the interaction starts.
+
+// Interaction runs one session between a Client and a Server built from the
+// given sets and returns the client's view of the intersection.
+func (scheme *DualAPSIScheme) Interaction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	client, err := NewClient(scheme.pairing, pk, clientSet, clientSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+	server, err := NewServer(scheme.pairing, pk, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	blindTime, blinding := client.Blind()
+	respondTime, tagSet, err := server.Respond(blinding)
+	if err != nil {
+		return 0, nil, err
+	}
+	intersectTime, intersection, err := client.Intersect(tagSet)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return blindTime + respondTime + intersectTime, intersection, nil
+}
+
+// ThreadedInteraction is Interaction with one goroutine per pairing.
+func (scheme *DualAPSIScheme) ThreadedInteraction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	startTime := time.Now()
+
+	// Step 1: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	r := scheme.pairing.NewZr()
+	rxP := scheme.pairing.NewG1()
+	ryP := scheme.pairing.NewG1()
+
+	r.Rand()
+	rxP.MulZn(pk.XP, r)
+	ryP.MulZn(pk.YP, r)
+
+	serverHashes := make(map[[32]byte]bool)
+	var serverWG sync.WaitGroup
+	var serverLock sync.RWMutex
+	serverWG.Add(len(serverSignatures))
+	for _, serverSignature := range serverSignatures {
+		go func(signature *pbc.Element) {
+			// Recall that serverSignature = H(c_i)^y.
+			e_sig_rxP := scheme.pairing.NewGT()
+			e_sig_rxP.Pair(signature, rxP)
+
+			hashed := sha256.Sum256(e_sig_rxP.Bytes())
+
+			serverLock.Lock()
+			serverHashes[hashed] = true
+			serverLock.Unlock()
+
+			serverWG.Done()
+		}(serverSignature)
+	}
+	serverWG.Wait()
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+	var intersection RawElementSlice
+	var clientWG sync.WaitGroup
+	var clientLock sync.RWMutex
+	clientWG.Add(len(clientSignatures))
+	for i, clientSignature := range clientSignatures {
+		go func(signature *pbc.Element, index int) {
+			e_sig_ryP := scheme.pairing.NewGT()
+			e_sig_ryP.Pair(signature, ryP)
+
+			hashed := sha256.Sum256(e_sig_ryP.Bytes())
+
+			_, serverHas := serverHashes[hashed]
+			if serverHas {
+				clientLock.Lock()
+				intersection = append(intersection, clientSet[index])
+				clientLock.Unlock()
+			}
+
+			clientWG.Done()
+		}(clientSignature, i)
+	}
+	clientWG.Wait()
+
+	totalTime := time.Since(startTime)
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
+
+// SmarterThreadedInteraction is Interaction with numThreads workers pulling
+// signatures from a channel.
+func (scheme *DualAPSIScheme) SmarterThreadedInteraction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	numThreads int, opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+	if numThreads < 1 {
+		return 0, nil, ErrThreadCount
+	}
+
+	startTime := time.Now()
+
+	// Step 1: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	r := scheme.pairing.NewZr()
+	rxP := scheme.pairing.NewG1()
+	ryP := scheme.pairing.NewG1()
+
+	r.Rand()
+	rxP.MulZn(pk.XP, r)
+	ryP.MulZn(pk.YP, r)
+
+	serverHashes := make(map[[32]byte]bool)
+	var serverWG sync.WaitGroup
+	var serverLock sync.RWMutex
+
+	serverChan := make(chan *pbc.Element, len(serverSignatures))
+	for _, v := range serverSignatures {
+		serverChan <- v
+	}
+	close(serverChan)
+
+	serverWG.Add(numThreads)
+	for i := 0; i < numThreads; i++ {
+		go func() {
+			e_sig_rxP := scheme.pairing.NewGT()
+
+			for signature := range serverChan {
+				// Recall that serverSignature = H(c_i)^y.
+				e_sig_rxP.Pair(signature, rxP)
+				hashed := sha256.Sum256(e_sig_rxP.Bytes())
+
+				serverLock.Lock()
+				serverHashes[hashed] = true
+				serverLock.Unlock()
+			}
+
+			serverWG.Done()
+		}()
+	}
+	serverWG.Wait()
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+
+	clientChan := make(chan int, len(clientSignatures))
+	for i := range clientSignatures {
+		clientChan <- i
+	}
+	close(clientChan)
+
+	var intersection RawElementSlice
+	var clientWG sync.WaitGroup
+	var clientLock sync.RWMutex
+	clientWG.Add(numThreads)
+	for i := 0; i < numThreads; i++ {
+		go func() {
+			e_sig_ryP := scheme.pairing.NewGT()
+
+			for index := range clientChan {
+				e_sig_ryP.Pair(clientSignatures[index], ryP)
+
+				hashed := sha256.Sum256(e_sig_ryP.Bytes())
+
+				_, serverHas := serverHashes[hashed]
+				if serverHas {
+					clientLock.Lock()
+					intersection = append(intersection, clientSet[index])
+					clientLock.Unlock()
+				}
+			}
+
+			clientWG.Done()
+		}()
+	}
+	clientWG.Wait()
+
+	totalTime := time.Since(startTime)
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
+
+// AtomicsThreadedInteraction is Interaction with numThreads workers claiming
+// signatures through an atomic counter.
+func (scheme *DualAPSIScheme) AtomicsThreadedInteraction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	numThreads int, opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+	if numThreads < 1 {
+		return 0, nil, ErrThreadCount
+	}
+
+	startTime := time.Now()
+
+	// Step 1: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	r := scheme.pairing.NewZr()
+	rxP := scheme.pairing.NewG1()
+	ryP := scheme.pairing.NewG1()
+
+	r.Rand()
+	rxP.MulZn(pk.XP, r)
+	ryP.MulZn(pk.YP, r)
+
+	serverHashes := make(map[[32]byte]bool)
+	var serverWG sync.WaitGroup
+	var serverLock sync.RWMutex
+
+	var serverNextIndex uint64 // serverNextIndex = 0
+	serverStopIndex := uint64(len(serverSignatures))
+
+	serverWG.Add(numThreads)
+	for i := 0; i < numThreads; i++ {
+		go func() {
+			e_sig_rxP := scheme.pairing.NewGT()
+
+			for {
+				index := atomic.AddUint64(&serverNextIndex, 1) - 1
+				if index >= serverStopIndex {
+					break
+				}
+
+				// Recall that serverSignature = H(c_i)^y.
+				e_sig_rxP.Pair(serverSignatures[index], rxP)
+				hashed := sha256.Sum256(e_sig_rxP.Bytes())
+
+				serverLock.Lock()
+				serverHashes[hashed] = true
+				serverLock.Unlock()
+			}
+
+			serverWG.Done()
+		}()
+	}
+	serverWG.Wait()
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+
+	var clientNextIndex uint64 // clientNextIndex = 0
+	clientStopIndex := uint64(len(clientSignatures))
+
+	var intersection RawElementSlice
+	var clientWG sync.WaitGroup
+	var clientLock sync.RWMutex
+
+	clientWG.Add(numThreads)
+	for i := 0; i < numThreads; i++ {
+		go func() {
+			e_sig_ryP := scheme.pairing.NewGT()
+
+			for {
+				index := atomic.AddUint64(&clientNextIndex, 1) - 1
+				if index >= clientStopIndex {
+					break
+				}
+
+				e_sig_ryP.Pair(clientSignatures[index], ryP)
+				hashed := sha256.Sum256(e_sig_ryP.Bytes())
+
+				_, serverHas := serverHashes[hashed]
+				if serverHas {
+					clientLock.Lock()
+					intersection = append(intersection, clientSet[index])
+					clientLock.Unlock()
+				}
+			}
+
+			clientWG.Done()
+		}()
+	}
+	clientWG.Wait()
+
+	totalTime := time.Since(startTime)
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
+
+// DivisionThreadedInteraction is Interaction with the signatures split into
+// numThreads contiguous chunks, one goroutine per chunk.
+func (scheme *DualAPSIScheme) DivisionThreadedInteraction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	numThreads int, opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+	if numThreads < 1 {
+		return 0, nil, ErrThreadCount
+	}
+
+	startTime := time.Now()
+
+	// Step 1: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	r := scheme.pairing.NewZr()
+	rxP := scheme.pairing.NewG1()
+	ryP := scheme.pairing.NewG1()
+
+	r.Rand()
+	rxP.MulZn(pk.XP, r)
+	ryP.MulZn(pk.YP, r)
+
+	serverHashes := make(map[[32]byte]bool)
+	var serverWG sync.WaitGroup
+	var serverLock sync.RWMutex
+
+	numServerElts := len(serverSignatures)
+	numPerServerThread := (numServerElts + numThreads - 1) / numThreads
+
+	for threadNum := 0; threadNum < numThreads; threadNum++ {
+		str := threadNum * numPerServerThread
+		if str >= numServerElts {
+			break
+		}
+		serverWG.Add(1)
+		go func(start int) {
+			// Recall that serverSignature = H(c_i)^y.
+			e_sig_rxP := scheme.pairing.NewGT()
+
+			end := start + numPerServerThread
+			if end > numServerElts {
+				end = numServerElts
+			}
+
+			for i := start; i < end; i++ {
+				e_sig_rxP.Pair(serverSignatures[i], rxP)
+				hashed := sha256.Sum256(e_sig_rxP.Bytes())
+
+				serverLock.Lock()
+				serverHashes[hashed] = true
+				serverLock.Unlock()
+			}
+
+			serverWG.Done()
+		}(str)
+	}
+	serverWG.Wait()
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+	var intersection RawElementSlice
+	var clientWG sync.WaitGroup
+	var clientLock sync.RWMutex
+
+	numClientElts := len(clientSignatures)
+	numPerClientThread := (numClientElts + numThreads - 1) / numThreads
+
+	for threadNum := 0; threadNum < numThreads; threadNum++ {
+		str := threadNum * numPerClientThread
+		if str >= numClientElts {
+			break
+		}
+		clientWG.Add(1)
+		go func(start int) {
+			e_sig_ryP := scheme.pairing.NewGT()
+
+			end := start + numPerClientThread
+			if end > numClientElts {
+				end = numClientElts
+			}
+
+			for i := start; i < end; i++ {
+				e_sig_ryP.Pair(clientSignatures[i], ryP)
+				hashed := sha256.Sum256(e_sig_ryP.Bytes())
+
+				_, serverHas := serverHashes[hashed]
+				if serverHas {
+					clientLock.Lock()
+					intersection = append(intersection, clientSet[i])
+					clientLock.Unlock()
+				}
+			}
+
+			clientWG.Done()
+		}(str)
+	}
+	clientWG.Wait()
+
+	totalTime := time.Since(startTime)
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
+
+// PrecomputeThreadedInteraction moves the server's pairings e(yH(s_j), xP)
+// out of the timed online phase, leaving only an exponentiation by r per
+// server element. The returned duration covers the online phase only.
+func (scheme *DualAPSIScheme) PrecomputeThreadedInteraction(
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element,
+	opts ...InteractionOption) (time.Duration, RawElementSlice, error) {
+
+	cfg := newInteractionConfig(opts)
+	pk, clientSet, clientSignatures, serverSet, serverSignatures, err :=
+		scheme.prepare(cfg, clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Precomputation phase.
+	// Server precomputes e(H(s_j)^y, P^x) (missing r)
+	var pairedSignatures []*pbc.Element
+	var pairedSignaturesLock sync.RWMutex
+	var pairedSignaturesWG sync.WaitGroup
+	pairedSignaturesWG.Add(len(serverSignatures))
+	for _, serverSignature := range serverSignatures {
+		go func(signature *pbc.Element) {
+			e_sig_xP := scheme.pairing.NewGT()
+			e_sig_xP.Pair(signature, pk.XP)
+
+			pairedSignaturesLock.Lock()
+			pairedSignatures = append(pairedSignatures, e_sig_xP)
+			pairedSignaturesLock.Unlock()
+
+			pairedSignaturesWG.Done()
+		}(serverSignature)
+	}
+	pairedSignaturesWG.Wait()
+
+	// Online phase.
+
+	startTime := time.Now()
+
+	// Step 2: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	r := scheme.pairing.NewZr()
+	ryP := scheme.pairing.NewG1()
+	r.Rand()
+	ryP.MulZn(pk.YP, r)
+
+	serverHashes := make(map[[32]byte]bool)
+	var serverWG sync.WaitGroup
+	var serverLock sync.RWMutex
+	serverWG.Add(len(pairedSignatures))
+	for _, pairedSignature := range pairedSignatures {
+		go func(pairedSignature *pbc.Element) {
+			// Recall that pairedSignature = H(c_i)^y.
+			e_sig_rxP := scheme.pairing.NewGT()
+			e_sig_rxP.PowZn(pairedSignature, r)
+
+			hashed := sha256.Sum256(e_sig_rxP.Bytes())
+
+			serverLock.Lock()
+			serverHashes[hashed] = true
+			serverLock.Unlock()
+
+			serverWG.Done()
+		}(pairedSignature)
+	}
+	serverWG.Wait()
+
+	// Step 3: C computes u_i = e(H(c_i)^x, P^y)^r_c
+	var intersection RawElementSlice
+	var clientWG sync.WaitGroup
+	var clientLock sync.RWMutex
+	clientWG.Add(len(clientSignatures))
+	for i, clientSignature := range clientSignatures {
+		go func(signature *pbc.Element, index int) { // every thread in go has stack of 2KB, which isn't that bad 2kb * 100k elements = 200mb of stack
+			e_sig_ryP := scheme.pairing.NewGT()
+			e_sig_ryP.Pair(signature, ryP)
+
+			hashed := sha256.Sum256(e_sig_ryP.Bytes())
+
+			_, serverHas := serverHashes[hashed]
+			if serverHas {
+				clientLock.Lock()
+				intersection = append(intersection, clientSet[index])
+				clientLock.Unlock()
+			}
+
+			clientWG.Done()
+		}(clientSignature, i)
+	}
+	clientWG.Wait()
+
+	totalTime := time.Since(startTime)
+	if err := cfg.recordInteraction(pk, clientSet, serverSet, intersection); err != nil {
+		return 0, nil, err
+	}
+	return totalTime, intersection, nil
+}
@@ -0,0 +1,234 @@
+package apsi
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Key files are PEM documents. A public key file holds an "APSI PARAMS"
+// block with the pairing parameters in PBC's text format followed by an
+// "APSI PUBLIC KEY" block with P, xP and yP, and an "APSI DOCUMENT KEY"
+// block with zP. Threshold and joint keys sign no documents and leave the
+// document key out; an authority's key always has one. An authority key
+// file adds an "APSI SECRET KEY" block with x, y and z. Group elements are stored as a 2-byte length followed by
+// PBC's encoding of the element.
+const (
+	pemParams      = "APSI PARAMS"
+	pemPublicKey   = "APSI PUBLIC KEY"
+	pemDocumentKey = "APSI DOCUMENT KEY"
+	pemSecretKey   = "APSI SECRET KEY"
+)
+
+// ErrKeyMismatch is returned when a loaded secret key does not match the
+// public key stored next to it.
+var ErrKeyMismatch = errors.New("apsi: secret key does not match public key")
+
+// WritePublicKey writes the pairing parameters and PK_J, which is all that
+// clients and servers need from the authority.
+func (authority *Authority) WritePublicKey(w io.Writer) error {
+	return writePublicBlocks(w, authority.params, authority.pk)
+}
+
+// WriteKeys writes the pairing parameters, PK_J and SK_J. The output
+// contains the authority's secrets and must be stored accordingly.
+func (authority *Authority) WriteKeys(w io.Writer) error {
+	if err := authority.WritePublicKey(w); err != nil {
+		return err
+	}
+	return pemEncode(w, pemSecretKey, authority.sk.encode())
+}
+
+func writePublicBlocks(w io.Writer, params *pbc.Params, pk PublicKey) error {
+	if err := pemEncode(w, pemParams, []byte(params.String())); err != nil {
+		return err
+	}
+	if err := pemEncode(w, pemPublicKey, encodeElements(pk.P, pk.XP, pk.YP)); err != nil {
+		return err
+	}
+	if pk.ZP == nil {
+		return nil
+	}
+	return pemEncode(w, pemDocumentKey, encodeElements(pk.ZP))
+}
+
+func (sk *SecretKey) encode() []byte {
+	return encodeElements(sk.X, sk.Y, sk.Z)
+}
+
+func decodeSecretKey(pairing *pbc.Pairing, data []byte) (SecretKey, error) {
+	elements, err := decodeElements(data, pairing.NewZr, pairing.NewZr, pairing.NewZr)
+	if err != nil {
+		return SecretKey{}, err
+	}
+	return SecretKey{X: elements[0], Y: elements[1], Z: elements[2]}, nil
+}
+
+func pemEncode(w io.Writer, blockType string, data []byte) error {
+	return pem.Encode(w, &pem.Block{Type: blockType, Bytes: data})
+}
+
+// ReadPublicKey reads a file written by WritePublicKey (or WriteKeys, in
+// which case the secret key is ignored) and returns the pairing and PK_J.
+func ReadPublicKey(r io.Reader) (*pbc.Pairing, PublicKey, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, PublicKey{}, err
+	}
+	_, pairing, pk, err := decodePublicBlocks(blocks)
+	return pairing, pk, err
+}
+
+// ReadAuthority reads a file written by WriteKeys. It checks that xP, yP
+// and zP match x, y and z before returning the authority.
+func ReadAuthority(r io.Reader) (*Authority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, pairing, pk, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := blocks[pemSecretKey]
+	if _, encrypted := blocks[pemEncryptedSecretKey]; !ok && encrypted {
+		return nil, ErrKeyEncrypted
+	}
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemSecretKey)
+	}
+	sk, err := decodeSecretKey(pairing, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPair(pairing, pk, sk); err != nil {
+		return nil, err
+	}
+	return &Authority{params: params, pairing: pairing, pk: pk, sk: sk}, nil
+}
+
+// checkKeyPair makes sure that xP, yP and zP were derived from x, y and z.
+func checkKeyPair(pairing *pbc.Pairing, pk PublicKey, sk SecretKey) error {
+	if pk.ZP == nil {
+		return fmt.Errorf("apsi: key file has no %s block", pemDocumentKey)
+	}
+	xP := pairing.NewG1().MulZn(pk.P, sk.X)
+	yP := pairing.NewG1().MulZn(pk.P, sk.Y)
+	zP := pairing.NewG1().MulZn(pk.P, sk.Z)
+	if !xP.Equals(pk.XP) || !yP.Equals(pk.YP) || !zP.Equals(pk.ZP) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+func readBlocks(r io.Reader) (map[string][]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make(map[string][]byte)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if _, dup := blocks[block.Type]; dup {
+			return nil, fmt.Errorf("apsi: key file has more than one %s block", block.Type)
+		}
+		blocks[block.Type] = block.Bytes
+	}
+	return blocks, nil
+}
+
+func decodeParamsBlock(blocks map[string][]byte) (*pbc.Params, *pbc.Pairing, error) {
+	paramsText, ok := blocks[pemParams]
+	if !ok {
+		return nil, nil, fmt.Errorf("apsi: key file has no %s block", pemParams)
+	}
+	params, err := pbc.NewParamsFromString(string(paramsText))
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, params.NewPairing(), nil
+}
+
+func decodePublicBlocks(blocks map[string][]byte) (*pbc.Params, *pbc.Pairing, PublicKey, error) {
+	params, pairing, err := decodeParamsBlock(blocks)
+	if err != nil {
+		return nil, nil, PublicKey{}, err
+	}
+
+	public, ok := blocks[pemPublicKey]
+	if !ok {
+		return nil, nil, PublicKey{}, fmt.Errorf("apsi: key file has no %s block", pemPublicKey)
+	}
+	elements, err := decodeElements(public, pairing.NewG1, pairing.NewG1, pairing.NewG1)
+	if err != nil {
+		return nil, nil, PublicKey{}, err
+	}
+	pk := PublicKey{P: elements[0], XP: elements[1], YP: elements[2]}
+	if document, ok := blocks[pemDocumentKey]; ok {
+		zP, err := decodeElements(document, pairing.NewG1)
+		if err != nil {
+			return nil, nil, PublicKey{}, err
+		}
+		pk.ZP = zP[0]
+		elements = append(elements, pk.ZP)
+	}
+	for _, element := range elements {
+		if element.Is0() {
+			return nil, nil, PublicKey{}, errors.New("apsi: public key contains the identity")
+		}
+	}
+	return params, pairing, pk, nil
+}
+
+func encodeElements(elements ...*pbc.Element) []byte {
+	var b []byte
+	for _, element := range elements {
+		b = appendBytes16(b, element.Bytes())
+	}
+	return b
+}
+
+// decodeElements reads one element per constructor, each from the field the
+// constructor creates, and requires the data to hold nothing else.
+func decodeElements(data []byte, fields ...func() *pbc.Element) ([]*pbc.Element, error) {
+	r := &wireReader{buf: data}
+	elements := make([]*pbc.Element, len(fields))
+	for i, newElement := range fields {
+		element := newElement()
+		encoded := r.bytes16()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(encoded) != element.BytesLen() {
+			return nil, ErrMalformedMessage
+		}
+		elements[i] = element.SetBytes(encoded)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
+
+// ExtractPublicKey copies the public half of a key file from r to w. It
+// works on plaintext and encrypted key files alike and never touches the
+// secret key.
+func ExtractPublicKey(r io.Reader, w io.Writer) error {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return err
+	}
+	params, _, pk, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return err
+	}
+	return writePublicBlocks(w, params, pk)
+}
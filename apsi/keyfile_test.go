@@ -0,0 +1,30 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestReadAuthorityNeedsDocumentKey(t *testing.T) {
+	_, authority := NewAuthority()
+	var keys bytes.Buffer
+	if err := authority.WriteKeys(&keys); err != nil {
+		t.Fatal(err)
+	}
+	var stripped bytes.Buffer
+	for data := keys.Bytes(); ; {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != pemDocumentKey {
+			pem.Encode(&stripped, block)
+		}
+	}
+	_, err := ReadAuthority(&stripped)
+	if err == nil || !strings.Contains(err.Error(), pemDocumentKey) {
+		t.Errorf("ReadAuthority = %v, want a missing %s block", err, pemDocumentKey)
+	}
+}
@@ -0,0 +1,46 @@
+package apsi
+
+import (
+	"github.com/Nik-U/pbc"
+)
+
+// PublicKey is the judge's public key PK_J = (P, xP, yP), along with zP
+// for checking the documents the judge signs. Every authority's key has
+// zP; ZP is nil only for threshold and joint keys, which sign no documents.
+type PublicKey struct {
+	P  *pbc.Element
+	XP *pbc.Element
+	YP *pbc.Element
+	ZP *pbc.Element
+}
+
+func (pk *PublicKey) forParty(party Party) (*pbc.Element, error) {
+	switch party {
+	case ClientParty:
+		return pk.XP, nil
+	case ServerParty:
+		return pk.YP, nil
+	}
+	return nil, ErrUnknownParty
+}
+
+// SecretKey is the judge's secret key SK_J = (x, y) and its document key
+// z. x authorizes client elements and y authorizes server elements. z signs
+// revocation lists, client registries, certificates, delegations and blind
+// keys and never an element, so that no signature handed out as an authorization,
+// blindly or not, can pass for a document.
+type SecretKey struct {
+	X *pbc.Element
+	Y *pbc.Element
+	Z *pbc.Element
+}
+
+func (sk *SecretKey) forParty(party Party) (*pbc.Element, error) {
+	switch party {
+	case ClientParty:
+		return sk.X, nil
+	case ServerParty:
+		return sk.Y, nil
+	}
+	return nil, ErrUnknownParty
+}
@@ -0,0 +1,218 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// An encrypted key file replaces the "APSI SECRET KEY" block with an
+// "APSI ENCRYPTED SECRET KEY" block:
+//
+//	format (1) | argon2id time (4) | memory KiB (4) | threads (1) |
+//	salt (16) | nonce (12) | AES-256-GCM ciphertext of the secret key block
+//
+// The params and public key blocks are authenticated as additional data, so
+// a secret key cannot be moved under a different public key.
+const (
+	pemEncryptedSecretKey = "APSI ENCRYPTED SECRET KEY"
+
+	keystoreFormat    = 1
+	keystoreSaltSize  = 16
+	keystoreKeySize   = 32
+	keystoreHeaderLen = 1 + 4 + 4 + 1 + keystoreSaltSize
+)
+
+var (
+	// ErrWrongPassphrase is returned when an encrypted key file does not
+	// decrypt under the given passphrase, or has been tampered with.
+	ErrWrongPassphrase = errors.New("apsi: wrong passphrase or corrupted key file")
+
+	// ErrKDFParams is returned for KDF parameters outside the bounds below,
+	// whether passed in or read from a key file.
+	ErrKDFParams = errors.New("apsi: KDF parameters out of range")
+
+	// ErrKeyEncrypted is returned by ReadAuthority for an encrypted key
+	// file; use ReadEncryptedAuthority instead.
+	ErrKeyEncrypted = errors.New("apsi: key file is encrypted")
+)
+
+// KDFParams are the argon2id parameters used to derive a key file's
+// encryption key from its passphrase.
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// DefaultKDFParams follow the argon2id recommendation of RFC 9106 for
+// memory-constrained environments: 3 passes over 64 MiB.
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// Bounds on KDFParams. The header of an encrypted block is only
+// authenticated once the key has been derived from it, so openBlock checks
+// the parameters first: too little work would make a forged file cheap to
+// attack, and too much would let one exhaust memory or CPU.
+const (
+	minKDFMemory  = 8 * 1024    // 8 MiB
+	maxKDFMemory  = 1024 * 1024 // 1 GiB
+	maxKDFTime    = 64
+	maxKDFThreads = 64
+)
+
+func (kdf KDFParams) check() error {
+	if kdf.Time < 1 || kdf.Time > maxKDFTime ||
+		kdf.Memory < minKDFMemory || kdf.Memory > maxKDFMemory ||
+		kdf.Threads < 1 || kdf.Threads > maxKDFThreads {
+		return ErrKDFParams
+	}
+	return nil
+}
+
+func (kdf KDFParams) deriveKey(passphrase []byte, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, kdf.Time, kdf.Memory, kdf.Threads, keystoreKeySize)
+}
+
+// WriteEncryptedKeys is WriteKeys with SK_J encrypted under passphrase.
+func (authority *Authority) WriteEncryptedKeys(w io.Writer, passphrase []byte, kdf KDFParams) error {
+	var public bytes.Buffer
+	if err := writePublicBlocks(&public, authority.params, authority.pk); err != nil {
+		return err
+	}
+	block, err := sealBlock(authority.sk.encode(), public.Bytes(), passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(public.Bytes()); err != nil {
+		return err
+	}
+	return pemEncode(w, pemEncryptedSecretKey, block)
+}
+
+// ReadEncryptedAuthority reads a file written by WriteEncryptedKeys and
+// decrypts SK_J with passphrase.
+func ReadEncryptedAuthority(r io.Reader, passphrase []byte) (*Authority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, pairing, pk, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blocks[pemEncryptedSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemEncryptedSecretKey)
+	}
+
+	var public bytes.Buffer
+	if err := writePublicBlocks(&public, params, pk); err != nil {
+		return nil, err
+	}
+	plaintext, err := openBlock(block, public.Bytes(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := decodeSecretKey(pairing, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyPair(pairing, pk, sk); err != nil {
+		return nil, err
+	}
+	return &Authority{params: params, pairing: pairing, pk: pk, sk: sk}, nil
+}
+
+// sealBlock encrypts plaintext under passphrase into the encrypted block
+// layout above, authenticating public as additional data.
+func sealBlock(plaintext []byte, public []byte, passphrase []byte, kdf KDFParams) ([]byte, error) {
+	if err := kdf.check(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, keystoreHeaderLen)
+	header[0] = keystoreFormat
+	binary.BigEndian.PutUint32(header[1:], kdf.Time)
+	binary.BigEndian.PutUint32(header[5:], kdf.Memory)
+	header[9] = kdf.Threads
+	salt := header[10:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newKeystoreAEAD(kdf.deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, nonce, plaintext, keystoreAAD(header, public))
+	return append(append(header, nonce...), sealed...), nil
+}
+
+// openBlock reverses sealBlock.
+func openBlock(block []byte, public []byte, passphrase []byte) ([]byte, error) {
+	if len(block) < keystoreHeaderLen || block[0] != keystoreFormat {
+		return nil, ErrMalformedMessage
+	}
+	header := block[:keystoreHeaderLen]
+	kdf := KDFParams{
+		Time:    binary.BigEndian.Uint32(header[1:]),
+		Memory:  binary.BigEndian.Uint32(header[5:]),
+		Threads: header[9],
+	}
+	if err := kdf.check(); err != nil {
+		return nil, err
+	}
+	salt := header[10:]
+
+	aead, err := newKeystoreAEAD(kdf.deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	rest := block[keystoreHeaderLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrMalformedMessage
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, keystoreAAD(header, public))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+// IsEncryptedKeyFile reports whether data holds an encrypted secret key or
+// signer share.
+func IsEncryptedKeyFile(data []byte) bool {
+	blocks, err := readBlocks(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	_, ok := blocks[pemEncryptedSecretKey]
+	_, share := blocks[pemEncryptedSignerShare]
+	return ok || share
+}
+
+func newKeystoreAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func keystoreAAD(header []byte, public []byte) []byte {
+	return append(append([]byte(nil), header...), public...)
+}
@@ -0,0 +1,73 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"testing"
+)
+
+// testKDFParams are the cheapest parameters within bounds.
+var testKDFParams = KDFParams{Time: 1, Memory: minKDFMemory, Threads: 1}
+
+func TestEncryptedKeyRoundTrip(t *testing.T) {
+	_, authority := NewAuthority()
+	var keys bytes.Buffer
+	if err := authority.WriteEncryptedKeys(&keys, []byte("right"), testKDFParams); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedKeyFile(keys.Bytes()) {
+		t.Error("IsEncryptedKeyFile = false")
+	}
+	if _, err := ReadAuthority(bytes.NewReader(keys.Bytes())); err != ErrKeyEncrypted {
+		t.Errorf("ReadAuthority = %v, want %v", err, ErrKeyEncrypted)
+	}
+	if _, err := ReadEncryptedAuthority(bytes.NewReader(keys.Bytes()), []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("ReadEncryptedAuthority with the wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+	read, err := ReadEncryptedAuthority(bytes.NewReader(keys.Bytes()), []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.PublicKey().ID() != authority.PublicKey().ID() || !read.sk.X.Equals(authority.sk.X) ||
+		!read.sk.Y.Equals(authority.sk.Y) || !read.sk.Z.Equals(authority.sk.Z) {
+		t.Error("decrypted key differs from the one written")
+	}
+}
+
+func TestKDFParamsBounds(t *testing.T) {
+	_, authority := NewAuthority()
+	for _, kdf := range []KDFParams{
+		{Time: 0, Memory: minKDFMemory, Threads: 1},
+		{Time: maxKDFTime + 1, Memory: minKDFMemory, Threads: 1},
+		{Time: 1, Memory: minKDFMemory - 1, Threads: 1},
+		{Time: 1, Memory: maxKDFMemory + 1, Threads: 1},
+		{Time: 1, Memory: minKDFMemory, Threads: 0},
+		{Time: 1, Memory: minKDFMemory, Threads: maxKDFThreads + 1},
+	} {
+		if err := authority.WriteEncryptedKeys(&bytes.Buffer{}, []byte("pw"), kdf); err != ErrKDFParams {
+			t.Errorf("WriteEncryptedKeys with %+v = %v, want %v", kdf, err, ErrKDFParams)
+		}
+	}
+
+	// A file claiming parameters out of bounds is refused before any key
+	// is derived from it.
+	var keys bytes.Buffer
+	if err := authority.WriteEncryptedKeys(&keys, []byte("pw"), testKDFParams); err != nil {
+		t.Fatal(err)
+	}
+	var forged bytes.Buffer
+	for data := keys.Bytes(); ; {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type == pemEncryptedSecretKey {
+			binary.BigEndian.PutUint32(block.Bytes[5:], maxKDFMemory+1)
+		}
+		pem.Encode(&forged, block)
+	}
+	if _, err := ReadEncryptedAuthority(&forged, []byte("pw")); err != ErrKDFParams {
+		t.Errorf("ReadEncryptedAuthority with forged parameters = %v, want %v", err, ErrKDFParams)
+	}
+}
@@ -0,0 +1,49 @@
+package apsi
+
+import (
+	"crypto/sha256"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Tag is the hash of a pairing value t_j or u_i. Tags are all that the
+// server reveals about its set.
+type Tag [32]byte
+
+func tagOf(paired *pbc.Element) Tag {
+	return sha256.Sum256(paired.Bytes())
+}
+
+// BlindingMessage is the client's first flow, C -> S: rxP, where r is the
+// client's ephemeral secret for the session, along with the epoch the
+// client's authorizations belong to, the digest of their context and the
+// version of the revocation list it applied. KeyID names the key version RxP was computed under, and
+// Alternates offers the client's other key versions in order of preference.
+// A client with its own key names itself in ClientID and proves each
+// blinding was computed from that key.
+type BlindingMessage struct {
+	RxP               *pbc.Element
+	Epoch             Epoch
+	Context           ContextDigest
+	RevocationVersion uint64
+	KeyID             KeyID
+	Alternates        []KeyBlinding
+	ClientID          ClientID
+	Proof             *KeyProof
+}
+
+// KeyBlinding is rxP for one more key version, with its own r.
+type KeyBlinding struct {
+	KeyID KeyID
+	RxP   *pbc.Element
+	Proof *KeyProof
+}
+
+// TagSetMessage is the server's reply, S -> C: {t_0, ..., t_{n-1}} where
+// t_j = e(yH(s_j), rxP). Tags are sorted so that their order says nothing
+// about the order of the server's set. KeyID names the key version the
+// server chose.
+type TagSetMessage struct {
+	Tags  []Tag
+	KeyID KeyID
+}
@@ -0,0 +1,378 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// With independent authorities, the client's authority holds x and the
+// server's authority holds y, and neither ever sees the other's secret.
+// They agree on a shared setup first: pairing parameters and a generator P
+// hashed from a public label, so that P is fixed by the label and neither
+// side picks it. Each then draws its own secret and publishes a party key,
+// (P, xP) or (P, yP). Joined, the two party keys are exactly PK_J = (P, xP,
+// yP), and clients and servers run the protocol under it unchanged.
+
+var (
+	// ErrWrongParty is returned when a party authority is asked to sign for
+	// the other side.
+	ErrWrongParty = errors.New("apsi: authority does not issue for this party")
+
+	// ErrSetupMismatch is returned when joining party keys that were not
+	// generated from the same shared setup.
+	ErrSetupMismatch = errors.New("apsi: party keys come from different setups")
+)
+
+// SharedSetup is what independent authorities agree on before generating
+// their keys: the pairing parameters and the label P is derived from.
+type SharedSetup struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	Label string
+	P     *pbc.Element
+}
+
+// NewSharedSetup generates fresh pairing parameters and derives P from
+// label.
+func NewSharedSetup(label string) (*SharedSetup, error) {
+	return DeriveSharedSetup(pbc.GenerateA(160, 512), label)
+}
+
+// DeriveSharedSetup derives P from label under existing pairing parameters.
+// Anyone holding params and label arrives at the same P.
+func DeriveSharedSetup(params *pbc.Params, label string) (*SharedSetup, error) {
+	if len(label) == 0 || len(label) > 255 {
+		return nil, errors.New("apsi: setup label must be 1 to 255 bytes")
+	}
+	pairing := params.NewPairing()
+	h := sha256.New()
+	h.Write([]byte("apsi-shared-generator-v1"))
+	h.Write([]byte(label))
+	return &SharedSetup{
+		params:  params,
+		pairing: pairing,
+		Label:   label,
+		P:       pairing.NewG1().SetFromHash(h.Sum(nil)),
+	}, nil
+}
+
+// Pairing returns the pairing the setup lives in.
+func (setup *SharedSetup) Pairing() *pbc.Pairing {
+	return setup.pairing
+}
+
+// PartyPublicKey is one independent authority's public key: P and xP for
+// the client's authority, P and yP for the server's.
+type PartyPublicKey struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	Party Party
+	P     *pbc.Element
+	Key   *pbc.Element
+}
+
+// Pairing returns the pairing the key lives in.
+func (ppk *PartyPublicKey) Pairing() *pbc.Pairing {
+	return ppk.pairing
+}
+
+// PartyAuthority is the authority for one side only. It holds x if it
+// issues for the client and y if it issues for the server.
+type PartyAuthority struct {
+	pk     *PartyPublicKey
+	secret *pbc.Element
+}
+
+// NewPartyAuthority draws the secret for party under setup and returns the
+// time it took along with the new authority.
+func NewPartyAuthority(setup *SharedSetup, party Party) (time.Duration, *PartyAuthority, error) {
+	if party != ClientParty && party != ServerParty {
+		return 0, nil, ErrUnknownParty
+	}
+	startSetup := time.Now()
+
+	secret := setup.pairing.NewZr().Rand()
+	key := setup.pairing.NewG1().MulZn(setup.P, secret)
+
+	setupTime := time.Since(startSetup)
+	return setupTime, &PartyAuthority{
+		pk: &PartyPublicKey{
+			params:  setup.params,
+			pairing: setup.pairing,
+			Party:   party,
+			P:       setup.P,
+			Key:     key,
+		},
+		secret: secret,
+	}, nil
+}
+
+// Party returns the side the authority issues for.
+func (authority *PartyAuthority) Party() Party {
+	return authority.pk.Party
+}
+
+// Pairing returns the pairing the authority's keys live in.
+func (authority *PartyAuthority) Pairing() *pbc.Pairing {
+	return authority.pk.pairing
+}
+
+// PublicKey returns the authority's party key.
+func (authority *PartyAuthority) PublicKey() *PartyPublicKey {
+	return authority.pk
+}
+
+// Authorize signs elt as Authority.Authorize does. party must be the
+// authority's own side.
+func (authority *PartyAuthority) Authorize(elt RawElement, party Party, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	if party != authority.pk.Party {
+		return 0, nil, ErrWrongParty
+	}
+
+	startTime := time.Now()
+
+	pairing := authority.pk.pairing
+	signature := pairing.NewG1().MulZn(newBinding(opts).hash(pairing, elt), authority.secret)
+
+	totalTime := time.Since(startTime)
+	return totalTime, signature, nil
+}
+
+// AuthorizeSet signs every element of elements for the given party. The
+// returned duration is the total signing time.
+func (authority *PartyAuthority) AuthorizeSet(elements RawElementSlice, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	var totalTime time.Duration
+	signatures := make([]*pbc.Element, len(elements))
+	for i, element := range elements {
+		signingTime, signature, err := authority.Authorize(element, party, opts...)
+		if err != nil {
+			return totalTime, nil, err
+		}
+		signatures[i] = signature
+		totalTime += signingTime
+	}
+	return totalTime, signatures, nil
+}
+
+// JointPublicKey is PK_J assembled from the client's and the server's party
+// keys.
+type JointPublicKey struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	PublicKey
+}
+
+// JoinPublicKeys assembles PK_J = (P, xP, yP) from the client authority's
+// key (P, xP) and the server authority's key (P, yP). Both must come from
+// the same shared setup.
+func JoinPublicKeys(client, server *PartyPublicKey) (*JointPublicKey, error) {
+	if client.Party != ClientParty || server.Party != ServerParty {
+		return nil, ErrWrongParty
+	}
+	if client.params.String() != server.params.String() ||
+		!bytes.Equal(client.P.Bytes(), server.P.Bytes()) {
+		return nil, ErrSetupMismatch
+	}
+	pairing := client.pairing
+	return &JointPublicKey{
+		params:  client.params,
+		pairing: pairing,
+		PublicKey: PublicKey{
+			P:  client.P,
+			XP: client.Key,
+			YP: pairing.NewG1().SetBytes(server.Key.Bytes()),
+		},
+	}, nil
+}
+
+// Pairing returns the pairing the key lives in.
+func (jpk *JointPublicKey) Pairing() *pbc.Pairing {
+	return jpk.pairing
+}
+
+// WritePublicKey writes the joint key in the format of
+// Authority.WritePublicKey, so ReadPublicKey reads it like any other PK_J.
+func (jpk *JointPublicKey) WritePublicKey(w io.Writer) error {
+	return writePublicBlocks(w, jpk.params, jpk.PublicKey)
+}
+
+// A setup file holds the params block followed by an "APSI SHARED SETUP"
+// block with the label. P is not stored: readers derive it again, which is
+// what makes it trustworthy. A party key file holds the params block and an
+// "APSI PARTY KEY" block with the party (1), P and the party's key; an
+// authority file adds the secret in an "APSI SECRET KEY" block, or in an
+// encrypted one as for Authority.WriteEncryptedKeys.
+const (
+	pemSharedSetup = "APSI SHARED SETUP"
+	pemPartyKey    = "APSI PARTY KEY"
+)
+
+// Write writes the setup for distribution to both authorities.
+func (setup *SharedSetup) Write(w io.Writer) error {
+	if err := pemEncode(w, pemParams, []byte(setup.params.String())); err != nil {
+		return err
+	}
+	return pemEncode(w, pemSharedSetup, []byte(setup.Label))
+}
+
+// ReadSharedSetup reads a file written by SharedSetup.Write and derives P.
+func ReadSharedSetup(r io.Reader) (*SharedSetup, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, _, err := decodeParamsBlock(blocks)
+	if err != nil {
+		return nil, err
+	}
+	label, ok := blocks[pemSharedSetup]
+	if !ok {
+		return nil, fmt.Errorf("apsi: setup file has no %s block", pemSharedSetup)
+	}
+	return DeriveSharedSetup(params, string(label))
+}
+
+// Write writes the party key for publication.
+func (ppk *PartyPublicKey) Write(w io.Writer) error {
+	if err := pemEncode(w, pemParams, []byte(ppk.params.String())); err != nil {
+		return err
+	}
+	return pemEncode(w, pemPartyKey, append([]byte{byte(ppk.Party)}, encodeElements(ppk.P, ppk.Key)...))
+}
+
+// ReadPartyPublicKey reads a file written by PartyPublicKey.Write, or a
+// party authority's key file, in which case the secret is ignored.
+func ReadPartyPublicKey(r io.Reader) (*PartyPublicKey, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodePartyKeyBlocks(blocks)
+}
+
+func decodePartyKeyBlocks(blocks map[string][]byte) (*PartyPublicKey, error) {
+	params, pairing, err := decodeParamsBlock(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemPartyKey]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemPartyKey)
+	}
+	if len(data) < 1 {
+		return nil, ErrMalformedMessage
+	}
+	party := Party(data[0])
+	if party != ClientParty && party != ServerParty {
+		return nil, ErrUnknownParty
+	}
+	elements, err := decodeElements(data[1:], pairing.NewG1, pairing.NewG1)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range elements {
+		if element.Is0() {
+			return nil, errors.New("apsi: party key contains the identity")
+		}
+	}
+	return &PartyPublicKey{
+		params:  params,
+		pairing: pairing,
+		Party:   party,
+		P:       elements[0],
+		Key:     elements[1],
+	}, nil
+}
+
+// WriteKeys writes the party key followed by the authority's secret in the
+// clear. The output must be stored like an authority key file.
+func (authority *PartyAuthority) WriteKeys(w io.Writer) error {
+	if err := authority.pk.Write(w); err != nil {
+		return err
+	}
+	return pemEncode(w, pemSecretKey, encodeElements(authority.secret))
+}
+
+// WriteEncryptedKeys is WriteKeys with the secret encrypted under
+// passphrase, in the same format as Authority.WriteEncryptedKeys.
+func (authority *PartyAuthority) WriteEncryptedKeys(w io.Writer, passphrase []byte, kdf KDFParams) error {
+	var public bytes.Buffer
+	if err := authority.pk.Write(&public); err != nil {
+		return err
+	}
+	block, err := sealBlock(encodeElements(authority.secret), public.Bytes(), passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(public.Bytes()); err != nil {
+		return err
+	}
+	return pemEncode(w, pemEncryptedSecretKey, block)
+}
+
+// ReadPartyAuthority reads a file written by PartyAuthority.WriteKeys. It
+// checks the secret against the party key.
+func ReadPartyAuthority(r io.Reader) (*PartyAuthority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	ppk, err := decodePartyKeyBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemSecretKey]
+	if _, encrypted := blocks[pemEncryptedSecretKey]; !ok && encrypted {
+		return nil, ErrKeyEncrypted
+	}
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemSecretKey)
+	}
+	return decodePartySecret(ppk, data)
+}
+
+// ReadEncryptedPartyAuthority reads a file written by
+// PartyAuthority.WriteEncryptedKeys and decrypts the secret with passphrase.
+func ReadEncryptedPartyAuthority(r io.Reader, passphrase []byte) (*PartyAuthority, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	ppk, err := decodePartyKeyBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blocks[pemEncryptedSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemEncryptedSecretKey)
+	}
+	var public bytes.Buffer
+	if err := ppk.Write(&public); err != nil {
+		return nil, err
+	}
+	data, err := openBlock(block, public.Bytes(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return decodePartySecret(ppk, data)
+}
+
+func decodePartySecret(ppk *PartyPublicKey, data []byte) (*PartyAuthority, error) {
+	elements, err := decodeElements(data, ppk.pairing.NewZr)
+	if err != nil {
+		return nil, err
+	}
+	if !ppk.pairing.NewG1().MulZn(ppk.P, elements[0]).Equals(ppk.Key) {
+		return nil, ErrKeyMismatch
+	}
+	return &PartyAuthority{pk: ppk, secret: elements[0]}, nil
+}
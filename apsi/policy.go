@@ -0,0 +1,578 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A policy says what the authority may sign for each party, and a
+// PolicyEngine checks every request against it before the authority signs
+// anything. Policies are JSON so that they can be written and reviewed by
+// whoever runs the authority:
+//
+//	{
+//	  "client": {
+//	    "allow": ["0a0b0c0d", "0a0b0c0e"],
+//	    "patterns": ["0a??????"],
+//	    "quota": {"limit": 1000, "period": "24h"},
+//	    "require": ["case", "reason"]
+//	  },
+//	  "server": {"patterns": ["????????"]}
+//	}
+//
+// Each element must be in allow if allow is given and match one of the
+// patterns if any are given, where a pattern is the element in hex with ?
+// for any digit. A quota caps how many elements each requester may have
+// signed for the party per period, and require names the justification
+// fields every request must fill in. A party the policy leaves out may not
+// be signed for at all. Blinded requests hide their elements, so a party
+// with allow or patterns is never signed for blindly.
+
+// ErrPolicyFormat is returned for a policy that cannot be parsed.
+var ErrPolicyFormat = errors.New("apsi: malformed policy")
+
+// PolicyRule names the rule a request was denied by.
+type PolicyRule string
+
+// The rules a policy can deny a request by.
+const (
+	RuleParty         PolicyRule = "party"
+	RuleAllow         PolicyRule = "allow"
+	RulePattern       PolicyRule = "pattern"
+	RuleQuota         PolicyRule = "quota"
+	RuleJustification PolicyRule = "require"
+)
+
+// PolicyDenial is one reason a request was denied. Index is the position
+// of the element it concerns in the request, or -1 if it concerns the
+// whole request.
+type PolicyDenial struct {
+	Rule   PolicyRule
+	Index  int
+	Detail string
+}
+
+// PolicyError lists every reason a request was denied.
+type PolicyError struct {
+	Party   Party
+	Denials []PolicyDenial
+}
+
+func (e *PolicyError) Error() string {
+	reasons := make([]string, len(e.Denials))
+	for i, d := range e.Denials {
+		if d.Index >= 0 {
+			reasons[i] = fmt.Sprintf("%s: element %d: %s", d.Rule, d.Index, d.Detail)
+		} else {
+			reasons[i] = fmt.Sprintf("%s: %s", d.Rule, d.Detail)
+		}
+	}
+	return fmt.Sprintf("apsi: policy denies %s request: %s", e.Party, strings.Join(reasons, "; "))
+}
+
+// PartyPolicy is the policy for one party's authorizations.
+type PartyPolicy struct {
+	Allow    []string     `json:"allow,omitempty"`
+	Patterns []string     `json:"patterns,omitempty"`
+	Quota    *PolicyQuota `json:"quota,omitempty"`
+	Require  []string     `json:"require,omitempty"`
+
+	allow    map[RawElement]bool
+	patterns []elementPattern
+	period   time.Duration
+}
+
+// PolicyQuota caps the elements signed for each requester of a party per
+// period, e.g. "24h".
+type PolicyQuota struct {
+	Limit  int    `json:"limit"`
+	Period string `json:"period"`
+}
+
+// Policy is the authority's policy for both parties.
+type Policy struct {
+	Client *PartyPolicy `json:"client,omitempty"`
+	Server *PartyPolicy `json:"server,omitempty"`
+}
+
+// ParsePolicy parses and checks a JSON policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrPolicyFormat, err)
+	}
+	for _, pp := range []*PartyPolicy{policy.Client, policy.Server} {
+		if pp == nil {
+			continue
+		}
+		if err := pp.compile(); err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrPolicyFormat, err)
+		}
+	}
+	return &policy, nil
+}
+
+// ReadPolicy reads a JSON policy.
+func ReadPolicy(r io.Reader) (*Policy, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// LoadPolicy reads the JSON policy at path.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPolicy(f)
+}
+
+func (policy *Policy) forParty(party Party) (*PartyPolicy, error) {
+	switch party {
+	case ClientParty:
+		return policy.Client, nil
+	case ServerParty:
+		return policy.Server, nil
+	}
+	return nil, ErrUnknownParty
+}
+
+// elementPattern matches the elements whose bits under mask equal value.
+type elementPattern struct {
+	value, mask RawElement
+}
+
+func parseElementPattern(s string) (elementPattern, error) {
+	var p elementPattern
+	if len(s) != 2*len(p.value) {
+		return p, fmt.Errorf("pattern %q is not %d hex digits", s, 2*len(p.value))
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '?' {
+			continue
+		}
+		digit, err := hex.DecodeString("0" + s[i:i+1])
+		if err != nil {
+			return p, fmt.Errorf("pattern %q: %q is not a hex digit or ?", s, s[i])
+		}
+		shift := uint(4 * (1 - i%2))
+		p.value[i/2] |= digit[0] << shift
+		p.mask[i/2] |= 0xf << shift
+	}
+	return p, nil
+}
+
+func (p elementPattern) matches(elt RawElement) bool {
+	for i := range elt {
+		if elt[i]&p.mask[i] != p.value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (pp *PartyPolicy) compile() error {
+	pp.allow = make(map[RawElement]bool, len(pp.Allow))
+	for _, s := range pp.Allow {
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != len(RawElement{}) {
+			return fmt.Errorf("allowed element %q is not %d hex bytes", s, len(RawElement{}))
+		}
+		var elt RawElement
+		copy(elt[:], b)
+		pp.allow[elt] = true
+	}
+	pp.patterns = nil
+	for _, s := range pp.Patterns {
+		p, err := parseElementPattern(s)
+		if err != nil {
+			return err
+		}
+		pp.patterns = append(pp.patterns, p)
+	}
+	if pp.Quota != nil {
+		period, err := time.ParseDuration(pp.Quota.Period)
+		if err != nil || period < time.Second {
+			return fmt.Errorf("quota period %q is not a duration of at least 1s", pp.Quota.Period)
+		}
+		if pp.Quota.Limit < 0 {
+			return errors.New("quota limit is negative")
+		}
+		pp.period = period
+	}
+	for _, field := range pp.Require {
+		if field == "" {
+			return errors.New("required justification field has no name")
+		}
+	}
+	return nil
+}
+
+// AuthorizationRequest is what a PolicyEngine is asked to sign: elements
+// for one party, bound by Options, with the justification the policy may
+// require. Requester names who asked, and is whose quota the request
+// counts against.
+type AuthorizationRequest struct {
+	Requester     string
+	Party         Party
+	Elements      RawElementSlice
+	Justification map[string]string
+	Options       []AuthorizeOption
+}
+
+// QuotaKey names one quota: a requester's for a party.
+type QuotaKey struct {
+	Requester string
+	Party     Party
+}
+
+// QuotaUsage is how many elements a requester has had signed for a party
+// in a quota period.
+type QuotaUsage struct {
+	Period Epoch
+	Used   int
+}
+
+// PolicyEngine signs requests with an authority once its policy allows
+// them. The policy can be replaced while the engine is in use.
+type PolicyEngine struct {
+	authority *Authority
+
+	mu        sync.Mutex
+	policy    *Policy
+	usage     map[QuotaKey]QuotaUsage
+	usagePath string
+}
+
+// NewPolicyEngine returns an engine that signs with authority under
+// policy.
+func NewPolicyEngine(authority *Authority, policy *Policy) *PolicyEngine {
+	return &PolicyEngine{
+		authority: authority,
+		policy:    policy,
+		usage:     make(map[QuotaKey]QuotaUsage),
+	}
+}
+
+// SetPolicy replaces the engine's policy. Requests already being signed
+// finish under the old one, and quota usage carries over.
+func (engine *PolicyEngine) SetPolicy(policy *Policy) {
+	engine.mu.Lock()
+	engine.policy = policy
+	engine.mu.Unlock()
+}
+
+// Reload replaces the engine's policy with the one at path. The old
+// policy stays in place if the new one cannot be read.
+func (engine *PolicyEngine) Reload(path string) error {
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	engine.SetPolicy(policy)
+	return nil
+}
+
+// Usage returns the quota usage recorded so far, for saving between runs.
+func (engine *PolicyEngine) Usage() map[QuotaKey]QuotaUsage {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	usage := make(map[QuotaKey]QuotaUsage, len(engine.usage))
+	for key, u := range engine.usage {
+		usage[key] = u
+	}
+	return usage
+}
+
+// RestoreUsage sets the quota usage to what Usage returned earlier.
+func (engine *PolicyEngine) RestoreUsage(usage map[QuotaKey]QuotaUsage) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	for key, u := range usage {
+		engine.usage[key] = u
+	}
+}
+
+// PersistUsage makes the engine keep its quota usage in the file at path,
+// starting from what the file holds. Every change is written back at once,
+// under a lock on the file's .lock file, and the file is read again before
+// each request is checked, so engines in several processes can share one
+// file without losing each other's counts.
+func (engine *PolicyEngine) PersistUsage(path string) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.usagePath = path
+	return engine.syncUsage(false, func() error { return nil })
+}
+
+// syncUsage runs update with the usage file, if there is one, locked and
+// read in, and writes the usage back if write is set and update succeeds.
+// The caller must hold engine.mu.
+func (engine *PolicyEngine) syncUsage(write bool, update func() error) error {
+	if engine.usagePath == "" {
+		return update()
+	}
+	lock, err := lockFile(engine.usagePath+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	if err := engine.readUsage(); err != nil {
+		return err
+	}
+	if err := update(); err != nil || !write {
+		return err
+	}
+	return engine.writeUsage()
+}
+
+// usageRecord is the JSON form of one quota's usage in a usage file.
+type usageRecord struct {
+	Requester string `json:"requester"`
+	Party     string `json:"party"`
+	Period    Epoch  `json:"period"`
+	Used      int    `json:"used"`
+}
+
+// readUsage replaces the usage with the usage file's. A missing file holds
+// no usage.
+func (engine *PolicyEngine) readUsage() error {
+	data, err := ioutil.ReadFile(engine.usagePath)
+	if os.IsNotExist(err) {
+		engine.usage = make(map[QuotaKey]QuotaUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var recs []usageRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return fmt.Errorf("apsi: %s: %v", engine.usagePath, err)
+	}
+	usage := make(map[QuotaKey]QuotaUsage, len(recs))
+	for _, rec := range recs {
+		key := QuotaKey{Requester: rec.Requester}
+		switch rec.Party {
+		case ClientParty.String():
+			key.Party = ClientParty
+		case ServerParty.String():
+			key.Party = ServerParty
+		default:
+			return fmt.Errorf("apsi: %s: %v", engine.usagePath, ErrUnknownParty)
+		}
+		usage[key] = QuotaUsage{Period: rec.Period, Used: rec.Used}
+	}
+	engine.usage = usage
+	return nil
+}
+
+// writeUsage replaces the usage file with the usage, sorted so that the
+// file reads the same for the same usage.
+func (engine *PolicyEngine) writeUsage() error {
+	recs := make([]usageRecord, 0, len(engine.usage))
+	for key, u := range engine.usage {
+		recs = append(recs, usageRecord{
+			Requester: key.Requester,
+			Party:     key.Party.String(),
+			Period:    u.Period,
+			Used:      u.Used,
+		})
+	}
+	sort.Slice(recs, func(a, b int) bool {
+		if recs[a].Requester != recs[b].Requester {
+			return recs[a].Requester < recs[b].Requester
+		}
+		return recs[a].Party < recs[b].Party
+	})
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(engine.usagePath, append(data, '\n'))
+}
+
+// Check evaluates req against the policy at now without signing it or
+// counting it against the quota.
+func (engine *PolicyEngine) Check(req *AuthorizationRequest, now time.Time) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	return engine.syncUsage(false, func() error {
+		return engine.check(req, 0, now)
+	})
+}
+
+// check evaluates req, along with blinded elements it cannot see, against
+// the policy at now.
+func (engine *PolicyEngine) check(req *AuthorizationRequest, blinded int, now time.Time) error {
+	pp, err := engine.policy.forParty(req.Party)
+	if err != nil {
+		return err
+	}
+	denied := &PolicyError{Party: req.Party}
+	deny := func(rule PolicyRule, index int, format string, args ...interface{}) {
+		denied.Denials = append(denied.Denials, PolicyDenial{rule, index, fmt.Sprintf(format, args...)})
+	}
+	if pp == nil {
+		deny(RuleParty, -1, "the policy does not allow signing for the %s", req.Party)
+		return denied
+	}
+
+	for _, field := range pp.Require {
+		if req.Justification[field] == "" {
+			deny(RuleJustification, -1, "justification field %q is required", field)
+		}
+	}
+	for i, elt := range req.Elements {
+		if len(pp.allow) > 0 && !pp.allow[elt] {
+			deny(RuleAllow, i, "%x is not on the allowlist", elt)
+		}
+		if len(pp.patterns) > 0 && !pp.matchesPattern(elt) {
+			deny(RulePattern, i, "%x matches no allowed pattern", elt)
+		}
+	}
+	if blinded > 0 && len(pp.allow) > 0 {
+		deny(RuleAllow, -1, "blinded elements cannot be checked against the allowlist")
+	}
+	if blinded > 0 && len(pp.patterns) > 0 {
+		deny(RulePattern, -1, "blinded elements cannot be checked against the patterns")
+	}
+	if pp.Quota != nil {
+		n := len(req.Elements) + blinded
+		used := engine.used(req.quotaKey(), pp, now)
+		if used+n > pp.Quota.Limit {
+			deny(RuleQuota, -1, "%d elements would exceed %q's quota of %d per %s (%d used)",
+				n, req.Requester, pp.Quota.Limit, pp.Quota.Period, used)
+		}
+	}
+	if len(denied.Denials) > 0 {
+		return denied
+	}
+	return nil
+}
+
+func (pp *PartyPolicy) matchesPattern(elt RawElement) bool {
+	for _, p := range pp.patterns {
+		if p.matches(elt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (req *AuthorizationRequest) quotaKey() QuotaKey {
+	return QuotaKey{Requester: req.Requester, Party: req.Party}
+}
+
+// used returns how many elements have been signed under key in the quota
+// period containing now.
+func (engine *PolicyEngine) used(key QuotaKey, pp *PartyPolicy, now time.Time) int {
+	u := engine.usage[key]
+	if u.Period != EpochOf(now, pp.period) {
+		return 0
+	}
+	return u.Used
+}
+
+// issue checks req and blinded further elements against the policy and,
+// if they are allowed, runs sign. The elements are counted against the
+// requester's quota, and written to the usage file, before sign runs, so
+// that requests signed at the same time cannot overshoot it together and
+// a crash cannot forget them; they are given back if sign fails.
+func (engine *PolicyEngine) issue(req *AuthorizationRequest, blinded int, sign func() error) error {
+	now := time.Now()
+	key, n := req.quotaKey(), len(req.Elements)+blinded
+	var quota bool
+	var period Epoch
+	engine.mu.Lock()
+	err := engine.syncUsage(true, func() error {
+		if err := engine.check(req, blinded, now); err != nil {
+			return err
+		}
+		pp, _ := engine.policy.forParty(req.Party)
+		if quota = pp.Quota != nil; quota {
+			period = EpochOf(now, pp.period)
+			engine.usage[key] = QuotaUsage{Period: period, Used: engine.used(key, pp, now) + n}
+		}
+		return nil
+	})
+	engine.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = sign()
+	if err != nil && quota {
+		engine.mu.Lock()
+		engine.syncUsage(true, func() error {
+			if u := engine.usage[key]; u.Period == period {
+				u.Used -= n
+				engine.usage[key] = u
+			}
+			return nil
+		})
+		engine.mu.Unlock()
+	}
+	return err
+}
+
+// AuthorizeSet checks req against the policy and, if it is allowed, signs
+// it and counts it against the requester's quota. A denied request fails
+// with a *PolicyError.
+func (engine *PolicyEngine) AuthorizeSet(req *AuthorizationRequest) (time.Duration, []*pbc.Element, error) {
+	var signingTime time.Duration
+	var signatures []*pbc.Element
+	err := engine.issue(req, 0, func() (err error) {
+		signingTime, signatures, err = engine.authority.AuthorizeSet(req.Elements, req.Party, req.Options...)
+		return err
+	})
+	return signingTime, signatures, err
+}
+
+// AuthorizeAggregate is AuthorizeSet for a single aggregate authorization
+// of req's elements, as Authority.AuthorizeAggregate issues.
+func (engine *PolicyEngine) AuthorizeAggregate(req *AuthorizationRequest) (time.Duration, *pbc.Element, error) {
+	var signingTime time.Duration
+	var aggregate *pbc.Element
+	err := engine.issue(req, 0, func() (err error) {
+		signingTime, aggregate, err = engine.authority.AuthorizeAggregate(req.Elements, req.Party, req.Options...)
+		return err
+	})
+	return signingTime, aggregate, err
+}
+
+// AuthorizeBlindedSet answers blind requests for req's party and
+// requester, as Authority.AuthorizeBlindedSet does, once the policy allows
+// them. req carries no elements; the requests count against the quota one
+// each, and are denied outright if the party's policy has an allowlist or
+// patterns, which they cannot be checked against.
+func (engine *PolicyEngine) AuthorizeBlindedSet(req *AuthorizationRequest, requests []*BlindRequest) (time.Duration, *BlindKey, []*pbc.Element, error) {
+	if len(req.Elements) > 0 {
+		return 0, nil, nil, errors.New("apsi: blinded authorization request carries elements")
+	}
+	var signingTime time.Duration
+	var bk *BlindKey
+	var blindSignatures []*pbc.Element
+	err := engine.issue(req, len(requests), func() (err error) {
+		signingTime, bk, blindSignatures, err = engine.authority.AuthorizeBlindedSet(requests, req.Party)
+		return err
+	})
+	return signingTime, bk, blindSignatures, err
+}
@@ -0,0 +1,117 @@
+package apsi
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testPolicy(t *testing.T, doc string) *Policy {
+	policy, err := ParsePolicy([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func testRequest(requester string, n int) *AuthorizationRequest {
+	set := make(RawElementSlice, n)
+	for i := range set {
+		set[i] = RawElement{0x0a, 0, 0, byte(i)}
+	}
+	return &AuthorizationRequest{Requester: requester, Party: ClientParty, Elements: set}
+}
+
+func TestPolicyDenials(t *testing.T) {
+	engine := NewPolicyEngine(nil, testPolicy(t, `{
+		"client": {"allow": ["0a000000"], "patterns": ["0a0000??"], "require": ["case"]}
+	}`))
+	req := testRequest("alice", 2)
+	req.Elements[1] = RawElement{0x0b, 0, 0, 0}
+	err := engine.Check(req, time.Now())
+	denied, ok := err.(*PolicyError)
+	if !ok {
+		t.Fatalf("Check = %v, want a *PolicyError", err)
+	}
+	want := []PolicyDenial{
+		{RuleJustification, -1, ""},
+		{RuleAllow, 1, ""},
+		{RulePattern, 1, ""},
+	}
+	if len(denied.Denials) != len(want) {
+		t.Fatalf("denials = %v, want %d", denied.Denials, len(want))
+	}
+	for i, d := range denied.Denials {
+		if d.Rule != want[i].Rule || d.Index != want[i].Index {
+			t.Errorf("denial %d = %s at %d, want %s at %d", i, d.Rule, d.Index, want[i].Rule, want[i].Index)
+		}
+	}
+
+	req = testRequest("alice", 1)
+	req.Justification = map[string]string{"case": "1"}
+	if err := engine.Check(req, time.Now()); err != nil {
+		t.Errorf("Check = %v, want nil", err)
+	}
+	req.Party = ServerParty
+	if err := engine.Check(req, time.Now()); err == nil {
+		t.Error("Check allowed a party the policy leaves out")
+	}
+}
+
+func TestPolicyQuotaRefund(t *testing.T) {
+	engine := NewPolicyEngine(nil, testPolicy(t, `{
+		"client": {"quota": {"limit": 3, "period": "24h"}}
+	}`))
+	failed := errors.New("signing failed")
+	if err := engine.issue(testRequest("alice", 3), 0, func() error { return failed }); err != failed {
+		t.Fatalf("issue = %v, want %v", err, failed)
+	}
+	if err := engine.issue(testRequest("alice", 2), 1, func() error { return nil }); err != nil {
+		t.Fatalf("issue after a refund = %v", err)
+	}
+	err := engine.issue(testRequest("alice", 1), 0, func() error { return nil })
+	if denied, ok := err.(*PolicyError); !ok || denied.Denials[0].Rule != RuleQuota {
+		t.Fatalf("issue over the quota = %v, want a quota denial", err)
+	}
+	if err := engine.issue(testRequest("bob", 3), 0, func() error { return nil }); err != nil {
+		t.Fatalf("issue for another requester = %v", err)
+	}
+}
+
+func TestPolicySharedUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apsi-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "policy.usage")
+	policy := testPolicy(t, `{"client": {"quota": {"limit": 3, "period": "24h"}}}`)
+	engines := make([]*PolicyEngine, 2)
+	for i := range engines {
+		engines[i] = NewPolicyEngine(nil, policy)
+		if err := engines[i].PersistUsage(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := engines[0].issue(testRequest("alice", 2), 0, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// The second engine read the file before the first signed anything, so
+	// it only sees the first's count by reading it again.
+	err = engines[1].issue(testRequest("alice", 2), 0, func() error { return nil })
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatalf("issue past a quota used by another engine = %v, want a *PolicyError", err)
+	}
+	if err := engines[1].issue(testRequest("alice", 1), 0, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewPolicyEngine(nil, policy)
+	if err := restarted.PersistUsage(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Usage()[QuotaKey{"alice", ClientParty}].Used; got != 3 {
+		t.Errorf("usage read back = %d, want 3", got)
+	}
+}
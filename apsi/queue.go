@@ -0,0 +1,494 @@
+package apsi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A request queue sits in front of the authority when a person has to
+// approve each batch before it is signed. Requesters submit a batch of
+// elements with a justification, a reviewer approves all of it, part of it
+// or none of it, and approval signs the approved elements straight away so
+// that the requester can collect the signatures later. Each request is a
+// JSON file in the queue's directory, replaced whole on every change, so
+// the queue survives restarts and can be inspected by hand. Reviewers may
+// run in separate processes, so a request is decided under a lock file
+// next to it, created exclusively; one left behind by a reviewer that
+// crashed has to be removed by hand.
+
+var (
+	// ErrUnknownRequest is returned for a request ID the queue does not
+	// hold.
+	ErrUnknownRequest = errors.New("apsi: no such authorization request")
+
+	// ErrRequestDecided is returned when approving or denying a request
+	// that has already been decided.
+	ErrRequestDecided = errors.New("apsi: authorization request has already been decided")
+
+	// ErrRequestPending is returned when collecting the signatures of a
+	// request that has not been decided yet.
+	ErrRequestPending = errors.New("apsi: authorization request is still pending")
+
+	// ErrRequestDenied is returned when collecting the signatures of a
+	// request that was denied.
+	ErrRequestDenied = errors.New("apsi: authorization request was denied")
+
+	// ErrRequestLocked is returned when a request is being decided or
+	// removed by someone else.
+	ErrRequestLocked = errors.New("apsi: authorization request is locked by another reviewer")
+)
+
+// RequestID names a queued request.
+type RequestID string
+
+// RequestStatus is where a queued request stands.
+type RequestStatus string
+
+// The states a queued request moves through. A request leaves
+// RequestPending exactly once.
+const (
+	RequestPending  RequestStatus = "pending"
+	RequestApproved RequestStatus = "approved"
+	RequestPartial  RequestStatus = "partially approved"
+	RequestDenied   RequestStatus = "denied"
+)
+
+// RequestSubmission is what a requester asks the authority to sign: the
+// elements for one party, the epoch and context to bind them to, and the
+// justification a reviewer decides on.
+type RequestSubmission struct {
+	Requester     string
+	Party         Party
+	Elements      RawElementSlice
+	Justification map[string]string
+	Epoch         Epoch
+	Context       Context
+}
+
+// authorizeOptions returns the options the submission asks to be signed
+// with.
+func (sub *RequestSubmission) authorizeOptions() []AuthorizeOption {
+	return CertificateInfo{Epoch: sub.Epoch, Context: sub.Context}.authorizeOptions()
+}
+
+// QueuedRequest is a submission with its place in the queue and, once
+// decided, the decision. Approved holds the indices of the elements that
+// were approved, whose signatures Signatures returns.
+type QueuedRequest struct {
+	RequestSubmission
+
+	ID        RequestID
+	Submitted time.Time
+	Status    RequestStatus
+
+	Decided  time.Time
+	Reviewer string
+	Reason   string
+	Approved []int
+
+	signatures [][]byte
+}
+
+// RequestSigner signs approved requests: an *Authority, or a
+// *PolicyEngine to hold approvals to the authority's policy as well.
+type RequestSigner interface {
+	signRequest(req *AuthorizationRequest) ([]*pbc.Element, error)
+}
+
+func (authority *Authority) signRequest(req *AuthorizationRequest) ([]*pbc.Element, error) {
+	_, signatures, err := authority.AuthorizeSet(req.Elements, req.Party, req.Options...)
+	return signatures, err
+}
+
+func (engine *PolicyEngine) signRequest(req *AuthorizationRequest) ([]*pbc.Element, error) {
+	_, signatures, err := engine.AuthorizeSet(req)
+	return signatures, err
+}
+
+// RequestQueue is a directory of queued requests.
+type RequestQueue struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// OpenRequestQueue opens the queue in dir, creating the directory if it
+// does not exist.
+func OpenRequestQueue(dir string) (*RequestQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &RequestQueue{dir: dir}, nil
+}
+
+// Submit queues sub as a pending request and returns its ID.
+func (queue *RequestQueue) Submit(sub RequestSubmission) (RequestID, error) {
+	if sub.Party != ClientParty && sub.Party != ServerParty {
+		return "", ErrUnknownParty
+	}
+	if len(sub.Elements) == 0 {
+		return "", errors.New("apsi: authorization request has no elements")
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	req := &QueuedRequest{
+		RequestSubmission: sub,
+		ID:                RequestID(hex.EncodeToString(id[:])),
+		Submitted:         time.Now(),
+		Status:            RequestPending,
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if err := queue.put(req); err != nil {
+		return "", err
+	}
+	return req.ID, nil
+}
+
+// Get returns the request with the given ID.
+func (queue *RequestQueue) Get(id RequestID) (*QueuedRequest, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.get(id)
+}
+
+// List returns the queued requests, oldest first, leaving out decided ones
+// unless all is set.
+func (queue *RequestQueue) List(all bool) ([]*QueuedRequest, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	names, err := filepath.Glob(filepath.Join(queue.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var reqs []*QueuedRequest
+	for _, name := range names {
+		req, err := queue.get(RequestID(strings.TrimSuffix(filepath.Base(name), ".json")))
+		if err != nil {
+			return nil, err
+		}
+		if all || req.Status == RequestPending {
+			reqs = append(reqs, req)
+		}
+	}
+	sort.Slice(reqs, func(a, b int) bool {
+		return reqs[a].Submitted.Before(reqs[b].Submitted)
+	})
+	return reqs, nil
+}
+
+// Approve signs the elements of a pending request at the given indices
+// with signer, or all of them if indices is nil, and records the
+// decision. Approving only some elements denies the rest. If signing fails,
+// as when a PolicyEngine denies the request, the request stays pending.
+func (queue *RequestQueue) Approve(id RequestID, signer RequestSigner, reviewer string, indices []int) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	unlock, err := queue.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	req, err := queue.get(id)
+	if err != nil {
+		return err
+	}
+	if req.Status != RequestPending {
+		return ErrRequestDecided
+	}
+	if indices == nil {
+		indices = make([]int, len(req.Elements))
+		for i := range indices {
+			indices[i] = i
+		}
+	}
+	seen := make(map[int]bool, len(indices))
+	elements := make(RawElementSlice, len(indices))
+	for k, i := range indices {
+		if i < 0 || i >= len(req.Elements) || seen[i] {
+			return fmt.Errorf("apsi: request %s has no element %d to approve", id, i)
+		}
+		seen[i] = true
+		elements[k] = req.Elements[i]
+	}
+	if len(elements) == 0 {
+		return errors.New("apsi: approve at least one element, or deny the request")
+	}
+
+	signatures, err := signer.signRequest(&AuthorizationRequest{
+		Requester:     req.Requester,
+		Party:         req.Party,
+		Elements:      elements,
+		Justification: req.Justification,
+		Options:       req.authorizeOptions(),
+	})
+	if err != nil {
+		return err
+	}
+	req.Status = RequestApproved
+	if len(indices) < len(req.Elements) {
+		req.Status = RequestPartial
+	}
+	req.Decided = time.Now()
+	req.Reviewer = reviewer
+	req.Approved = indices
+	req.signatures = make([][]byte, len(signatures))
+	for k, signature := range signatures {
+		req.signatures[k] = signature.Bytes()
+	}
+	return queue.put(req)
+}
+
+// Deny records that a pending request was denied, and why.
+func (queue *RequestQueue) Deny(id RequestID, reviewer, reason string) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	unlock, err := queue.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	req, err := queue.get(id)
+	if err != nil {
+		return err
+	}
+	if req.Status != RequestPending {
+		return ErrRequestDecided
+	}
+	req.Status = RequestDenied
+	req.Decided = time.Now()
+	req.Reviewer = reviewer
+	req.Reason = reason
+	return queue.put(req)
+}
+
+// Remove drops a request from the queue, as once its signatures have been
+// collected.
+func (queue *RequestQueue) Remove(id RequestID) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	unlock, err := queue.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := queue.get(id); err != nil {
+		return err
+	}
+	return os.Remove(queue.path(id))
+}
+
+// Signatures returns the approved elements of a decided request and their
+// signatures in pairing, checked against pk. It fails with
+// ErrRequestPending or ErrRequestDenied if there are none yet or none at
+// all.
+func (req *QueuedRequest) Signatures(pairing *pbc.Pairing, pk PublicKey) (RawElementSlice, []*pbc.Element, error) {
+	switch req.Status {
+	case RequestPending:
+		return nil, nil, ErrRequestPending
+	case RequestDenied:
+		return nil, nil, ErrRequestDenied
+	}
+	if len(req.signatures) != len(req.Approved) {
+		return nil, nil, ErrSignatureCount
+	}
+	set := make(RawElementSlice, len(req.Approved))
+	signatures := make([]*pbc.Element, len(req.Approved))
+	for k, i := range req.Approved {
+		if i < 0 || i >= len(req.Elements) {
+			return nil, nil, ErrMalformedMessage
+		}
+		set[k] = req.Elements[i]
+		var err error
+		if signatures[k], err = decodeG1(pairing, req.signatures[k]); err != nil {
+			return nil, nil, err
+		}
+	}
+	invalid, err := BatchVerify(pairing, pk, set, signatures, req.Party, req.authorizeOptions()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(invalid) > 0 {
+		return nil, nil, invalidSignatures(req.Party, invalid)
+	}
+	return set, signatures, nil
+}
+
+// requestRecord is the JSON form of a QueuedRequest.
+type requestRecord struct {
+	ID            string            `json:"id"`
+	Requester     string            `json:"requester,omitempty"`
+	Party         string            `json:"party"`
+	Elements      []string          `json:"elements"`
+	Justification map[string]string `json:"justification,omitempty"`
+	Epoch         Epoch             `json:"epoch,omitempty"`
+	Context       *Context          `json:"context,omitempty"`
+	Submitted     time.Time         `json:"submitted"`
+	Status        RequestStatus     `json:"status"`
+	Decided       *time.Time        `json:"decided,omitempty"`
+	Reviewer      string            `json:"reviewer,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+	Approved      []int             `json:"approved,omitempty"`
+	Signatures    []string          `json:"signatures,omitempty"`
+}
+
+func (queue *RequestQueue) path(id RequestID) string {
+	return filepath.Join(queue.dir, string(id)+".json")
+}
+
+// lock creates the lock file of the request with the given ID, failing
+// with ErrRequestLocked if another process holds it, and returns a function
+// that removes it.
+func (queue *RequestQueue) lock(id RequestID) (func(), error) {
+	if err := id.check(); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(queue.dir, string(id)+".lock")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, ErrRequestLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
+
+// check keeps request IDs from naming files outside the queue.
+func (id RequestID) check() error {
+	if id == "" || strings.ContainsAny(string(id), `/\.`) {
+		return ErrUnknownRequest
+	}
+	return nil
+}
+
+func (queue *RequestQueue) get(id RequestID) (*QueuedRequest, error) {
+	if err := id.check(); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(queue.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec requestRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("apsi: request %s: %v", id, err)
+	}
+
+	req := &QueuedRequest{
+		RequestSubmission: RequestSubmission{
+			Requester:     rec.Requester,
+			Justification: rec.Justification,
+			Epoch:         rec.Epoch,
+		},
+		ID:        RequestID(rec.ID),
+		Submitted: rec.Submitted,
+		Status:    rec.Status,
+		Reviewer:  rec.Reviewer,
+		Reason:    rec.Reason,
+		Approved:  rec.Approved,
+	}
+	if req.ID != id {
+		return nil, fmt.Errorf("apsi: request %s: file holds request %s", id, req.ID)
+	}
+	switch rec.Party {
+	case ClientParty.String():
+		req.Party = ClientParty
+	case ServerParty.String():
+		req.Party = ServerParty
+	default:
+		return nil, fmt.Errorf("apsi: request %s: %v", id, ErrUnknownParty)
+	}
+	if rec.Context != nil {
+		req.Context = *rec.Context
+	}
+	if rec.Decided != nil {
+		req.Decided = *rec.Decided
+	}
+	for _, s := range rec.Elements {
+		var elt RawElement
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != len(elt) {
+			return nil, fmt.Errorf("apsi: request %s: element %q is malformed", id, s)
+		}
+		copy(elt[:], b)
+		req.Elements = append(req.Elements, elt)
+	}
+	for _, s := range rec.Signatures {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("apsi: request %s: signature is malformed", id)
+		}
+		req.signatures = append(req.signatures, b)
+	}
+	return req, nil
+}
+
+// put replaces the request's file, so that it never holds a partial
+// request.
+func (queue *RequestQueue) put(req *QueuedRequest) error {
+	rec := requestRecord{
+		ID:            string(req.ID),
+		Requester:     req.Requester,
+		Party:         req.Party.String(),
+		Justification: req.Justification,
+		Epoch:         req.Epoch,
+		Submitted:     req.Submitted,
+		Status:        req.Status,
+		Reviewer:      req.Reviewer,
+		Reason:        req.Reason,
+		Approved:      req.Approved,
+	}
+	if req.Context != (Context{}) {
+		rec.Context = &req.Context
+	}
+	if !req.Decided.IsZero() {
+		rec.Decided = &req.Decided
+	}
+	for _, elt := range req.Elements {
+		rec.Elements = append(rec.Elements, hex.EncodeToString(elt[:]))
+	}
+	for _, b := range req.signatures {
+		rec.Signatures = append(rec.Signatures, hex.EncodeToString(b))
+	}
+	data, err := json.MarshalIndent(&rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(queue.dir, string(req.ID)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), queue.path(req.ID))
+}
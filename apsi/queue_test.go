@@ -0,0 +1,114 @@
+package apsi
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testQueue(t *testing.T) (*RequestQueue, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "apsi-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := OpenRequestQueue(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return queue, dir
+}
+
+func TestQueueLockContention(t *testing.T) {
+	queue, dir := testQueue(t)
+	defer os.RemoveAll(dir)
+	_, authority := NewAuthority()
+	id, err := queue.Submit(RequestSubmission{Requester: "alice", Party: ClientParty, Elements: RawElementSlice{{0, 0, 0, 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reviewer in another process opens the same directory and is in the
+	// middle of deciding the request.
+	other, err := OpenRequestQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := other.lock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Approve(id, authority, "bob", nil); err != ErrRequestLocked {
+		t.Errorf("Approve = %v, want %v", err, ErrRequestLocked)
+	}
+	if err := queue.Deny(id, "bob", "no"); err != ErrRequestLocked {
+		t.Errorf("Deny = %v, want %v", err, ErrRequestLocked)
+	}
+	if err := queue.Remove(id); err != ErrRequestLocked {
+		t.Errorf("Remove = %v, want %v", err, ErrRequestLocked)
+	}
+	if req, err := queue.Get(id); err != nil || req.Status != RequestPending {
+		t.Errorf("Get while locked = %v, %v", req, err)
+	}
+	unlock()
+
+	if err := queue.Approve(id, authority, "bob", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Deny(id, "carol", "too late"); err != ErrRequestDecided {
+		t.Errorf("Deny after approval = %v, want %v", err, ErrRequestDecided)
+	}
+	if _, err := queue.lock("../" + id); err != ErrUnknownRequest {
+		t.Errorf("lock outside the queue = %v, want %v", err, ErrUnknownRequest)
+	}
+}
+
+func TestQueuePartialApproval(t *testing.T) {
+	queue, dir := testQueue(t)
+	defer os.RemoveAll(dir)
+	_, authority := NewAuthority()
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 3}}
+	id, err := queue.Submit(RequestSubmission{Requester: "alice", Party: ServerParty, Elements: set, Epoch: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := queue.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := req.Signatures(authority.Pairing(), authority.PublicKey()); err != ErrRequestPending {
+		t.Errorf("Signatures while pending = %v, want %v", err, ErrRequestPending)
+	}
+	if err := queue.Approve(id, authority, "bob", []int{2, 2}); err == nil {
+		t.Error("Approve accepted an element twice")
+	}
+	if err := queue.Approve(id, authority, "bob", []int{2, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	if req, err = queue.Get(id); err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != RequestPartial || req.Reviewer != "bob" {
+		t.Errorf("request is %s by %q, want %s by bob", req.Status, req.Reviewer, RequestPartial)
+	}
+	approved, signatures, err := req.Signatures(authority.Pairing(), authority.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RawElementSlice{set[2], set[0]}); !reflect.DeepEqual(approved, want) {
+		t.Errorf("approved = %x, want %x", approved, want)
+	}
+	if ok, err := Verify(authority.Pairing(), authority.PublicKey(), set[2], signatures[0], ServerParty, AtEpoch(9)); err != nil || !ok {
+		t.Errorf("approved signature does not verify in the request's epoch: %v, %v", ok, err)
+	}
+
+	if err := queue.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Get(id); err != ErrUnknownRequest {
+		t.Errorf("Get after Remove = %v, want %v", err, ErrUnknownRequest)
+	}
+}
@@ -0,0 +1,201 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A revocation list names authorizations that must no longer take part in
+// interactions. Entries are digests of the revoked signatures rather than
+// the elements, so publishing the list reveals nothing to anyone who does
+// not already hold the signature. Every change bumps the list's version
+// and is signed by the authority, which lets a party refuse a counterparty
+// that filtered its set against an older list.
+//
+// The list is signed like every other document the authority publishes;
+// see signDocument.
+//
+// Revocation only binds honest parties. Each side filters its own set, and
+// neither sees the other's signatures, so nothing stops a party from
+// skipping the list; the version a client announces is its own claim. A
+// server that must refuse a client it cannot trust to filter needs that
+// client removed from its client registry instead (see RemoveClient), which
+// the server checks for itself.
+
+var (
+	// ErrRevocationSignature is returned when a revocation list is not
+	// signed by the authority.
+	ErrRevocationSignature = errors.New("apsi: revocation list signature is invalid")
+
+	// ErrStaleRevocationList is returned when the client filtered its set
+	// against an older revocation list than the server's.
+	ErrStaleRevocationList = errors.New("apsi: client revocation list is out of date")
+)
+
+// RevocationID identifies one revoked authorization.
+type RevocationID [32]byte
+
+// RevocationIDOf returns the entry that revokes signature for party.
+func RevocationIDOf(party Party, signature *pbc.Element) RevocationID {
+	h := sha256.New()
+	h.Write([]byte("apsi-revocation-id-v1"))
+	h.Write([]byte{byte(party)})
+	h.Write(signature.Bytes())
+	var id RevocationID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// RevocationList is the authority's signed list of revoked authorizations.
+type RevocationList struct {
+	Version   uint64
+	revoked   map[RevocationID]bool
+	signature *pbc.Element
+}
+
+// NewRevocationList returns an empty, unsigned list at version 0.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revoked: make(map[RevocationID]bool)}
+}
+
+// Len returns the number of revoked authorizations.
+func (list *RevocationList) Len() int {
+	return len(list.revoked)
+}
+
+// IsRevoked reports whether signature has been revoked for party.
+func (list *RevocationList) IsRevoked(party Party, signature *pbc.Element) bool {
+	return list.revoked[RevocationIDOf(party, signature)]
+}
+
+// ids returns the entries in a canonical order.
+func (list *RevocationList) ids() []RevocationID {
+	ids := make([]RevocationID, 0, len(list.revoked))
+	for id := range list.revoked {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return bytes.Compare(ids[a][:], ids[b][:]) < 0
+	})
+	return ids
+}
+
+// contents encodes what the signature covers: version (8) | count (4) |
+// entries (32 each), entries in increasing order.
+func (list *RevocationList) contents() []byte {
+	ids := list.ids()
+	b := make([]byte, 12, 12+len(ids)*len(RevocationID{}))
+	binary.BigEndian.PutUint64(b, list.Version)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(ids)))
+	for _, id := range ids {
+		b = append(b, id[:]...)
+	}
+	return b
+}
+
+const revocationListDomain = "apsi-revocation-list-v1"
+
+// Revoke adds the authorization of elt for party, as issued with opts, to
+// list, bumps its version and signs it again.
+func (authority *Authority) Revoke(list *RevocationList, elt RawElement, party Party, opts ...AuthorizeOption) error {
+	return authority.RevokeSet(list, RawElementSlice{elt}, party, opts...)
+}
+
+// RevokeSet is Revoke for every element of set, as a single new version of
+// list. The authorizations are recomputed to find their entries but are
+// not issued again: an audit log set on the authority records an
+// AuditRevoke entry for each element rather than an authorization.
+func (authority *Authority) RevokeSet(list *RevocationList, set RawElementSlice, party Party, opts ...AuthorizeOption) error {
+	secretKey, err := authority.sk.forParty(party)
+	if err != nil {
+		return err
+	}
+	for _, elt := range set {
+		list.revoked[RevocationIDOf(party, authority.sign(elt, secretKey, opts))] = true
+	}
+	if authority.audit != nil {
+		if err := authority.audit.recordElements(AuditRevoke, party, authority.pk.ID(), set); err != nil {
+			return err
+		}
+	}
+	list.Version++
+	authority.SignRevocationList(list)
+	return nil
+}
+
+// SignRevocationList signs list as it stands.
+func (authority *Authority) SignRevocationList(list *RevocationList) {
+	list.signature = authority.signDocument(revocationListDomain, list.contents())
+}
+
+// Verify checks that the authority signed list.
+func (list *RevocationList) Verify(pairing *pbc.Pairing, pk PublicKey) bool {
+	return verifyDocument(pairing, pk, revocationListDomain, list.contents(), list.signature)
+}
+
+// filter returns the indices of the revoked signatures, in increasing
+// order.
+func (list *RevocationList) filter(signatures []*pbc.Element, party Party) []int {
+	var revoked []int
+	for i, signature := range signatures {
+		if signature != nil && list.IsRevoked(party, signature) {
+			revoked = append(revoked, i)
+		}
+	}
+	return revoked
+}
+
+// A revocation list file holds one "APSI REVOCATION LIST" block: the signed
+// contents followed by the signature as a 2-byte length and PBC encoding.
+const pemRevocationList = "APSI REVOCATION LIST"
+
+// Write writes the signed list.
+func (list *RevocationList) Write(w io.Writer) error {
+	if list.signature == nil {
+		return ErrRevocationSignature
+	}
+	return pemEncode(w, pemRevocationList, appendBytes16(list.contents(), list.signature.Bytes()))
+}
+
+// ReadRevocationList reads a list written by Write and checks its signature
+// against pk.
+func ReadRevocationList(r io.Reader, pairing *pbc.Pairing, pk PublicKey) (*RevocationList, error) {
+	data, err := readSingleBlock(r, pemRevocationList)
+	if err != nil {
+		return nil, err
+	}
+	br := &wireReader{buf: data}
+	version := br.uint64()
+	count := br.uint32()
+	if br.err == nil && uint64(count)*32 > uint64(len(br.buf)) {
+		return nil, ErrMalformedMessage
+	}
+	list := NewRevocationList()
+	list.Version = version
+	for i := uint32(0); i < count && br.err == nil; i++ {
+		var id RevocationID
+		copy(id[:], br.next(len(id)))
+		if list.revoked[id] {
+			return nil, errors.New("apsi: revocation list repeats an entry")
+		}
+		list.revoked[id] = true
+	}
+	signature := br.bytes16()
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+	list.signature, err = decodeG1(pairing, signature)
+	if err != nil {
+		return nil, err
+	}
+	if !list.Verify(pairing, pk) {
+		return nil, ErrRevocationSignature
+	}
+	return list, nil
+}
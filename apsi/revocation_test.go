@@ -0,0 +1,97 @@
+package apsi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRevocationFiltersBothSides(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 3}}
+	_, clientSignatures, err := authority.AuthorizeSet(set, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverSignatures, err := authority.AuthorizeSet(set, ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := NewRevocationList()
+	if err := authority.Revoke(list, set[0], ClientParty); err != nil {
+		t.Fatal(err)
+	}
+	if err := authority.Revoke(list, set[1], ServerParty); err != nil {
+		t.Fatal(err)
+	}
+
+	var file bytes.Buffer
+	if err := list.Write(&file); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadRevocationList(&file, pairing, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Version != 2 || read.Len() != 2 {
+		t.Fatalf("read version %d with %d entries, want 2 and 2", read.Version, read.Len())
+	}
+
+	client, err := NewClient(pairing, pk, set, clientSignatures, WithRevocationList(read))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, set, serverSignatures, WithRevocationList(read))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intersection, err := runSession(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := set[2:]; !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+}
+
+func TestRevocationStaleClient(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	list := NewRevocationList()
+	if err := authority.Revoke(list, RawElement{0, 0, 0, 1}, ClientParty); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(pairing, pk, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, nil, nil, WithRevocationList(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSession(client, server); err != ErrStaleRevocationList {
+		t.Errorf("session = %v, want %v", err, ErrStaleRevocationList)
+	}
+}
+
+func TestRevocationListForged(t *testing.T) {
+	_, authority := NewAuthority()
+	_, other := NewAuthority()
+	list := NewRevocationList()
+	if err := other.Revoke(list, RawElement{0, 0, 0, 1}, ClientParty); err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	if err := list.Write(&file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRevocationList(&file, authority.Pairing(), authority.PublicKey()); err != ErrRevocationSignature {
+		t.Errorf("ReadRevocationList = %v, want %v", err, ErrRevocationSignature)
+	}
+	// A list signed with x, as an authorization would be, is no document.
+	list.signature = authority.sign(RawElement{0, 0, 0, 1}, authority.sk.X, nil)
+	if list.Verify(authority.Pairing(), authority.PublicKey()) {
+		t.Error("a list signed with x verified")
+	}
+}
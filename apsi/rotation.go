@@ -0,0 +1,255 @@
+package apsi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// An authority rotates its keys by drawing a fresh (x, y) over the same
+// pairing and P. The keyring records every version it has used: the newest
+// is current, and each older one stays accepted until the end of the
+// overlap it was given, which is the window for parties to get their sets
+// re-issued. Parties that hold authorizations under several versions offer
+// all of them and settle on the first one both sides hold.
+
+var (
+	// ErrUnknownKey is returned for a key version that is not in the
+	// keyring.
+	ErrUnknownKey = errors.New("apsi: unknown key version")
+
+	// ErrKeyRetired is returned for a key version whose overlap has ended.
+	ErrKeyRetired = errors.New("apsi: key version has been retired")
+
+	// ErrNoCommonKey is returned when the client and the server hold
+	// authorizations under no common key version.
+	ErrNoCommonKey = errors.New("apsi: client and server share no key version")
+)
+
+// KeyID identifies one version of the authority's keys.
+type KeyID [8]byte
+
+// ID returns the key ID of pk, a digest of P and yP. Every version has its
+// own y, and leaving out xP gives per-client keys (see ForClient) the ID of
+// the version they were derived from.
+func (pk PublicKey) ID() KeyID {
+	h := sha256.New()
+	h.Write([]byte("apsi-key-id-v1"))
+	h.Write(encodeElements(pk.P, pk.YP))
+	var id KeyID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// KeyVersion is one entry of a keyring. Retires is zero for the current
+// version.
+type KeyVersion struct {
+	PublicKey PublicKey
+	Retires   time.Time
+}
+
+// ID returns the version's key ID.
+func (version KeyVersion) ID() KeyID {
+	return version.PublicKey.ID()
+}
+
+// Accepted reports whether the version is still accepted at now.
+func (version KeyVersion) Accepted(now time.Time) bool {
+	return version.Retires.IsZero() || now.Before(version.Retires)
+}
+
+// Keyring lists the versions of an authority's keys, oldest first.
+type Keyring struct {
+	params   *pbc.Params
+	pairing  *pbc.Pairing
+	versions []KeyVersion
+}
+
+// NewKeyring returns a keyring whose only version is authority's key.
+func NewKeyring(authority *Authority) *Keyring {
+	return &Keyring{
+		params:   authority.params,
+		pairing:  authority.pairing,
+		versions: []KeyVersion{{PublicKey: authority.pk}},
+	}
+}
+
+// Pairing returns the pairing every version lives in.
+func (ring *Keyring) Pairing() *pbc.Pairing {
+	return ring.pairing
+}
+
+// Current returns the version new authorizations are issued under.
+func (ring *Keyring) Current() KeyVersion {
+	return ring.versions[len(ring.versions)-1]
+}
+
+// Versions returns every version, oldest first.
+func (ring *Keyring) Versions() []KeyVersion {
+	return append([]KeyVersion(nil), ring.versions...)
+}
+
+// Lookup returns the public key of version id if it is accepted at now.
+func (ring *Keyring) Lookup(id KeyID, now time.Time) (PublicKey, error) {
+	for _, version := range ring.versions {
+		if version.ID() != id {
+			continue
+		}
+		if !version.Accepted(now) {
+			return PublicKey{}, ErrKeyRetired
+		}
+		return version.PublicKey, nil
+	}
+	return PublicKey{}, ErrUnknownKey
+}
+
+// Rotate draws a new (x, y) and document key over the authority's pairing and P, adds it to
+// ring as the current version and retires the old one after overlap. It
+// returns the time taken and the authority for the new version; the old
+// authority's secret key is no longer needed, since re-issuance only checks
+// old authorizations against the old public key.
+func (authority *Authority) Rotate(ring *Keyring, overlap time.Duration) (time.Duration, *Authority, error) {
+	if ring.Current().ID() != authority.pk.ID() {
+		return 0, nil, fmt.Errorf("apsi: authority key %s is not the keyring's current version", authority.pk.ID())
+	}
+	startTime := time.Now()
+
+	x := authority.pairing.NewZr().Rand()
+	y := authority.pairing.NewZr().Rand()
+	z := authority.pairing.NewZr().Rand()
+	next := &Authority{
+		params:  authority.params,
+		pairing: authority.pairing,
+		pk: PublicKey{
+			P:  authority.pk.P,
+			XP: authority.pairing.NewG1().MulZn(authority.pk.P, x),
+			YP: authority.pairing.NewG1().MulZn(authority.pk.P, y),
+			ZP: authority.pairing.NewG1().MulZn(authority.pk.P, z),
+		},
+		sk:    SecretKey{X: x, Y: y, Z: z},
+		audit: authority.audit,
+
+		blindPeriod: authority.blindPeriod,
+	}
+
+	rotateTime := time.Since(startTime)
+	ring.versions[len(ring.versions)-1].Retires = startTime.Add(overlap)
+	ring.versions = append(ring.versions, KeyVersion{PublicKey: next.pk})
+	return rotateTime, next, nil
+}
+
+// Reissue authorizes set for party under the authority's key, provided that
+// signatures are valid authorizations of set under old. opts must be the
+// options the old authorizations were issued with, and are carried over. If
+// any old signature is invalid, nothing is issued and the error is an
+// *InvalidSignatureError naming them.
+func (authority *Authority) Reissue(old PublicKey, set RawElementSlice, signatures []*pbc.Element, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	invalid, err := BatchVerify(authority.pairing, old, set, signatures, party, opts...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(invalid) > 0 {
+		if party == ClientParty {
+			return 0, nil, &InvalidSignatureError{ClientIndices: invalid}
+		}
+		return 0, nil, &InvalidSignatureError{ServerIndices: invalid}
+	}
+	return authority.AuthorizeSet(set, party, opts...)
+}
+
+// negotiateKey finds the first offered key ID that held contains and
+// returns its index in both.
+func negotiateKey(offered []KeyID, held []KeyID) (int, int, error) {
+	for o, id := range offered {
+		for h := range held {
+			if held[h] == id {
+				return o, h, nil
+			}
+		}
+	}
+	return 0, 0, ErrNoCommonKey
+}
+
+// A keyring file holds the params block followed by an "APSI KEYRING"
+// block: a 2-byte count, then for each version from oldest to newest its
+// retirement time as 8-byte Unix seconds (0 for the current version) and
+// P, xP, yP and zP.
+const pemKeyring = "APSI KEYRING"
+
+// Write writes the keyring for distribution to clients and servers.
+func (ring *Keyring) Write(w io.Writer) error {
+	if err := pemEncode(w, pemParams, []byte(ring.params.String())); err != nil {
+		return err
+	}
+	b := appendUint16(nil, uint16(len(ring.versions)))
+	for _, version := range ring.versions {
+		var retires uint64
+		if !version.Retires.IsZero() {
+			retires = uint64(version.Retires.Unix())
+		}
+		pk := version.PublicKey
+		b = appendUint64(b, retires)
+		b = append(b, encodeElements(pk.P, pk.XP, pk.YP, pk.ZP)...)
+	}
+	return pemEncode(w, pemKeyring, b)
+}
+
+// ReadKeyring reads a keyring written by Keyring.Write.
+func ReadKeyring(r io.Reader) (*Keyring, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, pairing, err := decodeParamsBlock(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemKeyring]
+	if !ok {
+		return nil, fmt.Errorf("apsi: keyring file has no %s block", pemKeyring)
+	}
+
+	br := &wireReader{buf: data}
+	count := int(br.uint16())
+	if br.err == nil && count == 0 {
+		return nil, errors.New("apsi: keyring is empty")
+	}
+	ring := &Keyring{params: params, pairing: pairing}
+	for i := 0; i < count && br.err == nil; i++ {
+		retires := br.uint64()
+		fields := br.next(4*2 + 4*int(pairing.G1Length()))
+		if br.err != nil {
+			break
+		}
+		elements, err := decodeElements(fields, pairing.NewG1, pairing.NewG1, pairing.NewG1, pairing.NewG1)
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			if element.Is0() {
+				return nil, errors.New("apsi: keyring contains the identity")
+			}
+		}
+		version := KeyVersion{PublicKey: PublicKey{P: elements[0], XP: elements[1], YP: elements[2], ZP: elements[3]}}
+		if retires != 0 {
+			version.Retires = time.Unix(int64(retires), 0)
+		}
+		if (retires == 0) != (i == count-1) {
+			return nil, errors.New("apsi: keyring must retire every version but the last")
+		}
+		ring.versions = append(ring.versions, version)
+	}
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
@@ -0,0 +1,119 @@
+package apsi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyringRoundTrip(t *testing.T) {
+	_, authority := NewAuthority()
+	ring := NewKeyring(authority)
+	_, next, err := authority.Rotate(ring, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	if err := ring.Write(&file); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadKeyring(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := read.Versions()
+	if len(versions) != 2 || versions[0].ID() != authority.PublicKey().ID() || versions[1].ID() != next.PublicKey().ID() {
+		t.Fatalf("read %d versions, want the old and the new key", len(versions))
+	}
+	if versions[1].PublicKey.ZP == nil || !versions[1].PublicKey.ZP.Equals(next.PublicKey().ZP) {
+		t.Error("document key did not survive the round trip")
+	}
+
+	old := authority.PublicKey().ID()
+	if _, err := read.Lookup(old, time.Now()); err != nil {
+		t.Errorf("Lookup during the overlap = %v", err)
+	}
+	if _, err := read.Lookup(old, time.Now().Add(2*time.Hour)); err != ErrKeyRetired {
+		t.Errorf("Lookup after the overlap = %v, want %v", err, ErrKeyRetired)
+	}
+}
+
+func TestRotationNegotiatesKey(t *testing.T) {
+	_, authority := NewAuthority()
+	ring := NewKeyring(authority)
+	_, next, err := authority.Rotate(ring, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairing := authority.Pairing()
+	set := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}}
+	_, oldSignatures, err := authority.AuthorizeSet(set, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newSignatures, err := next.Reissue(authority.PublicKey(), set, oldSignatures, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverSignatures, err := next.AuthorizeSet(set[1:], ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(pairing, authority.PublicKey(), set, oldSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddKeyVersion(next.PublicKey(), set, newSignatures); err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, next.PublicKey(), set[1:], serverSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intersection, err := runSession(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := set[1:]; !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+
+	oldOnly, err := NewClient(pairing, authority.PublicKey(), set, oldSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSession(oldOnly, server); err != ErrNoCommonKey {
+		t.Errorf("session without a common key = %v, want %v", err, ErrNoCommonKey)
+	}
+}
+
+func TestSessionRequiresKeyID(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	client, err := NewClient(pairing, pk, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(pairing, pk, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, blinding := client.Blind()
+	blinding.KeyID = KeyID{}
+	if _, _, err := server.Respond(blinding); err != ErrMalformedMessage {
+		t.Errorf("Respond without a key ID = %v, want %v", err, ErrMalformedMessage)
+	}
+	if _, err := blinding.MarshalBinary(); err != ErrMalformedMessage {
+		t.Errorf("MarshalBinary without a key ID = %v, want %v", err, ErrMalformedMessage)
+	}
+	client.Blind()
+	if _, _, err := client.Intersect(&TagSetMessage{}); err != ErrMalformedMessage {
+		t.Errorf("Intersect without a key ID = %v, want %v", err, ErrMalformedMessage)
+	}
+	if _, err := UnmarshalTagSetMessage(make([]byte, 4)); err != ErrMalformedMessage {
+		t.Errorf("UnmarshalTagSetMessage without a key ID = %v, want %v", err, ErrMalformedMessage)
+	}
+}
@@ -0,0 +1,108 @@
+package apsi
+
+import (
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Party identifies which side of the protocol an authorization is for.
+type Party int
+
+const (
+	ClientParty Party = 0
+	ServerParty Party = 1
+)
+
+func (party Party) String() string {
+	switch party {
+	case ClientParty:
+		return "client"
+	case ServerParty:
+		return "server"
+	}
+	return "unknown"
+}
+
+// DualAPSIScheme runs all three roles of one Dual-APSI instance in a single
+// process. It is what the benchmarks use; deployments that put the roles in
+// different processes use Authority, Client and Server directly.
+type DualAPSIScheme struct {
+	authority *Authority
+	pairing   *pbc.Pairing
+	keyring   *Keyring
+
+	// authorities holds every key version, for interactions run under an
+	// older one.
+	authorities map[KeyID]*Authority
+
+	pk PublicKey
+}
+
+// NewDualAPSIScheme runs the Setup phase and returns the time it took along
+// with the new scheme.
+func NewDualAPSIScheme() (time.Duration, *DualAPSIScheme) {
+	setupTime, authority := NewAuthority()
+	return setupTime, &DualAPSIScheme{
+		authority: authority,
+		pairing:   authority.pairing,
+		keyring:   NewKeyring(authority),
+		pk:        authority.pk,
+
+		authorities: map[KeyID]*Authority{authority.pk.ID(): authority},
+	}
+}
+
+// Rotate moves the scheme to a new version of the judge's keys, keeping the
+// current one accepted for overlap. It returns the time taken and the new
+// version's key ID.
+func (scheme *DualAPSIScheme) Rotate(overlap time.Duration) (time.Duration, KeyID, error) {
+	rotateTime, next, err := scheme.authority.Rotate(scheme.keyring, overlap)
+	if err != nil {
+		return 0, KeyID{}, err
+	}
+	scheme.authority = next
+	scheme.pk = next.pk
+	scheme.authorities[next.pk.ID()] = next
+	return rotateTime, next.pk.ID(), nil
+}
+
+// Keyring returns every version of the judge's keys.
+func (scheme *DualAPSIScheme) Keyring() *Keyring {
+	return scheme.keyring
+}
+
+// Reissue moves authorizations issued under key version from onto the
+// current version. It fails once from has been retired.
+func (scheme *DualAPSIScheme) Reissue(from KeyID, set RawElementSlice, signatures []*pbc.Element, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	old, err := scheme.keyring.Lookup(from, time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+	return scheme.authority.Reissue(old, set, signatures, party, opts...)
+}
+
+// Authority returns the scheme's judge under the current key version.
+func (scheme *DualAPSIScheme) Authority() *Authority {
+	return scheme.authority
+}
+
+// Pairing returns the pairing the scheme operates over.
+func (scheme *DualAPSIScheme) Pairing() *pbc.Pairing {
+	return scheme.pairing
+}
+
+// PublicKey returns the judge's current public key.
+func (scheme *DualAPSIScheme) PublicKey() PublicKey {
+	return scheme.pk
+}
+
+// Authorize is shorthand for scheme.Authority().Authorize.
+func (scheme *DualAPSIScheme) Authorize(elt RawElement, party Party, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	return scheme.authority.Authorize(elt, party, opts...)
+}
+
+// AuthorizeSet is shorthand for scheme.Authority().AuthorizeSet.
+func (scheme *DualAPSIScheme) AuthorizeSet(elements RawElementSlice, party Party, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	return scheme.authority.AuthorizeSet(elements, party, opts...)
+}
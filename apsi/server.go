@@ -0,0 +1,170 @@
+package apsi
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Server holds the server's set and its authorizations yH(s_j). It keeps no
+// per-session state, so one Server can answer any number of clients. Like a
+// Client, it may hold authorizations under several key versions.
+type Server struct {
+	pairing *pbc.Pairing
+	cfg     *interactionConfig
+	keys    []serverKey
+
+	// registries holds the client registries of cfg whose signatures have
+	// been checked against the matching key version.
+	registries map[KeyID]*ClientRegistry
+
+	epoch      Epoch
+	revocation uint64
+
+	// ErrorLog, if set, receives the errors of sessions run by Serve.
+	ErrorLog *log.Logger
+
+	// Timeout bounds the reading or writing of each frame in a session
+	// run by ServeConn, so idle clients do not hold connections open. Zero
+	// means DefaultFrameTimeout; a negative Timeout disables it.
+	Timeout time.Duration
+}
+
+// serverKey is the server's set under one key version.
+type serverKey struct {
+	id         KeyID
+	set        RawElementSlice
+	signatures []*pbc.Element
+}
+
+// NewServer returns a server for set, where signatures[j] authorizes set[j].
+// Options apply to the server's own signatures as they would in an
+// interaction; WithEpoch and WithContext also make the server refuse
+// clients in other epochs or contexts.
+func NewServer(pairing *pbc.Pairing, pk PublicKey, set RawElementSlice, signatures []*pbc.Element, opts ...InteractionOption) (*Server, error) {
+	cfg := newInteractionConfig(opts)
+	if err := cfg.checkRevocationList(pairing, pk); err != nil {
+		return nil, err
+	}
+	server := &Server{
+		pairing:    pairing,
+		cfg:        cfg,
+		registries: make(map[KeyID]*ClientRegistry),
+		epoch:      cfg.epoch,
+		revocation: cfg.revocationVersion(),
+	}
+	if err := server.AddKeyVersion(pk, set, signatures); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// AddKeyVersion gives the server set authorized under another version of
+// the authority's keys. It must not be called while the server is serving.
+func (server *Server) AddKeyVersion(pk PublicKey, set RawElementSlice, signatures []*pbc.Element) error {
+	root := pk
+	pk, set, signatures, err := server.cfg.prepareParty(server.pairing, pk, set, signatures, ServerParty)
+	if err != nil {
+		return err
+	}
+	id := pk.ID()
+	for _, key := range server.keys {
+		if key.id == id {
+			return fmt.Errorf("apsi: server already holds key version %s", id)
+		}
+	}
+	// Registries are signed under the root key, but sessions name the
+	// version by the key they run under.
+	if registry := server.cfg.registries[root.ID()]; registry != nil {
+		if !registry.Verify(server.pairing, root) {
+			return ErrRegistrySignature
+		}
+		server.registries[id] = registry
+	}
+	server.keys = append(server.keys, serverKey{id: id, set: set, signatures: signatures})
+	return nil
+}
+
+// Respond answers a client's blinding with t_j = e(yH(s_j), rxP) for every
+// server element, under the first key version the client offers that the
+// server holds. It fails with ErrNoCommonKey if there is none, with
+// ErrEpochMismatch or ErrContextMismatch if the client is not in the
+// server's epoch or context, with ErrEpochOver if the server was given
+// WithEpochPeriod and its epoch is not the current one, with
+// ErrStaleRevocationList if the client claims to have applied an older
+// revocation list than the server, and with ErrUnknownClient or ErrClientProof if the
+// server has client registries and the client is not in the one for the
+// negotiated version.
+func (server *Server) Respond(msg *BlindingMessage) (time.Duration, *TagSetMessage, error) {
+	if msg == nil || msg.RxP == nil || msg.KeyID == (KeyID{}) {
+		return 0, nil, ErrMalformedMessage
+	}
+	if msg.Epoch != server.epoch {
+		return 0, nil, ErrEpochMismatch
+	}
+	if server.cfg.period > 0 && EpochOf(time.Now(), server.cfg.period) != server.epoch {
+		return 0, nil, ErrEpochOver
+	}
+	if msg.Context != server.cfg.context {
+		return 0, nil, ErrContextMismatch
+	}
+	// The version is the client's word; this catches honest clients with
+	// an out-of-date list, not clients that ignore it.
+	if msg.RevocationVersion < server.revocation {
+		return 0, nil, ErrStaleRevocationList
+	}
+
+	offered := []KeyID{msg.KeyID}
+	blindings := []KeyBlinding{{KeyID: msg.KeyID, RxP: msg.RxP, Proof: msg.Proof}}
+	for _, alternate := range msg.Alternates {
+		offered = append(offered, alternate.KeyID)
+		blindings = append(blindings, alternate)
+	}
+	held := make([]KeyID, len(server.keys))
+	for i, k := range server.keys {
+		held[i] = k.id
+	}
+	o, h, err := negotiateKey(offered, held)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, blinding := &server.keys[h], blindings[o]
+	if len(server.cfg.registries) > 0 {
+		err := checkClient(server.pairing, server.registries[key.id], msg.ClientID, blinding.RxP, blinding.Proof)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	rxP := blinding.RxP
+
+	startTime := time.Now()
+
+	// Step 1: S -> C: {t_0, ..., t_{n-1}}
+	// where t_j = e(H(s_j)^y, P^xr_c)
+	tags := make([]Tag, len(key.signatures))
+	e_sig_rxP := server.pairing.NewGT()
+	for j, signature := range key.signatures {
+		// Recall that signature = H(s_j)^y.
+		e_sig_rxP.Pair(signature, rxP)
+		tags[j] = tagOf(e_sig_rxP)
+	}
+	sort.Slice(tags, func(a, b int) bool {
+		return bytes.Compare(tags[a][:], tags[b][:]) < 0
+	})
+
+	totalTime := time.Since(startTime)
+	err = server.cfg.recordSession(AuditEntry{
+		Party:      ServerParty,
+		KeyID:      key.id,
+		ClientID:   msg.ClientID,
+		ServerSize: len(key.set),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalTime, &TagSetMessage{Tags: tags, KeyID: key.id}, nil
+}
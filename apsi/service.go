@@ -0,0 +1,350 @@
+package apsi
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// The signing service lets parties ask a remote authority for
+// authorizations, so that x and y stay on the authority's host. It speaks
+// the frame protocol of the sessions, with an Issue message carrying a
+// batch to sign and an Issued message carrying the signatures back, over
+// mutual TLS: the authority only answers peers with a certificate from the
+// CA it trusts, and the common name of that certificate is the identity it
+// grants parties to.
+
+var (
+	// ErrNotTLS is returned when the signing service is handed a
+	// connection that is not TLS, and so names no peer.
+	ErrNotTLS = errors.New("apsi: signing service needs a TLS connection")
+
+	// ErrForbidden is returned when a peer asks for authorizations of a
+	// party it has not been granted.
+	ErrForbidden = errors.New("apsi: peer may not request authorizations for this party")
+)
+
+// MaxIssueElements is the largest batch one Issue message may carry.
+const MaxIssueElements = 1 << 16
+
+// IssueMessage asks the authority to sign a batch. It is a submission
+// without the requester, whom the authority learns from the TLS
+// certificate instead:
+//
+//	party (1) | epoch (8) | len(case) (2) | case | len(purpose) (2) | purpose |
+//	len(counterparty) (2) | counterparty | count (4) | elements |
+//	fields (2) | (len(field) (2) | field | len(value) (2) | value)...
+type IssueMessage struct {
+	RequestSubmission
+}
+
+// MarshalBinary encodes the message as above.
+func (msg *IssueMessage) MarshalBinary() ([]byte, error) {
+	if len(msg.Elements) > MaxIssueElements || len(msg.Justification) > 0xffff {
+		return nil, ErrFrameTooLarge
+	}
+	b := []byte{byte(msg.Party)}
+	b = appendUint64(b, uint64(msg.Epoch))
+	b = appendBytes16(b, []byte(msg.Context.Case))
+	b = appendBytes16(b, []byte(msg.Context.Purpose))
+	b = appendBytes16(b, []byte(msg.Context.Counterparty))
+	b = appendUint32(b, uint32(len(msg.Elements)))
+	for _, elt := range msg.Elements {
+		b = append(b, elt[:]...)
+	}
+	fields := make([]string, 0, len(msg.Justification))
+	for field := range msg.Justification {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	b = appendUint16(b, uint16(len(fields)))
+	for _, field := range fields {
+		b = appendBytes16(b, []byte(field))
+		b = appendBytes16(b, []byte(msg.Justification[field]))
+	}
+	return b, nil
+}
+
+// UnmarshalIssueMessage decodes a message encoded by MarshalBinary.
+func UnmarshalIssueMessage(data []byte) (*IssueMessage, error) {
+	r := &wireReader{buf: data}
+	msg := &IssueMessage{}
+	party := r.next(1)
+	msg.Epoch = Epoch(r.uint64())
+	msg.Context.Case = string(r.bytes16())
+	msg.Context.Purpose = string(r.bytes16())
+	msg.Context.Counterparty = string(r.bytes16())
+	count := r.uint32()
+	if count > MaxIssueElements {
+		return nil, ErrFrameTooLarge
+	}
+	if r.err == nil {
+		msg.Elements = make(RawElementSlice, count)
+		for i := range msg.Elements {
+			copy(msg.Elements[i][:], r.next(len(RawElement{})))
+		}
+	}
+	fields := int(r.uint16())
+	msg.Justification = make(map[string]string, fields)
+	for i := 0; i < fields && r.err == nil; i++ {
+		field := string(r.bytes16())
+		msg.Justification[field] = string(r.bytes16())
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	msg.Party = Party(party[0])
+	if msg.Party != ClientParty && msg.Party != ServerParty {
+		return nil, ErrUnknownParty
+	}
+	return msg, nil
+}
+
+// IssuedMessage carries the signatures of an IssueMessage's elements back,
+// in order, along with the key version they were signed under:
+//
+//	key ID (8) | count (4) | (len(signature) (2) | signature)...
+type IssuedMessage struct {
+	KeyID      KeyID
+	Signatures []*pbc.Element
+}
+
+// MarshalBinary encodes the message as above.
+func (msg *IssuedMessage) MarshalBinary() ([]byte, error) {
+	b := append([]byte(nil), msg.KeyID[:]...)
+	b = appendUint32(b, uint32(len(msg.Signatures)))
+	for _, signature := range msg.Signatures {
+		b = appendBytes16(b, signature.Bytes())
+	}
+	return b, nil
+}
+
+// UnmarshalIssuedMessage decodes a message encoded by MarshalBinary.
+func UnmarshalIssuedMessage(pairing *pbc.Pairing, data []byte) (*IssuedMessage, error) {
+	r := &wireReader{buf: data}
+	msg := &IssuedMessage{}
+	copy(msg.KeyID[:], r.next(len(msg.KeyID)))
+	count := r.uint32()
+	if count > MaxIssueElements {
+		return nil, ErrFrameTooLarge
+	}
+	encoded := make([][]byte, 0, count)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		encoded = append(encoded, r.bytes16())
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	msg.Signatures = make([]*pbc.Element, len(encoded))
+	for i, b := range encoded {
+		var err error
+		if msg.Signatures[i], err = decodeG1(pairing, b); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// SigningService answers Issue requests with a signer, for the peers it
+// has granted each party to.
+type SigningService struct {
+	mu     sync.RWMutex
+	signer RequestSigner
+	keyID  KeyID
+	grants map[string]map[Party]bool
+
+	// ErrorLog, if set, receives the errors of requests served by Serve.
+	ErrorLog *log.Logger
+
+	// Timeout bounds the TLS handshake and the reading or writing of each
+	// frame of a request answered by ServeConn, so idle peers do not hold
+	// connections open. Zero means DefaultFrameTimeout; a negative Timeout
+	// disables it.
+	Timeout time.Duration
+}
+
+// NewSigningService returns a service that signs with signer, which holds
+// the key pk. No peer is granted anything yet.
+func NewSigningService(signer RequestSigner, pk PublicKey) *SigningService {
+	return &SigningService{
+		signer: signer,
+		keyID:  pk.ID(),
+		grants: make(map[string]map[Party]bool),
+	}
+}
+
+// Grant lets the peer whose certificate names identity request
+// authorizations for parties.
+func (service *SigningService) Grant(identity string, parties ...Party) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.grants[identity] == nil {
+		service.grants[identity] = make(map[Party]bool)
+	}
+	for _, party := range parties {
+		service.grants[identity][party] = true
+	}
+}
+
+// Revoke withdraws every grant of identity.
+func (service *SigningService) Revoke(identity string) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.grants, identity)
+}
+
+func (service *SigningService) granted(identity string, party Party) bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	return service.grants[identity][party]
+}
+
+// peerIdentity completes the TLS handshake on conn within timeout, if it
+// is positive, and returns the common name of the peer's verified
+// certificate.
+func peerIdentity(conn net.Conn, timeout time.Duration) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", ErrNotTLS
+	}
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	chains := tlsConn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", errors.New("apsi: peer presented no verified certificate")
+	}
+	return chains[0][0].Subject.CommonName, nil
+}
+
+// ServeConn answers one Issue request on conn and closes it. It fails with
+// ErrNotTLS unless conn is a TLS connection, and reports requests for a
+// party the peer was not granted as ErrForbidden and requests its signer's
+// policy denies as a *PolicyError, to the peer as well as to the caller.
+// The handshake and each frame must complete within service.Timeout.
+func (service *SigningService) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	timeout := frameTimeout(service.Timeout)
+	identity, err := peerIdentity(conn, timeout)
+	if err != nil {
+		return err
+	}
+	wire := &wireConn{rw: conn, timeout: timeout}
+	if err := wire.serverHandshake(); err != nil {
+		return err
+	}
+	payload, err := wire.readFrame(MessageIssue)
+	if err != nil {
+		return err
+	}
+	msg, err := UnmarshalIssueMessage(payload)
+	if err != nil {
+		wire.sendError(CodeMalformed, err)
+		return err
+	}
+	if !service.granted(identity, msg.Party) {
+		err := fmt.Errorf("%v: %s asked for %s authorizations", ErrForbidden, identity, msg.Party)
+		wire.sendError(CodeForbidden, err)
+		return err
+	}
+
+	signatures, err := service.signer.signRequest(&AuthorizationRequest{
+		Requester:     identity,
+		Party:         msg.Party,
+		Elements:      msg.Elements,
+		Justification: msg.Justification,
+		Options:       msg.authorizeOptions(),
+	})
+	if _, denied := err.(*PolicyError); denied {
+		wire.sendError(CodePolicy, err)
+		return err
+	}
+	if err != nil {
+		wire.sendError(CodeInternal, err)
+		return err
+	}
+	payload, err = (&IssuedMessage{KeyID: service.keyID, Signatures: signatures}).MarshalBinary()
+	if err != nil {
+		wire.sendError(CodeInternal, err)
+		return err
+	}
+	return wire.writeFrame(MessageIssued, payload)
+}
+
+// Serve accepts connections on listener and answers each one on its own
+// goroutine until Accept fails. It returns once the requests it has
+// accepted are answered, so closing the listener lets a caller wait for
+// batches that are being signed.
+func (service *SigningService) Serve(listener net.Listener) error {
+	var serving sync.WaitGroup
+	defer serving.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			if err := service.ServeConn(conn); err != nil && service.ErrorLog != nil {
+				service.ErrorLog.Printf("apsi: issue request from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// RequestIssue asks the signing service on the other end of conn to sign
+// sub, closes conn and returns the signatures once they verify under pk.
+// The returned duration covers the verification, not the network or the
+// authority's signing time.
+func RequestIssue(conn net.Conn, pairing *pbc.Pairing, pk PublicKey, sub RequestSubmission) (time.Duration, []*pbc.Element, error) {
+	defer conn.Close()
+
+	payload, err := (&IssueMessage{RequestSubmission: sub}).MarshalBinary()
+	if err != nil {
+		return 0, nil, err
+	}
+	wire := &wireConn{rw: conn}
+	if err := wire.clientHandshake(); err != nil {
+		return 0, nil, err
+	}
+	if err := wire.writeFrame(MessageIssue, payload); err != nil {
+		return 0, nil, err
+	}
+	payload, err = wire.readFrame(MessageIssued)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply, err := UnmarshalIssuedMessage(pairing, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	if reply.KeyID != pk.ID() {
+		return 0, nil, ErrUnknownKey
+	}
+	if len(reply.Signatures) != len(sub.Elements) {
+		return 0, nil, ErrSignatureCount
+	}
+
+	startTime := time.Now()
+	invalid, err := BatchVerify(pairing, pk, sub.Elements, reply.Signatures, sub.Party, sub.authorizeOptions()...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(invalid) > 0 {
+		return 0, nil, invalidSignatures(sub.Party, invalid)
+	}
+	return time.Since(startTime), reply.Signatures, nil
+}
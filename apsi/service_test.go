@@ -0,0 +1,48 @@
+package apsi
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeWaitsForRequests(t *testing.T) {
+	_, authority := NewAuthority()
+	service := NewSigningService(authority, authority.PublicKey())
+	service.Timeout = -1
+
+	transport := NewPipeTransport()
+	listener, _ := transport.Listen()
+	served := make(chan error, 1)
+	go func() {
+		served <- service.Serve(tls.NewListener(listener, &tls.Config{}))
+	}()
+	clientConn, err := transport.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned while a request was open: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clientConn.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return once the request was done")
+	}
+}
+
+func TestServeConnNotTLS(t *testing.T) {
+	_, authority := NewAuthority()
+	service := NewSigningService(authority, authority.PublicKey())
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	if err := service.ServeConn(serverConn); err != ErrNotTLS {
+		t.Errorf("ServeConn = %v, want %v", err, ErrNotTLS)
+	}
+}
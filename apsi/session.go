@@ -0,0 +1,113 @@
+package apsi
+
+import (
+	"net"
+	"time"
+)
+
+// Query runs one session with the server on the other end of conn, closes
+// conn and returns the intersection. Only the client learns the result. The
+// returned duration covers the client's computation, not network time.
+// Each frame must arrive or be sent within client.Timeout.
+func (client *Client) Query(conn net.Conn) (time.Duration, RawElementSlice, error) {
+	defer conn.Close()
+
+	wire := &wireConn{rw: conn, timeout: frameTimeout(client.Timeout)}
+	if err := wire.clientHandshake(); err != nil {
+		return 0, nil, err
+	}
+
+	blindTime, blinding := client.Blind()
+	payload, err := blinding.MarshalBinary()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := wire.writeFrame(MessageBlinding, payload); err != nil {
+		return 0, nil, err
+	}
+
+	payload, err = wire.readFrame(MessageTagSet)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply, err := UnmarshalTagSetMessage(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	intersectTime, intersection, err := client.Intersect(reply)
+	if err != nil {
+		return 0, nil, err
+	}
+	return blindTime + intersectTime, intersection, nil
+}
+
+// ServeConn answers one client session on conn and closes it. Each frame
+// must arrive or be sent within server.Timeout.
+func (server *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	wire := &wireConn{rw: conn, timeout: frameTimeout(server.Timeout)}
+	if err := wire.serverHandshake(); err != nil {
+		return err
+	}
+
+	payload, err := wire.readFrame(MessageBlinding)
+	if err != nil {
+		return err
+	}
+	request, err := UnmarshalBlindingMessage(server.pairing, payload)
+	if err != nil {
+		wire.sendError(CodeMalformed, err)
+		return err
+	}
+
+	_, reply, err := server.Respond(request)
+	if err == ErrEpochMismatch || err == ErrEpochOver {
+		wire.sendError(CodeEpoch, err)
+		return err
+	}
+	if err == ErrContextMismatch {
+		wire.sendError(CodeContext, err)
+		return err
+	}
+	if err == ErrStaleRevocationList {
+		wire.sendError(CodeRevocation, err)
+		return err
+	}
+	if err == ErrNoCommonKey {
+		wire.sendError(CodeKey, err)
+		return err
+	}
+	if err == ErrUnknownClient || err == ErrClientProof {
+		wire.sendError(CodeClient, err)
+		return err
+	}
+	if err != nil {
+		wire.sendError(CodeInternal, err)
+		return err
+	}
+	payload, err = reply.MarshalBinary()
+	if err != nil {
+		wire.sendError(CodeInternal, err)
+		return err
+	}
+	return wire.writeFrame(MessageTagSet, payload)
+}
+
+// Serve accepts connections on listener and answers each one on its own
+// goroutine until Accept fails. Errors from individual sessions go to
+// server.ErrorLog if it is set.
+func (server *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := server.ServeConn(conn); err != nil && server.ErrorLog != nil {
+				server.ErrorLog.Printf("apsi: session with %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
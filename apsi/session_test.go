@@ -0,0 +1,112 @@
+package apsi
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestQueryOverPipe(t *testing.T) {
+	_, scheme := NewDualAPSIScheme()
+	clientSet := RawElementSlice{{0, 0, 0, 1}, {0, 0, 0, 2}, {0, 0, 0, 3}}
+	serverSet := RawElementSlice{{0, 0, 0, 2}, {0, 0, 0, 3}, {0, 0, 0, 4}}
+	_, clientSignatures, err := scheme.AuthorizeSet(clientSet, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverSignatures, err := scheme.AuthorizeSet(serverSet, ServerParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(scheme.Pairing(), scheme.PublicKey(), clientSet, clientSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(scheme.Pairing(), scheme.PublicKey(), serverSet, serverSignatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeConn(serverConn)
+	}()
+	_, intersection, err := client.Query(clientConn)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("ServeConn: %v", err)
+	}
+	sort.Sort(intersection)
+	if want := (RawElementSlice{{0, 0, 0, 2}, {0, 0, 0, 3}}); !reflect.DeepEqual(intersection, want) {
+		t.Errorf("intersection = %x, want %x", intersection, want)
+	}
+}
+
+func TestServeConnVersionMismatch(t *testing.T) {
+	_, scheme := NewDualAPSIScheme()
+	server, err := NewServer(scheme.Pairing(), scheme.PublicKey(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeConn(serverConn)
+	}()
+	wire := &wireConn{rw: clientConn}
+	if err := wire.writeFrame(MessageHello, []byte{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = wire.readFrame(MessageHelloAck)
+	if perr, ok := err.(*ProtocolError); !ok || perr.Code != CodeVersion {
+		t.Errorf("client got %v, want peer error %d", err, CodeVersion)
+	}
+	if err := <-served; err != ErrVersionMismatch {
+		t.Errorf("ServeConn = %v, want %v", err, ErrVersionMismatch)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	_, scheme := NewDualAPSIScheme()
+	client, err := NewClient(scheme.Pairing(), scheme.PublicKey(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Timeout = 50 * time.Millisecond
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	queried := make(chan error, 1)
+	go func() {
+		_, _, err := client.Query(clientConn)
+		queried <- err
+	}()
+	select {
+	case err := <-queried:
+		if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+			t.Errorf("Query = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Query did not time out on a silent server")
+	}
+}
+
+// runSession runs one session between client and server in memory and
+// returns the intersection in increasing order.
+func runSession(client *Client, server *Server) (RawElementSlice, error) {
+	_, blinding := client.Blind()
+	_, tags, err := server.Respond(blinding)
+	if err != nil {
+		return nil, err
+	}
+	_, intersection, err := client.Intersect(tags)
+	sort.Sort(intersection)
+	return intersection, err
+}
@@ -0,0 +1,539 @@
+package apsi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Threshold issuance splits x and y with Shamir's scheme so that any t of n
+// signers can authorize an element, while t-1 of them learn nothing about
+// SK_J. Signer i holds x_i = f(i) and y_i = g(i) for random polynomials f
+// and g of degree t-1 with f(0) = x and g(0) = y. Its partial authorization
+// of elt is x_iH(elt), and any t partials combine by Lagrange interpolation
+// in the exponent:
+//
+//	sum_{i in S} lambda_i x_iH(elt) = f(0)H(elt) = xH(elt)
+//
+// The result is exactly what Authorize would have produced, so clients and
+// servers cannot tell a threshold authority from a single one.
+//
+// Shares come either from an existing authority (Split, which acts as a
+// trusted dealer) or from distributed key generation, in which x and y never
+// exist in one place. Every signer deals its own pair of random polynomials
+// and publishes Feldman commitments a_kP to their coefficients; a signer's
+// share is the sum of what it was dealt, and PK_J is the sum of the dealt
+// constant terms.
+
+// MaxSigners is the largest number of signers a threshold key can have.
+const MaxSigners = 1<<16 - 1
+
+var (
+	// ErrThreshold is returned when a threshold is not between 1 and the
+	// number of signers.
+	ErrThreshold = errors.New("apsi: threshold must be between 1 and the number of signers")
+
+	// ErrSignerIndex is returned for a signer index outside 1..n.
+	ErrSignerIndex = errors.New("apsi: signer index out of range")
+
+	// ErrNotEnoughShares is returned when fewer than t valid partial
+	// authorizations are available to combine.
+	ErrNotEnoughShares = errors.New("apsi: not enough valid partial authorizations")
+)
+
+// BadShareError is returned by FinishDKG when shares dealt to this signer
+// do not match their dealers' commitments. FinishDKG needs a valid share
+// from every one of the n dealers, so no signer can finish: the whole of
+// key generation has to be rerun, with every signer dealing afresh and the
+// listed dealers replaced.
+type BadShareError struct {
+	Dealers []int
+}
+
+func (e *BadShareError) Error() string {
+	return fmt.Sprintf("apsi: shares from dealers %v do not match their commitments", e.Dealers)
+}
+
+func checkThreshold(threshold, signers int) error {
+	if signers < 1 || signers > MaxSigners || threshold < 1 || threshold > signers {
+		return ErrThreshold
+	}
+	return nil
+}
+
+// ThresholdSetup is what the signers agree on before key generation: the
+// pairing parameters, the generator P and the threshold.
+type ThresholdSetup struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	P         *pbc.Element
+	Threshold int
+	Signers   int
+}
+
+// NewThresholdSetup generates fresh pairing parameters and a generator for
+// a threshold-of-signers key.
+func NewThresholdSetup(threshold, signers int) (*ThresholdSetup, error) {
+	if err := checkThreshold(threshold, signers); err != nil {
+		return nil, err
+	}
+	params := pbc.GenerateA(160, 512)
+	pairing := params.NewPairing()
+	return &ThresholdSetup{
+		params:    params,
+		pairing:   pairing,
+		P:         pairing.NewG1().Rand(),
+		Threshold: threshold,
+		Signers:   signers,
+	}, nil
+}
+
+// Pairing returns the pairing the setup lives in.
+func (setup *ThresholdSetup) Pairing() *pbc.Pairing {
+	return setup.pairing
+}
+
+func (setup *ThresholdSetup) checkIndex(index int) error {
+	if index < 1 || index > setup.Signers {
+		return ErrSignerIndex
+	}
+	return nil
+}
+
+// ThresholdPublicKey is PK_J together with the public halves x_iP and y_iP
+// of every signer's share, which let anyone check a partial authorization.
+type ThresholdPublicKey struct {
+	params  *pbc.Params
+	pairing *pbc.Pairing
+
+	PublicKey
+	Threshold int
+
+	// XShares[i-1] and YShares[i-1] belong to signer i.
+	XShares []*pbc.Element
+	YShares []*pbc.Element
+}
+
+// Pairing returns the pairing the key lives in.
+func (tpk *ThresholdPublicKey) Pairing() *pbc.Pairing {
+	return tpk.pairing
+}
+
+// Signers returns n, the number of shares the key was split into.
+func (tpk *ThresholdPublicKey) Signers() int {
+	return len(tpk.XShares)
+}
+
+func (tpk *ThresholdPublicKey) shareKey(index int, party Party) (*pbc.Element, error) {
+	if index < 1 || index > tpk.Signers() {
+		return nil, ErrSignerIndex
+	}
+	switch party {
+	case ClientParty:
+		return tpk.XShares[index-1], nil
+	case ServerParty:
+		return tpk.YShares[index-1], nil
+	}
+	return nil, ErrUnknownParty
+}
+
+// ThresholdSigner holds one share (x_i, y_i) of SK_J.
+type ThresholdSigner struct {
+	pk    *ThresholdPublicKey
+	index int
+	share SecretKey
+}
+
+// Index returns the signer's index i, between 1 and n.
+func (signer *ThresholdSigner) Index() int {
+	return signer.index
+}
+
+// PublicKey returns the threshold public key the signer's share belongs to.
+func (signer *ThresholdSigner) PublicKey() *ThresholdPublicKey {
+	return signer.pk
+}
+
+// PartialAuthorization is one signer's share of an authorization.
+type PartialAuthorization struct {
+	Signer    int
+	Signature *pbc.Element
+}
+
+// PartialAuthorize signs elt with the signer's share, returning x_iH(elt)
+// for the client and y_iH(elt) for the server.
+func (signer *ThresholdSigner) PartialAuthorize(elt RawElement, party Party, opts ...AuthorizeOption) (time.Duration, PartialAuthorization, error) {
+	secretShare, err := signer.share.forParty(party)
+	if err != nil {
+		return 0, PartialAuthorization{}, err
+	}
+
+	startTime := time.Now()
+
+	pairing := signer.pk.pairing
+	signature := pairing.NewG1().MulZn(newBinding(opts).hash(pairing, elt), secretShare)
+
+	totalTime := time.Since(startTime)
+	return totalTime, PartialAuthorization{Signer: signer.index, Signature: signature}, nil
+}
+
+// PartialAuthorizeSet partially signs every element of elements for the
+// given party. The returned duration is the total signing time.
+func (signer *ThresholdSigner) PartialAuthorizeSet(elements RawElementSlice, party Party, opts ...AuthorizeOption) (time.Duration, []PartialAuthorization, error) {
+	var totalTime time.Duration
+	partials := make([]PartialAuthorization, len(elements))
+	for i, element := range elements {
+		signingTime, partial, err := signer.PartialAuthorize(element, party, opts...)
+		if err != nil {
+			return totalTime, nil, err
+		}
+		partials[i] = partial
+		totalTime += signingTime
+	}
+	return totalTime, partials, nil
+}
+
+// VerifyPartial checks a partial authorization of elt against the signer's
+// public share: e(sig, P) == e(H(elt), x_iP).
+func (tpk *ThresholdPublicKey) VerifyPartial(elt RawElement, partial PartialAuthorization, party Party, opts ...AuthorizeOption) (bool, error) {
+	shareKey, err := tpk.shareKey(partial.Signer, party)
+	if err != nil {
+		return false, err
+	}
+	if partial.Signature == nil {
+		return false, nil
+	}
+
+	e_sig_P := tpk.pairing.NewGT().Pair(partial.Signature, tpk.P)
+	e_H_xiP := tpk.pairing.NewGT().Pair(newBinding(opts).hash(tpk.pairing, elt), shareKey)
+	return e_sig_P.Equals(e_H_xiP), nil
+}
+
+// Combine turns partial authorizations of elt into the full authorization
+// xH(elt) (or yH(elt)). Every partial is verified first; invalid ones,
+// repeats and unknown signers are skipped, and the first t valid partials
+// are interpolated. It fails with ErrNotEnoughShares if fewer than t are
+// valid.
+func (tpk *ThresholdPublicKey) Combine(elt RawElement, party Party, partials []PartialAuthorization, opts ...AuthorizeOption) (time.Duration, *pbc.Element, error) {
+	if _, err := tpk.PublicKey.forParty(party); err != nil {
+		return 0, nil, err
+	}
+
+	startTime := time.Now()
+
+	seen := make(map[int]bool, len(partials))
+	var valid []PartialAuthorization
+	for _, partial := range partials {
+		if len(valid) == tpk.Threshold {
+			break
+		}
+		if seen[partial.Signer] {
+			continue
+		}
+		ok, err := tpk.VerifyPartial(elt, partial, party, opts...)
+		if err == ErrSignerIndex || (err == nil && !ok) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		seen[partial.Signer] = true
+		valid = append(valid, partial)
+	}
+	if len(valid) < tpk.Threshold {
+		return time.Since(startTime), nil, ErrNotEnoughShares
+	}
+
+	indices := make([]int, len(valid))
+	for i, partial := range valid {
+		indices[i] = partial.Signer
+	}
+	coefficients := lagrangeAtZero(tpk.pairing, indices)
+
+	signature := tpk.pairing.NewG1().Set0()
+	for i, partial := range valid {
+		term := tpk.pairing.NewG1().MulZn(partial.Signature, coefficients[i])
+		signature.Add(signature, term)
+	}
+
+	totalTime := time.Since(startTime)
+	return totalTime, signature, nil
+}
+
+// CombineSet runs Combine for every element of set, where partials[i] holds
+// the partial authorizations of set[i]. It stops at the first element that
+// cannot be combined. The returned duration is the total combining time.
+func (tpk *ThresholdPublicKey) CombineSet(set RawElementSlice, party Party, partials [][]PartialAuthorization, opts ...AuthorizeOption) (time.Duration, []*pbc.Element, error) {
+	if len(set) != len(partials) {
+		return 0, nil, ErrSignatureCount
+	}
+	var totalTime time.Duration
+	signatures := make([]*pbc.Element, len(set))
+	for i, elt := range set {
+		combineTime, signature, err := tpk.Combine(elt, party, partials[i], opts...)
+		totalTime += combineTime
+		if err != nil {
+			return totalTime, nil, err
+		}
+		signatures[i] = signature
+	}
+	return totalTime, signatures, nil
+}
+
+// Split acts as a trusted dealer and splits the authority's SK_J into one
+// share per signer, any threshold of which can authorize. The authority's
+// own key file should be destroyed afterwards, or the split buys nothing.
+func (authority *Authority) Split(threshold, signers int) (*ThresholdPublicKey, []*ThresholdSigner, error) {
+	if err := checkThreshold(threshold, signers); err != nil {
+		return nil, nil, err
+	}
+	pairing := authority.pairing
+	f := randomPolynomial(pairing, authority.sk.X, threshold-1)
+	g := randomPolynomial(pairing, authority.sk.Y, threshold-1)
+
+	tpk := &ThresholdPublicKey{
+		params:    authority.params,
+		pairing:   pairing,
+		PublicKey: authority.pk,
+		Threshold: threshold,
+		XShares:   make([]*pbc.Element, signers),
+		YShares:   make([]*pbc.Element, signers),
+	}
+	shares := make([]*ThresholdSigner, signers)
+	for i := range shares {
+		share := SecretKey{X: f.eval(pairing, i+1), Y: g.eval(pairing, i+1)}
+		tpk.XShares[i] = pairing.NewG1().MulZn(authority.pk.P, share.X)
+		tpk.YShares[i] = pairing.NewG1().MulZn(authority.pk.P, share.Y)
+		shares[i] = &ThresholdSigner{pk: tpk, index: i + 1, share: share}
+	}
+	return tpk, shares, nil
+}
+
+// DKGDeal is one signer's contribution to distributed key generation: a
+// random polynomial for each of x and y. The dealer publishes the
+// commitment and hands Share(j) privately to every signer j, itself
+// included.
+type DKGDeal struct {
+	setup  *ThresholdSetup
+	dealer int
+	f, g   polynomial
+}
+
+// DKGCommitment holds a dealer's Feldman commitments a_kP to the
+// coefficients of its two polynomials, constant term first.
+type DKGCommitment struct {
+	Dealer int
+	X      []*pbc.Element
+	Y      []*pbc.Element
+}
+
+// DKGShare is the value of a dealer's polynomials at a recipient's index.
+// It is secret and must only reach the recipient.
+type DKGShare struct {
+	Dealer    int
+	Recipient int
+	X         *pbc.Element
+	Y         *pbc.Element
+}
+
+// NewDeal starts key generation for the signer with index dealer.
+func (setup *ThresholdSetup) NewDeal(dealer int) (*DKGDeal, error) {
+	if err := setup.checkIndex(dealer); err != nil {
+		return nil, err
+	}
+	degree := setup.Threshold - 1
+	return &DKGDeal{
+		setup:  setup,
+		dealer: dealer,
+		f:      randomPolynomial(setup.pairing, setup.pairing.NewZr().Rand(), degree),
+		g:      randomPolynomial(setup.pairing, setup.pairing.NewZr().Rand(), degree),
+	}, nil
+}
+
+// Commitment returns the deal's public commitment.
+func (deal *DKGDeal) Commitment() *DKGCommitment {
+	return &DKGCommitment{
+		Dealer: deal.dealer,
+		X:      deal.f.commit(deal.setup.pairing, deal.setup.P),
+		Y:      deal.g.commit(deal.setup.pairing, deal.setup.P),
+	}
+}
+
+// Share returns the share of the deal meant for recipient.
+func (deal *DKGDeal) Share(recipient int) (*DKGShare, error) {
+	if err := deal.setup.checkIndex(recipient); err != nil {
+		return nil, err
+	}
+	return &DKGShare{
+		Dealer:    deal.dealer,
+		Recipient: recipient,
+		X:         deal.f.eval(deal.setup.pairing, recipient),
+		Y:         deal.g.eval(deal.setup.pairing, recipient),
+	}, nil
+}
+
+// VerifyShare checks a dealt share against its dealer's commitment:
+// share.X P == sum_k recipient^k C_k, and the same for Y.
+func (setup *ThresholdSetup) VerifyShare(commitment *DKGCommitment, share *DKGShare) bool {
+	if commitment.Dealer != share.Dealer || setup.checkIndex(share.Recipient) != nil {
+		return false
+	}
+	xP := setup.pairing.NewG1().MulZn(setup.P, share.X)
+	yP := setup.pairing.NewG1().MulZn(setup.P, share.Y)
+	return xP.Equals(evalCommitment(setup.pairing, commitment.X, share.Recipient)) &&
+		yP.Equals(evalCommitment(setup.pairing, commitment.Y, share.Recipient))
+}
+
+// FinishDKG completes key generation for the signer with the given index.
+// It needs the commitment of every dealer 1..n and the share each of them
+// dealt to index. Shares that do not match their commitments are reported
+// in a *BadShareError. Every signer that finishes computes the same
+// ThresholdPublicKey.
+func (setup *ThresholdSetup) FinishDKG(index int, commitments []*DKGCommitment, shares []*DKGShare) (*ThresholdSigner, error) {
+	if err := setup.checkIndex(index); err != nil {
+		return nil, err
+	}
+	byDealer := make([]*DKGCommitment, setup.Signers+1)
+	for _, commitment := range commitments {
+		if err := setup.checkIndex(commitment.Dealer); err != nil {
+			return nil, err
+		}
+		if byDealer[commitment.Dealer] != nil {
+			return nil, fmt.Errorf("apsi: more than one commitment from dealer %d", commitment.Dealer)
+		}
+		if len(commitment.X) != setup.Threshold || len(commitment.Y) != setup.Threshold {
+			return nil, fmt.Errorf("apsi: commitment from dealer %d has the wrong degree", commitment.Dealer)
+		}
+		byDealer[commitment.Dealer] = commitment
+	}
+	for dealer := 1; dealer <= setup.Signers; dealer++ {
+		if byDealer[dealer] == nil {
+			return nil, fmt.Errorf("apsi: no commitment from dealer %d", dealer)
+		}
+	}
+
+	dealt := make([]bool, setup.Signers+1)
+	share := SecretKey{X: setup.pairing.NewZr().Set0(), Y: setup.pairing.NewZr().Set0()}
+	var bad []int
+	for _, s := range shares {
+		if s.Recipient != index {
+			return nil, fmt.Errorf("apsi: share from dealer %d is for signer %d", s.Dealer, s.Recipient)
+		}
+		if err := setup.checkIndex(s.Dealer); err != nil {
+			return nil, err
+		}
+		if dealt[s.Dealer] {
+			return nil, fmt.Errorf("apsi: more than one share from dealer %d", s.Dealer)
+		}
+		dealt[s.Dealer] = true
+		if !setup.VerifyShare(byDealer[s.Dealer], s) {
+			bad = append(bad, s.Dealer)
+			continue
+		}
+		share.X.Add(share.X, s.X)
+		share.Y.Add(share.Y, s.Y)
+	}
+	if bad != nil {
+		return nil, &BadShareError{Dealers: bad}
+	}
+	for dealer := 1; dealer <= setup.Signers; dealer++ {
+		if !dealt[dealer] {
+			return nil, fmt.Errorf("apsi: no share from dealer %d", dealer)
+		}
+	}
+
+	tpk := &ThresholdPublicKey{
+		params:    setup.params,
+		pairing:   setup.pairing,
+		PublicKey: PublicKey{P: setup.P, XP: setup.pairing.NewG1().Set0(), YP: setup.pairing.NewG1().Set0()},
+		Threshold: setup.Threshold,
+		XShares:   make([]*pbc.Element, setup.Signers),
+		YShares:   make([]*pbc.Element, setup.Signers),
+	}
+	for i := range tpk.XShares {
+		tpk.XShares[i] = setup.pairing.NewG1().Set0()
+		tpk.YShares[i] = setup.pairing.NewG1().Set0()
+	}
+	for _, commitment := range byDealer[1:] {
+		tpk.XP.Add(tpk.XP, commitment.X[0])
+		tpk.YP.Add(tpk.YP, commitment.Y[0])
+		for i := range tpk.XShares {
+			tpk.XShares[i].Add(tpk.XShares[i], evalCommitment(setup.pairing, commitment.X, i+1))
+			tpk.YShares[i].Add(tpk.YShares[i], evalCommitment(setup.pairing, commitment.Y, i+1))
+		}
+	}
+	if tpk.XP.Is0() || tpk.YP.Is0() {
+		return nil, errors.New("apsi: public key contains the identity")
+	}
+	return &ThresholdSigner{pk: tpk, index: index, share: share}, nil
+}
+
+// polynomial is a polynomial over Zr, constant term first.
+type polynomial []*pbc.Element
+
+func randomPolynomial(pairing *pbc.Pairing, constant *pbc.Element, degree int) polynomial {
+	f := make(polynomial, degree+1)
+	f[0] = pairing.NewZr().Set(constant)
+	for k := 1; k <= degree; k++ {
+		f[k] = pairing.NewZr().Rand()
+	}
+	return f
+}
+
+// eval computes f(at) by Horner's rule.
+func (f polynomial) eval(pairing *pbc.Pairing, at int) *pbc.Element {
+	x := zrInt(pairing, at)
+	value := pairing.NewZr().Set0()
+	for k := len(f) - 1; k >= 0; k-- {
+		value.Mul(value, x)
+		value.Add(value, f[k])
+	}
+	return value
+}
+
+func (f polynomial) commit(pairing *pbc.Pairing, P *pbc.Element) []*pbc.Element {
+	commitments := make([]*pbc.Element, len(f))
+	for k, coefficient := range f {
+		commitments[k] = pairing.NewG1().MulZn(P, coefficient)
+	}
+	return commitments
+}
+
+// evalCommitment computes f(at)P from the commitments a_kP to f.
+func evalCommitment(pairing *pbc.Pairing, commitments []*pbc.Element, at int) *pbc.Element {
+	x := zrInt(pairing, at)
+	value := pairing.NewG1().Set0()
+	for k := len(commitments) - 1; k >= 0; k-- {
+		value.MulZn(value, x)
+		value.Add(value, commitments[k])
+	}
+	return value
+}
+
+// lagrangeAtZero returns the coefficients lambda_i that interpolate a
+// polynomial at 0 from its values at indices:
+//
+//	lambda_i = prod_{j != i} j / (j - i)
+func lagrangeAtZero(pairing *pbc.Pairing, indices []int) []*pbc.Element {
+	coefficients := make([]*pbc.Element, len(indices))
+	for k, i := range indices {
+		numerator := pairing.NewZr().Set1()
+		denominator := pairing.NewZr().Set1()
+		for _, j := range indices {
+			if j == i {
+				continue
+			}
+			numerator.Mul(numerator, zrInt(pairing, j))
+			denominator.Mul(denominator, zrInt(pairing, j-i))
+		}
+		coefficients[k] = numerator.Div(numerator, denominator)
+	}
+	return coefficients
+}
+
+func zrInt(pairing *pbc.Pairing, i int) *pbc.Element {
+	return pairing.NewZr().SetInt32(int32(i))
+}
@@ -0,0 +1,128 @@
+package apsi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestThresholdCombine(t *testing.T) {
+	_, authority := NewAuthority()
+	tpk, signers, err := authority.Split(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elt := RawElement{0, 0, 0, 1}
+	partials := make([]PartialAuthorization, len(signers))
+	for i, signer := range signers {
+		if _, partials[i], err = signer.PartialAuthorize(elt, ClientParty); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, want, err := authority.Authorize(elt, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A forged partial, a repeat and an unknown signer are all skipped.
+	forged := PartialAuthorization{Signer: 2, Signature: authority.Pairing().NewG1().Rand()}
+	unknown := PartialAuthorization{Signer: 9, Signature: partials[0].Signature}
+	_, signature, err := tpk.Combine(elt, ClientParty, []PartialAuthorization{partials[0], partials[0], forged, unknown, partials[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signature.Equals(want) {
+		t.Error("combined authorization differs from the authority's")
+	}
+
+	if _, _, err := tpk.Combine(elt, ClientParty, []PartialAuthorization{partials[1], forged}); err != ErrNotEnoughShares {
+		t.Errorf("Combine with one valid partial = %v, want %v", err, ErrNotEnoughShares)
+	}
+	if _, _, err := authority.Split(4, 3); err != ErrThreshold {
+		t.Errorf("Split(4, 3) = %v, want %v", err, ErrThreshold)
+	}
+}
+
+// runDKG deals for every signer of setup and returns the deals along with
+// every share, shares[j-1] holding those dealt to signer j.
+func runDKG(t *testing.T, setup *ThresholdSetup) ([]*DKGCommitment, [][]*DKGShare) {
+	t.Helper()
+	commitments := make([]*DKGCommitment, setup.Signers)
+	shares := make([][]*DKGShare, setup.Signers)
+	for dealer := 1; dealer <= setup.Signers; dealer++ {
+		deal, err := setup.NewDeal(dealer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitments[dealer-1] = deal.Commitment()
+		for recipient := 1; recipient <= setup.Signers; recipient++ {
+			share, err := deal.Share(recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shares[recipient-1] = append(shares[recipient-1], share)
+		}
+	}
+	return commitments, shares
+}
+
+func TestDKG(t *testing.T) {
+	setup, err := NewThresholdSetup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments, shares := runDKG(t, setup)
+	signers := make([]*ThresholdSigner, setup.Signers)
+	for i := range signers {
+		if signers[i], err = setup.FinishDKG(i+1, commitments, shares[i]); err != nil {
+			t.Fatalf("FinishDKG(%d): %v", i+1, err)
+		}
+	}
+	tpk := signers[0].PublicKey()
+	for _, signer := range signers[1:] {
+		other := signer.PublicKey()
+		if !other.XP.Equals(tpk.XP) || !other.YP.Equals(tpk.YP) {
+			t.Errorf("signer %d computed another public key", signer.Index())
+		}
+	}
+
+	elt := RawElement{0, 0, 0, 1}
+	var partials []PartialAuthorization
+	for _, signer := range signers[1:] {
+		_, partial, err := signer.PartialAuthorize(elt, ServerParty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partials = append(partials, partial)
+	}
+	_, signature, err := tpk.Combine(elt, ServerParty, partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Verify(setup.Pairing(), tpk.PublicKey, elt, signature, ServerParty); err != nil || !ok {
+		t.Errorf("combined authorization does not verify: %v, %v", ok, err)
+	}
+}
+
+func TestDKGBadShare(t *testing.T) {
+	setup, err := NewThresholdSetup(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitments, shares := runDKG(t, setup)
+	shares[0][1].X = setup.Pairing().NewZr().Rand()
+	shares[0][2].Y = setup.Pairing().NewZr().Rand()
+
+	_, err = setup.FinishDKG(1, commitments, shares[0])
+	bad, ok := err.(*BadShareError)
+	if !ok {
+		t.Fatalf("FinishDKG = %v, want a *BadShareError", err)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(bad.Dealers, want) {
+		t.Errorf("bad dealers = %v, want %v", bad.Dealers, want)
+	}
+
+	// Leaving the bad dealers out does not help: every dealer is needed.
+	if _, err := setup.FinishDKG(1, commitments, shares[0][:1]); err == nil {
+		t.Error("FinishDKG succeeded without every dealer's share")
+	}
+}
@@ -0,0 +1,453 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Nik-U/pbc"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Threshold files reuse the key file format. A setup file holds the params
+// block and an "APSI THRESHOLD SETUP" block with t, n and P. A threshold
+// public key file is an ordinary public key file with an extra "APSI
+// THRESHOLD KEY" block holding t, n and every x_iP followed by every y_iP,
+// so clients and servers can load it with ReadPublicKey. A signer file adds
+// an "APSI SIGNER SHARE" block (or an "APSI ENCRYPTED SIGNER SHARE" block in
+// the keystore format) with i, x_i and y_i.
+//
+// Key generation exchanges "APSI DKG COMMITMENT" blocks (dealer, then the t
+// commitments for x and the t for y) and "APSI DKG SHARE" blocks (dealer,
+// recipient, then the two share values). Shares that pass through a shared
+// directory are sealed: each signer first publishes an "APSI DKG RECIPIENT"
+// block (index, then a 32-byte X25519 public key), keeps the private half in
+// an "APSI DKG RECIPIENT KEY" block, and dealers write "APSI SEALED DKG
+// SHARE" blocks holding the share block's bytes in a NaCl anonymous box. All
+// counts are 2-byte big-endian integers.
+const (
+	pemThresholdSetup       = "APSI THRESHOLD SETUP"
+	pemThresholdKey         = "APSI THRESHOLD KEY"
+	pemSignerShare          = "APSI SIGNER SHARE"
+	pemEncryptedSignerShare = "APSI ENCRYPTED SIGNER SHARE"
+	pemDKGCommitment        = "APSI DKG COMMITMENT"
+	pemDKGShare             = "APSI DKG SHARE"
+	pemDKGRecipient         = "APSI DKG RECIPIENT"
+	pemDKGRecipientKey      = "APSI DKG RECIPIENT KEY"
+	pemSealedDKGShare       = "APSI SEALED DKG SHARE"
+)
+
+// ErrDKGSealed is returned when a sealed share cannot be opened with the
+// recipient's key, because it was sealed to another key or altered.
+var ErrDKGSealed = errors.New("apsi: DKG share is not sealed to this recipient")
+
+// Write writes the setup for distribution to every signer.
+func (setup *ThresholdSetup) Write(w io.Writer) error {
+	if err := pemEncode(w, pemParams, []byte(setup.params.String())); err != nil {
+		return err
+	}
+	b := appendUint16(nil, uint16(setup.Threshold))
+	b = appendUint16(b, uint16(setup.Signers))
+	return pemEncode(w, pemThresholdSetup, append(b, encodeElements(setup.P)...))
+}
+
+// ReadThresholdSetup reads a file written by ThresholdSetup.Write.
+func ReadThresholdSetup(r io.Reader) (*ThresholdSetup, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	params, pairing, err := decodeParamsBlock(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemThresholdSetup]
+	if !ok {
+		return nil, fmt.Errorf("apsi: setup file has no %s block", pemThresholdSetup)
+	}
+	br := &wireReader{buf: data}
+	threshold, signers := int(br.uint16()), int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if err := checkThreshold(threshold, signers); err != nil {
+		return nil, err
+	}
+	elements, err := decodeElements(br.buf, pairing.NewG1)
+	if err != nil {
+		return nil, err
+	}
+	if elements[0].Is0() {
+		return nil, errors.New("apsi: setup generator is the identity")
+	}
+	return &ThresholdSetup{
+		params:    params,
+		pairing:   pairing,
+		P:         elements[0],
+		Threshold: threshold,
+		Signers:   signers,
+	}, nil
+}
+
+// WritePublicKey writes the threshold public key. The output is a valid
+// public key file for ReadPublicKey.
+func (tpk *ThresholdPublicKey) WritePublicKey(w io.Writer) error {
+	if err := writePublicBlocks(w, tpk.params, tpk.PublicKey); err != nil {
+		return err
+	}
+	b := appendUint16(nil, uint16(tpk.Threshold))
+	b = appendUint16(b, uint16(tpk.Signers()))
+	b = append(b, encodeElements(tpk.XShares...)...)
+	b = append(b, encodeElements(tpk.YShares...)...)
+	return pemEncode(w, pemThresholdKey, b)
+}
+
+// ReadThresholdPublicKey reads a file written by
+// ThresholdPublicKey.WritePublicKey, or the public half of a signer file.
+func ReadThresholdPublicKey(r io.Reader) (*ThresholdPublicKey, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeThresholdKeyBlocks(blocks)
+}
+
+func decodeThresholdKeyBlocks(blocks map[string][]byte) (*ThresholdPublicKey, error) {
+	params, pairing, pk, err := decodePublicBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemThresholdKey]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemThresholdKey)
+	}
+	br := &wireReader{buf: data}
+	threshold, signers := int(br.uint16()), int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if err := checkThreshold(threshold, signers); err != nil {
+		return nil, err
+	}
+	fields := make([]func() *pbc.Element, 2*signers)
+	for i := range fields {
+		fields[i] = pairing.NewG1
+	}
+	elements, err := decodeElements(br.buf, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return &ThresholdPublicKey{
+		params:    params,
+		pairing:   pairing,
+		PublicKey: pk,
+		Threshold: threshold,
+		XShares:   elements[:signers],
+		YShares:   elements[signers:],
+	}, nil
+}
+
+// WriteShare writes the signer's public key file followed by its share in
+// the clear. The output must be stored like an authority key file.
+func (signer *ThresholdSigner) WriteShare(w io.Writer) error {
+	if err := signer.pk.WritePublicKey(w); err != nil {
+		return err
+	}
+	return pemEncode(w, pemSignerShare, signer.encodeShare())
+}
+
+// WriteEncryptedShare is WriteShare with the share encrypted under
+// passphrase, in the same format as WriteEncryptedKeys.
+func (signer *ThresholdSigner) WriteEncryptedShare(w io.Writer, passphrase []byte, kdf KDFParams) error {
+	var public bytes.Buffer
+	if err := signer.pk.WritePublicKey(&public); err != nil {
+		return err
+	}
+	block, err := sealBlock(signer.encodeShare(), public.Bytes(), passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(public.Bytes()); err != nil {
+		return err
+	}
+	return pemEncode(w, pemEncryptedSignerShare, block)
+}
+
+func (signer *ThresholdSigner) encodeShare() []byte {
+	b := appendUint16(nil, uint16(signer.index))
+	return append(b, encodeElements(signer.share.X, signer.share.Y)...)
+}
+
+// ReadThresholdSigner reads a file written by WriteShare. It checks the
+// share against the signer's x_iP and y_iP.
+func ReadThresholdSigner(r io.Reader) (*ThresholdSigner, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	tpk, err := decodeThresholdKeyBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemSignerShare]
+	if _, encrypted := blocks[pemEncryptedSignerShare]; !ok && encrypted {
+		return nil, ErrKeyEncrypted
+	}
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemSignerShare)
+	}
+	return decodeShare(tpk, data)
+}
+
+// ReadEncryptedThresholdSigner reads a file written by WriteEncryptedShare
+// and decrypts the share with passphrase.
+func ReadEncryptedThresholdSigner(r io.Reader, passphrase []byte) (*ThresholdSigner, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	tpk, err := decodeThresholdKeyBlocks(blocks)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := blocks[pemEncryptedSignerShare]
+	if !ok {
+		return nil, fmt.Errorf("apsi: key file has no %s block", pemEncryptedSignerShare)
+	}
+	var public bytes.Buffer
+	if err := tpk.WritePublicKey(&public); err != nil {
+		return nil, err
+	}
+	data, err := openBlock(block, public.Bytes(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return decodeShare(tpk, data)
+}
+
+func decodeShare(tpk *ThresholdPublicKey, data []byte) (*ThresholdSigner, error) {
+	br := &wireReader{buf: data}
+	index := int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if index < 1 || index > tpk.Signers() {
+		return nil, ErrSignerIndex
+	}
+	elements, err := decodeElements(br.buf, tpk.pairing.NewZr, tpk.pairing.NewZr)
+	if err != nil {
+		return nil, err
+	}
+	share := SecretKey{X: elements[0], Y: elements[1]}
+	sharePK := PublicKey{P: tpk.P, XP: tpk.XShares[index-1], YP: tpk.YShares[index-1]}
+	if err := checkKeyPair(tpk.pairing, sharePK, share); err != nil {
+		return nil, err
+	}
+	return &ThresholdSigner{pk: tpk, index: index, share: share}, nil
+}
+
+// Write writes the commitment for publication to every signer.
+func (commitment *DKGCommitment) Write(w io.Writer) error {
+	b := appendUint16(nil, uint16(commitment.Dealer))
+	b = append(b, encodeElements(commitment.X...)...)
+	b = append(b, encodeElements(commitment.Y...)...)
+	return pemEncode(w, pemDKGCommitment, b)
+}
+
+// ReadDKGCommitment reads a file written by DKGCommitment.Write.
+func (setup *ThresholdSetup) ReadDKGCommitment(r io.Reader) (*DKGCommitment, error) {
+	data, err := readSingleBlock(r, pemDKGCommitment)
+	if err != nil {
+		return nil, err
+	}
+	br := &wireReader{buf: data}
+	dealer := int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if err := setup.checkIndex(dealer); err != nil {
+		return nil, err
+	}
+	fields := make([]func() *pbc.Element, 2*setup.Threshold)
+	for i := range fields {
+		fields[i] = setup.pairing.NewG1
+	}
+	elements, err := decodeElements(br.buf, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return &DKGCommitment{
+		Dealer: dealer,
+		X:      elements[:setup.Threshold],
+		Y:      elements[setup.Threshold:],
+	}, nil
+}
+
+// Write writes the share for delivery to its recipient in the clear. Use
+// WriteSealed when the share passes through anyone else's hands.
+func (share *DKGShare) Write(w io.Writer) error {
+	return pemEncode(w, pemDKGShare, share.encode())
+}
+
+// WriteSealed writes the share sealed to recipient's key, which must be
+// the key of the share's recipient. Only the holder of the private half
+// can open it.
+func (share *DKGShare) WriteSealed(w io.Writer, recipient *DKGRecipient) error {
+	if share.Recipient != recipient.Index {
+		return ErrSignerIndex
+	}
+	sealed, err := box.SealAnonymous(nil, share.encode(), &recipient.public, rand.Reader)
+	if err != nil {
+		return err
+	}
+	return pemEncode(w, pemSealedDKGShare, sealed)
+}
+
+func (share *DKGShare) encode() []byte {
+	b := appendUint16(nil, uint16(share.Dealer))
+	b = appendUint16(b, uint16(share.Recipient))
+	return append(b, encodeElements(share.X, share.Y)...)
+}
+
+// ReadDKGShare reads a file written by DKGShare.Write.
+func (setup *ThresholdSetup) ReadDKGShare(r io.Reader) (*DKGShare, error) {
+	data, err := readSingleBlock(r, pemDKGShare)
+	if err != nil {
+		return nil, err
+	}
+	return setup.decodeDKGShare(data)
+}
+
+// ReadSealedDKGShare reads a file written by DKGShare.WriteSealed and opens
+// it with recipient's private key.
+func (setup *ThresholdSetup) ReadSealedDKGShare(r io.Reader, recipient *DKGRecipient) (*DKGShare, error) {
+	if recipient.private == nil {
+		return nil, errors.New("apsi: recipient has no private key")
+	}
+	sealed, err := readSingleBlock(r, pemSealedDKGShare)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := box.OpenAnonymous(nil, sealed, &recipient.public, recipient.private)
+	if !ok {
+		return nil, ErrDKGSealed
+	}
+	share, err := setup.decodeDKGShare(data)
+	if err != nil {
+		return nil, err
+	}
+	if share.Recipient != recipient.Index {
+		return nil, ErrSignerIndex
+	}
+	return share, nil
+}
+
+func (setup *ThresholdSetup) decodeDKGShare(data []byte) (*DKGShare, error) {
+	br := &wireReader{buf: data}
+	dealer, recipient := int(br.uint16()), int(br.uint16())
+	if br.err != nil {
+		return nil, br.err
+	}
+	if setup.checkIndex(dealer) != nil || setup.checkIndex(recipient) != nil {
+		return nil, ErrSignerIndex
+	}
+	elements, err := decodeElements(br.buf, setup.pairing.NewZr, setup.pairing.NewZr)
+	if err != nil {
+		return nil, err
+	}
+	return &DKGShare{Dealer: dealer, Recipient: recipient, X: elements[0], Y: elements[1]}, nil
+}
+
+// DKGRecipient is the key a signer receives its dealt shares under, so
+// that they can pass through a directory every signer reads. The private
+// half stays with the signer and is only needed until FinishDKG.
+type DKGRecipient struct {
+	Index int
+
+	public  [32]byte
+	private *[32]byte
+}
+
+// NewDKGRecipient generates signer index's key for receiving shares.
+func (setup *ThresholdSetup) NewDKGRecipient(index int) (*DKGRecipient, error) {
+	if err := setup.checkIndex(index); err != nil {
+		return nil, err
+	}
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &DKGRecipient{Index: index, public: *public, private: private}, nil
+}
+
+// Write writes the public half for publication to the dealers.
+func (recipient *DKGRecipient) Write(w io.Writer) error {
+	b := appendUint16(nil, uint16(recipient.Index))
+	return pemEncode(w, pemDKGRecipient, append(b, recipient.public[:]...))
+}
+
+// WriteKey writes the public half followed by the private half in the
+// clear. The output must stay with the signer.
+func (recipient *DKGRecipient) WriteKey(w io.Writer) error {
+	if recipient.private == nil {
+		return errors.New("apsi: recipient has no private key")
+	}
+	if err := recipient.Write(w); err != nil {
+		return err
+	}
+	return pemEncode(w, pemDKGRecipientKey, recipient.private[:])
+}
+
+// ReadDKGRecipient reads a file written by DKGRecipient.Write or WriteKey.
+// The private half, when present, is checked against the public half.
+func (setup *ThresholdSetup) ReadDKGRecipient(r io.Reader) (*DKGRecipient, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[pemDKGRecipient]
+	if !ok {
+		return nil, fmt.Errorf("apsi: file has no %s block", pemDKGRecipient)
+	}
+	br := &wireReader{buf: data}
+	recipient := &DKGRecipient{Index: int(br.uint16())}
+	copy(recipient.public[:], br.next(len(recipient.public)))
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+	if err := setup.checkIndex(recipient.Index); err != nil {
+		return nil, err
+	}
+
+	data, ok = blocks[pemDKGRecipientKey]
+	if !ok {
+		return recipient, nil
+	}
+	if len(data) != len(recipient.public) {
+		return nil, ErrMalformedMessage
+	}
+	var private, public [32]byte
+	copy(private[:], data)
+	curve25519.ScalarBaseMult(&public, &private)
+	if subtle.ConstantTimeCompare(public[:], recipient.public[:]) != 1 {
+		return nil, errors.New("apsi: recipient key does not match its public key")
+	}
+	recipient.private = &private
+	return recipient, nil
+}
+
+func readSingleBlock(r io.Reader, blockType string) ([]byte, error) {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := blocks[blockType]
+	if !ok {
+		return nil, fmt.Errorf("apsi: file has no %s block", blockType)
+	}
+	return data, nil
+}
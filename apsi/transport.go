@@ -0,0 +1,131 @@
+package apsi
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Transport opens the connections a Client and a Server talk over. The
+// server side calls Listen and the client side calls Dial.
+type Transport interface {
+	Listen() (net.Listener, error)
+	Dial() (net.Conn, error)
+}
+
+// NewTransport returns the transport for a network name as accepted by the
+// apsi command: "tcp" with a host:port address or "unix" with a socket path.
+func NewTransport(network string, addr string) (Transport, error) {
+	switch network {
+	case "tcp":
+		return TCPTransport{Addr: addr}, nil
+	case "unix":
+		return UnixTransport{Path: addr}, nil
+	}
+	return nil, fmt.Errorf("apsi: unknown network %q", network)
+}
+
+// TCPTransport connects the parties over TCP.
+type TCPTransport struct {
+	Addr string
+}
+
+func (t TCPTransport) Listen() (net.Listener, error) {
+	return net.Listen("tcp", t.Addr)
+}
+
+func (t TCPTransport) Dial() (net.Conn, error) {
+	return net.Dial("tcp", t.Addr)
+}
+
+// TLSTransport connects over TCP with TLS. The signing service needs both
+// sides to present certificates, so Config should hold a certificate on
+// both sides, the CA to verify the peer against and, on the listening
+// side, ClientAuth set to tls.RequireAndVerifyClientCert.
+type TLSTransport struct {
+	Addr   string
+	Config *tls.Config
+}
+
+func (t TLSTransport) Listen() (net.Listener, error) {
+	return tls.Listen("tcp", t.Addr, t.Config)
+}
+
+func (t TLSTransport) Dial() (net.Conn, error) {
+	return tls.Dial("tcp", t.Addr, t.Config)
+}
+
+// UnixTransport connects the parties over a Unix domain socket.
+type UnixTransport struct {
+	Path string
+}
+
+func (t UnixTransport) Listen() (net.Listener, error) {
+	return net.Listen("unix", t.Path)
+}
+
+func (t UnixTransport) Dial() (net.Conn, error) {
+	return net.Dial("unix", t.Path)
+}
+
+var errPipeClosed = errors.New("apsi: pipe transport closed")
+
+// PipeTransport connects the parties in memory with net.Pipe. It is meant
+// for running both parties in one process without touching the network.
+type PipeTransport struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewPipeTransport returns a PipeTransport. Dial blocks until the listener
+// accepts the connection.
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (t *PipeTransport) Listen() (net.Listener, error) {
+	return pipeListener{t}, nil
+}
+
+func (t *PipeTransport) Dial() (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	select {
+	case t.conns <- serverConn:
+		return clientConn, nil
+	case <-t.done:
+		return nil, errPipeClosed
+	}
+}
+
+type pipeListener struct {
+	transport *PipeTransport
+}
+
+func (l pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.transport.conns:
+		return conn, nil
+	case <-l.transport.done:
+		return nil, errPipeClosed
+	}
+}
+
+func (l pipeListener) Close() error {
+	l.transport.once.Do(func() { close(l.transport.done) })
+	return nil
+}
+
+func (l pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,370 @@
+package apsi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Verify checks that sig is the authority's authorization of elt for party,
+// that is e(sig, P) == e(H(elt), xP) for the client and the same with yP for
+// the server. opts must match the ones the signature was issued with.
+func Verify(pairing *pbc.Pairing, pk PublicKey, elt RawElement, sig *pbc.Element, party Party, opts ...AuthorizeOption) (bool, error) {
+	partyKey, err := pk.forParty(party)
+	if err != nil {
+		return false, err
+	}
+	if sig == nil {
+		return false, nil
+	}
+
+	e_sig_P := pairing.NewGT().Pair(sig, pk.P)
+	e_H_xP := pairing.NewGT().Pair(newBinding(opts).hash(pairing, elt), partyKey)
+	return e_sig_P.Equals(e_H_xP), nil
+}
+
+// VerifySet verifies every signature in signatures against the matching
+// element of set one at a time and returns the indices of the ones that
+// fail. BatchVerify gives the same answer with far fewer pairings.
+func VerifySet(pairing *pbc.Pairing, pk PublicKey, set RawElementSlice, signatures []*pbc.Element, party Party, opts ...AuthorizeOption) ([]int, error) {
+	if len(set) != len(signatures) {
+		return nil, ErrSignatureCount
+	}
+	var invalid []int
+	for i, elt := range set {
+		ok, err := Verify(pairing, pk, elt, signatures[i], party, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			invalid = append(invalid, i)
+		}
+	}
+	return invalid, nil
+}
+
+// Verify is Verify under the scheme's public key.
+func (scheme *DualAPSIScheme) Verify(elt RawElement, sig *pbc.Element, party Party, opts ...AuthorizeOption) (bool, error) {
+	return Verify(scheme.pairing, scheme.pk, elt, sig, party, opts...)
+}
+
+// InvalidSignatureError lists the signatures that failed verification
+// before an interaction, by index into the client's and server's sets.
+type InvalidSignatureError struct {
+	ClientIndices []int
+	ServerIndices []int
+}
+
+func (e *InvalidSignatureError) Error() string {
+	return fmt.Sprintf("apsi: invalid signatures: client %v, server %v", e.ClientIndices, e.ServerIndices)
+}
+
+// invalidSignatures reports the invalid signatures of one party's set.
+func invalidSignatures(party Party, invalid []int) *InvalidSignatureError {
+	if party == ServerParty {
+		return &InvalidSignatureError{ServerIndices: invalid}
+	}
+	return &InvalidSignatureError{ClientIndices: invalid}
+}
+
+// VerifyMode selects what an interaction does with signatures that fail
+// verification.
+type VerifyMode int
+
+const (
+	// VerifyNone uses signatures as given, which is the cheapest and what
+	// the benchmarks measure.
+	VerifyNone VerifyMode = iota
+
+	// VerifyReject fails the interaction with an *InvalidSignatureError if
+	// any signature is invalid.
+	VerifyReject
+
+	// VerifyDrop leaves elements with invalid signatures out of the
+	// interaction, as if they were not in the set.
+	VerifyDrop
+)
+
+// InteractionOption configures one call to an *Interaction method, or a
+// Client or Server for all of its sessions.
+type InteractionOption func(*interactionConfig)
+
+type interactionConfig struct {
+	verify     VerifyMode
+	epoch      Epoch
+	period     time.Duration
+	context    ContextDigest
+	revocation *RevocationList
+	keyID      KeyID
+	clientID   ClientID
+	registries map[KeyID]*ClientRegistry
+
+	delegations []*DelegationChain
+	blindKeys   []*BlindKey
+
+	audit *AuditLog
+}
+
+// WithVerification verifies both parties' signatures before the
+// interaction and handles invalid ones according to mode.
+func WithVerification(mode VerifyMode) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.verify = mode
+	}
+}
+
+// WithEpoch runs the interaction in epoch. Only authorizations issued
+// AtEpoch(epoch) take part; unless WithVerification asks for something
+// else, signatures from any other epoch are dropped, so stale
+// authorizations produce no matches.
+func WithEpoch(epoch Epoch) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.epoch = epoch
+	}
+}
+
+// WithEpochPeriod says that the epoch given to WithEpoch is one of
+// EpochOf's periods of the given length. A Server then checks the clock at
+// every session and refuses clients with ErrEpochOver once the epoch has
+// ended, so a server that keeps running does not outlive its
+// authorizations.
+func WithEpochPeriod(period time.Duration) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.period = period
+	}
+}
+
+// WithRevocationList leaves authorizations revoked in list out of the
+// interaction, as if their elements were not in the set. A Client also
+// announces the list's version, and a Server refuses clients whose list is
+// older than its own. The list must be signed under the key the Client or
+// Server is created with, and applies to every key version it holds.
+func WithRevocationList(list *RevocationList) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.revocation = list
+	}
+}
+
+// WithKeyVersion runs a *Interaction method under version id of the
+// scheme's keys instead of the current one. Both sets must be authorized
+// under that version, and it must not have been retired.
+func WithKeyVersion(id KeyID) InteractionOption {
+	return func(cfg *interactionConfig) {
+		cfg.keyID = id
+	}
+}
+
+func newInteractionConfig(opts []InteractionOption) *interactionConfig {
+	cfg := &interactionConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	bound := cfg.epoch != NoEpoch || cfg.context != (ContextDigest{}) || len(cfg.delegations) > 0
+	if bound && cfg.verify == VerifyNone {
+		cfg.verify = VerifyDrop
+	}
+	return cfg
+}
+
+func (cfg *interactionConfig) authorizeOptions() []AuthorizeOption {
+	var opts []AuthorizeOption
+	if cfg.epoch != NoEpoch {
+		opts = append(opts, AtEpoch(cfg.epoch))
+	}
+	if cfg.context != (ContextDigest{}) {
+		opts = append(opts, inContextDigest(cfg.context))
+	}
+	return opts
+}
+
+// check verifies one party's signatures as cfg asks and returns the indices
+// of the invalid ones.
+func (cfg *interactionConfig) check(pairing *pbc.Pairing, pk PublicKey, set RawElementSlice, signatures []*pbc.Element, party Party) ([]int, error) {
+	if cfg.verify == VerifyNone {
+		return nil, nil
+	}
+	return BatchVerify(pairing, pk, set, signatures, party, cfg.authorizeOptions()...)
+}
+
+// dropIndices returns set and signatures without the entries at the sorted
+// indices in drop.
+func dropIndices(set RawElementSlice, signatures []*pbc.Element, drop []int) (RawElementSlice, []*pbc.Element) {
+	if len(drop) == 0 {
+		return set, signatures
+	}
+	keptSet := make(RawElementSlice, 0, len(set)-len(drop))
+	keptSignatures := make([]*pbc.Element, 0, len(set)-len(drop))
+	for i := range set {
+		if len(drop) > 0 && drop[0] == i {
+			drop = drop[1:]
+			continue
+		}
+		keptSet = append(keptSet, set[i])
+		keptSignatures = append(keptSignatures, signatures[i])
+	}
+	return keptSet, keptSignatures
+}
+
+// revoked returns the indices of the signatures cfg's revocation list
+// revokes.
+func (cfg *interactionConfig) revoked(signatures []*pbc.Element, party Party) []int {
+	if cfg.revocation == nil {
+		return nil
+	}
+	return cfg.revocation.filter(signatures, party)
+}
+
+func (cfg *interactionConfig) revocationVersion() uint64 {
+	if cfg.revocation == nil {
+		return 0
+	}
+	return cfg.revocation.Version
+}
+
+func (cfg *interactionConfig) checkRevocationList(pairing *pbc.Pairing, pk PublicKey) error {
+	if cfg.revocation != nil && !cfg.revocation.Verify(pairing, pk) {
+		return ErrRevocationSignature
+	}
+	return nil
+}
+
+// checkRegistered is the server's check of the client in an interaction
+// run in one process: the registry for pk's version must be signed and list
+// the client under pk's xP.
+func (cfg *interactionConfig) checkRegistered(pairing *pbc.Pairing, pk PublicKey) error {
+	registry := cfg.registries[pk.ID()]
+	if registry == nil || cfg.clientID == "" {
+		return ErrUnknownClient
+	}
+	if !registry.Verify(pairing, pk) {
+		return ErrRegistrySignature
+	}
+	xP, err := registry.Lookup(cfg.clientID)
+	if err != nil {
+		return err
+	}
+	if !xP.Equals(pk.XP) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// mergeIndices returns the sorted union of two sorted index lists.
+func mergeIndices(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	merged := make([]int, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			merged, a = append(merged, a[0]), a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			merged, b = append(merged, b[0]), b[1:]
+		default:
+			merged, a, b = append(merged, a[0]), a[1:], b[1:]
+		}
+	}
+	return merged
+}
+
+// prepareParty is prepare for one side of a session run over a network,
+// except that the caller checks the revocation list. It returns the key
+// the party runs under, which differs from pk under a delegation or a
+// blind key.
+func (cfg *interactionConfig) prepareParty(pairing *pbc.Pairing, pk PublicKey,
+	set RawElementSlice, signatures []*pbc.Element, party Party) (PublicKey, RawElementSlice, []*pbc.Element, error) {
+
+	if len(set) != len(signatures) {
+		return PublicKey{}, nil, nil, ErrSignatureCount
+	}
+	delegated, err := cfg.delegatedKey(pairing, pk)
+	if err != nil {
+		return PublicKey{}, nil, nil, err
+	}
+	if party == ClientParty {
+		if delegated, err = cfg.blindKey(pairing, delegated); err != nil {
+			return PublicKey{}, nil, nil, err
+		}
+	}
+	invalid, err := cfg.check(pairing, delegated, set, signatures, party)
+	if err != nil {
+		return PublicKey{}, nil, nil, err
+	}
+	invalid = mergeIndices(invalid, cfg.outOfScope(pk.ID(), set, party))
+	if len(invalid) > 0 && cfg.verify == VerifyReject {
+		if party == ClientParty {
+			return PublicKey{}, nil, nil, &InvalidSignatureError{ClientIndices: invalid}
+		}
+		return PublicKey{}, nil, nil, &InvalidSignatureError{ServerIndices: invalid}
+	}
+	set, signatures = dropIndices(set, signatures, mergeIndices(invalid, cfg.revoked(signatures, party)))
+	return delegated, set, signatures, nil
+}
+
+// prepare checks the inputs of an interaction and applies cfg to them,
+// returning the public key and the sets and signatures the interaction
+// should run on.
+func (scheme *DualAPSIScheme) prepare(cfg *interactionConfig,
+	clientSet RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet RawElementSlice, serverSignatures []*pbc.Element) (
+	PublicKey, RawElementSlice, []*pbc.Element, RawElementSlice, []*pbc.Element, error) {
+
+	if err := checkSets(clientSet, clientSignatures, serverSet, serverSignatures); err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	authority := scheme.authority
+	if cfg.keyID != (KeyID{}) {
+		if _, err := scheme.keyring.Lookup(cfg.keyID, time.Now()); err != nil {
+			return PublicKey{}, nil, nil, nil, nil, err
+		}
+		authority = scheme.authorities[cfg.keyID]
+	}
+	pk := authority.pk
+	if cfg.clientID != "" {
+		client, err := authority.ForClient(cfg.clientID)
+		if err != nil {
+			return PublicKey{}, nil, nil, nil, nil, err
+		}
+		pk = client.pk
+	}
+	if cfg.registries != nil {
+		if err := cfg.checkRegistered(scheme.pairing, pk); err != nil {
+			return PublicKey{}, nil, nil, nil, nil, err
+		}
+	}
+	if err := cfg.checkRevocationList(scheme.pairing, pk); err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	root := pk.ID()
+	pk, err := cfg.delegatedKey(scheme.pairing, pk)
+	if err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	if pk, err = cfg.blindKey(scheme.pairing, pk); err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	invalidClient, err := cfg.check(scheme.pairing, pk, clientSet, clientSignatures, ClientParty)
+	if err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	invalidServer, err := cfg.check(scheme.pairing, pk, serverSet, serverSignatures, ServerParty)
+	if err != nil {
+		return PublicKey{}, nil, nil, nil, nil, err
+	}
+	invalidClient = mergeIndices(invalidClient, cfg.outOfScope(root, clientSet, ClientParty))
+	invalidServer = mergeIndices(invalidServer, cfg.outOfScope(root, serverSet, ServerParty))
+	if cfg.verify == VerifyReject && (len(invalidClient) > 0 || len(invalidServer) > 0) {
+		return PublicKey{}, nil, nil, nil, nil, &InvalidSignatureError{invalidClient, invalidServer}
+	}
+
+	dropClient := mergeIndices(invalidClient, cfg.revoked(clientSignatures, ClientParty))
+	dropServer := mergeIndices(invalidServer, cfg.revoked(serverSignatures, ServerParty))
+	clientSet, clientSignatures = dropIndices(clientSet, clientSignatures, dropClient)
+	serverSet, serverSignatures = dropIndices(serverSet, serverSignatures, dropServer)
+	return pk, clientSet, clientSignatures, serverSet, serverSignatures, nil
+}
@@ -0,0 +1,273 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A wallet is one party's store of the authorization certificates it has
+// been issued, kept by element so that authorizations collected over many
+// issuances can be looked up for whatever set a session needs. An element
+// may hold one certificate per epoch and context it was authorized for.
+//
+// Certificates are verified when they are added. Reading a wallet back
+// only checks that each certificate opens to its element and is under the
+// wallet's key; the pairings are left to the interaction, which verifies
+// the signatures it is given anyway.
+
+// ErrWalletFormat is returned for a wallet file that cannot be decoded.
+var ErrWalletFormat = errors.New("apsi: malformed wallet")
+
+// Wallet holds one party's authorization certificates under one key.
+type Wallet struct {
+	pairing *pbc.Pairing
+	pk      PublicKey
+	party   Party
+	entries map[RawElement][]*AuthorizationCertificate
+}
+
+// NewWallet returns an empty wallet for party's certificates under pk.
+func NewWallet(pairing *pbc.Pairing, pk PublicKey, party Party) (*Wallet, error) {
+	if party != ClientParty && party != ServerParty {
+		return nil, ErrUnknownParty
+	}
+	return &Wallet{
+		pairing: pairing,
+		pk:      pk,
+		party:   party,
+		entries: make(map[RawElement][]*AuthorizationCertificate),
+	}, nil
+}
+
+// Pairing returns the pairing the wallet's certificates are in.
+func (wallet *Wallet) Pairing() *pbc.Pairing {
+	return wallet.pairing
+}
+
+// Party returns the party the wallet holds certificates for.
+func (wallet *Wallet) Party() Party {
+	return wallet.party
+}
+
+// Len returns the number of elements with at least one certificate.
+func (wallet *Wallet) Len() int {
+	return len(wallet.entries)
+}
+
+// Elements returns the elements with at least one certificate, sorted.
+func (wallet *Wallet) Elements() RawElementSlice {
+	set := make(RawElementSlice, 0, len(wallet.entries))
+	for elt := range wallet.entries {
+		set = append(set, elt)
+	}
+	sort.Sort(set)
+	return set
+}
+
+// Certificates returns elt's certificates.
+func (wallet *Wallet) Certificates(elt RawElement) []*AuthorizationCertificate {
+	return append([]*AuthorizationCertificate(nil), wallet.entries[elt]...)
+}
+
+// Add verifies cert as an authorization of elt at now and stores it,
+// replacing any certificate elt already has for the same epoch and context.
+func (wallet *Wallet) Add(elt RawElement, cert *AuthorizationCertificate, now time.Time) error {
+	if cert.Party != wallet.party {
+		return ErrWrongParty
+	}
+	if err := cert.Verify(wallet.pairing, wallet.pk, elt, now); err != nil {
+		return err
+	}
+	wallet.put(elt, cert)
+	return nil
+}
+
+func (wallet *Wallet) put(elt RawElement, cert *AuthorizationCertificate) {
+	certs := wallet.entries[elt]
+	for i, held := range certs {
+		if held.Epoch == cert.Epoch && held.Context == cert.Context {
+			certs[i] = cert
+			return
+		}
+	}
+	wallet.entries[elt] = append(certs, cert)
+}
+
+// Remove drops every certificate elt has.
+func (wallet *Wallet) Remove(elt RawElement) {
+	delete(wallet.entries, elt)
+}
+
+// Prune drops the certificates that have expired at now and returns how
+// many it dropped.
+func (wallet *Wallet) Prune(now time.Time) int {
+	pruned := 0
+	for elt, certs := range wallet.entries {
+		kept := certs[:0]
+		for _, cert := range certs {
+			if cert.expiredAt(now) {
+				pruned++
+			} else {
+				kept = append(kept, cert)
+			}
+		}
+		if len(kept) == 0 {
+			delete(wallet.entries, elt)
+		} else {
+			wallet.entries[elt] = kept
+		}
+	}
+	return pruned
+}
+
+func (cert *AuthorizationCertificate) expiredAt(now time.Time) bool {
+	return !cert.Expires.IsZero() && !now.Before(cert.Expires)
+}
+
+// WalletSet is the result of looking a set up in a wallet: the elements
+// that have a current authorization for the binding asked for, with their
+// signatures, and the elements that do not.
+type WalletSet struct {
+	Set        RawElementSlice
+	Signatures []*pbc.Element
+
+	// Missing holds the elements with no certificate for the binding, and
+	// Expired those whose certificate for it has expired.
+	Missing RawElementSlice
+	Expired RawElementSlice
+
+	binding binding
+}
+
+// Complete reports whether every element looked up had a current
+// authorization.
+func (ws *WalletSet) Complete() bool {
+	return len(ws.Missing) == 0 && len(ws.Expired) == 0
+}
+
+// InteractionOptions returns the options that run an interaction in the
+// epoch and context the set was looked up for.
+func (ws *WalletSet) InteractionOptions() []InteractionOption {
+	var opts []InteractionOption
+	if ws.binding.epoch != NoEpoch {
+		opts = append(opts, WithEpoch(ws.binding.epoch))
+	}
+	if ws.binding.context != (ContextDigest{}) {
+		opts = append(opts, withContextDigest(ws.binding.context))
+	}
+	return opts
+}
+
+// Lookup finds the authorizations of set bound as opts say that are
+// current at now. The result's Set and Signatures go straight into
+// NewClient, NewServer or an Interaction along with its
+// InteractionOptions.
+func (wallet *Wallet) Lookup(set RawElementSlice, now time.Time, opts ...AuthorizeOption) *WalletSet {
+	ws := &WalletSet{binding: newBinding(opts)}
+	for _, elt := range set {
+		var found *AuthorizationCertificate
+		for _, cert := range wallet.entries[elt] {
+			if cert.Epoch == ws.binding.epoch && cert.Context.Digest() == ws.binding.context {
+				found = cert
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			ws.Missing = append(ws.Missing, elt)
+		case found.expiredAt(now):
+			ws.Expired = append(ws.Expired, elt)
+		default:
+			ws.Set = append(ws.Set, elt)
+			ws.Signatures = append(ws.Signatures, found.Signature)
+		}
+	}
+	return ws
+}
+
+// A wallet file is an "APSI WALLET" block holding
+//
+//	party (1) | key ID (8)
+//
+// followed by one "APSI WALLET ENTRY" block per certificate holding the
+// element followed by the certificate's MarshalBinary encoding.
+const (
+	pemWallet      = "APSI WALLET"
+	pemWalletEntry = "APSI WALLET ENTRY"
+)
+
+// Write writes the wallet, elements in order.
+func (wallet *Wallet) Write(w io.Writer) error {
+	id := wallet.pk.ID()
+	header := append([]byte{byte(wallet.party)}, id[:]...)
+	if err := pemEncode(w, pemWallet, header); err != nil {
+		return err
+	}
+	for _, elt := range wallet.Elements() {
+		certs := wallet.entries[elt]
+		sort.SliceStable(certs, func(a, b int) bool {
+			return certs[a].Issued.Before(certs[b].Issued)
+		})
+		for _, cert := range certs {
+			data, err := cert.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := pemEncode(w, pemWalletEntry, append(append([]byte(nil), elt[:]...), data...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadWallet reads a wallet written by Write for certificates under pk.
+func ReadWallet(r io.Reader, pairing *pbc.Pairing, pk PublicKey) (*Wallet, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	block, data := pem.Decode(data)
+	if block == nil || block.Type != pemWallet || len(block.Bytes) != 1+len(KeyID{}) {
+		return nil, ErrWalletFormat
+	}
+	wallet, err := NewWallet(pairing, pk, Party(block.Bytes[0]))
+	if err != nil {
+		return nil, err
+	}
+	id := pk.ID()
+	if !bytes.Equal(block.Bytes[1:], id[:]) {
+		return nil, ErrUnknownKey
+	}
+
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != pemWalletEntry || len(block.Bytes) < len(RawElement{}) {
+			return nil, ErrWalletFormat
+		}
+		var elt RawElement
+		copy(elt[:], block.Bytes)
+		cert, err := UnmarshalAuthorizationCertificate(pairing, block.Bytes[len(elt):])
+		if err != nil {
+			return nil, err
+		}
+		if cert.Party != wallet.party || !cert.Opens(elt) {
+			return nil, ErrWalletFormat
+		}
+		if cert.KeyID != id {
+			return nil, ErrUnknownKey
+		}
+		wallet.put(elt, cert)
+	}
+	return wallet, nil
+}
@@ -0,0 +1,93 @@
+package apsi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWalletLookup(t *testing.T) {
+	_, authority := NewAuthority()
+	pairing, pk := authority.Pairing(), authority.PublicKey()
+	wallet, err := NewWallet(pairing, pk, ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a, b, c := RawElement{0, 0, 0, 1}, RawElement{0, 0, 0, 2}, RawElement{0, 0, 0, 3}
+	inCase := CertificateInfo{Epoch: 1, Context: Context{Case: "17"}}
+	grants := []struct {
+		elt  RawElement
+		info CertificateInfo
+	}{
+		{a, CertificateInfo{Epoch: 1}},
+		{a, inCase},
+		{b, inCase},
+		{c, CertificateInfo{Epoch: 1, Context: inCase.Context, Expires: now.Add(time.Minute)}},
+		{c, CertificateInfo{Epoch: 2}},
+	}
+	certs := make([]*AuthorizationCertificate, len(grants))
+	for i, grant := range grants {
+		if _, certs[i], err = authority.IssueCertificate(grant.elt, ClientParty, grant.info); err != nil {
+			t.Fatal(err)
+		}
+		if err := wallet.Add(grant.elt, certs[i], now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var written bytes.Buffer
+	if err := wallet.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+	if wallet, err = ReadWallet(&written, pairing, pk); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Len() != 3 || len(wallet.Certificates(a)) != 2 {
+		t.Fatalf("wallet read back holds %d elements, %d certificates for a", wallet.Len(), len(wallet.Certificates(a)))
+	}
+
+	set := RawElementSlice{a, b, c, {0, 0, 0, 4}}
+	ws := wallet.Lookup(set, now.Add(2*time.Minute), AtEpoch(1), InContext(inCase.Context))
+	if want := (RawElementSlice{a, b}); !reflect.DeepEqual(ws.Set, want) {
+		t.Errorf("Set = %x, want %x", ws.Set, want)
+	}
+	if len(ws.Signatures) != 2 || !ws.Signatures[0].Equals(certs[1].Signature) || !ws.Signatures[1].Equals(certs[2].Signature) {
+		t.Error("Lookup returned the signatures of other certificates")
+	}
+	if want := (RawElementSlice{c}); !reflect.DeepEqual(ws.Expired, want) {
+		t.Errorf("Expired = %x, want %x", ws.Expired, want)
+	}
+	if want := (RawElementSlice{{0, 0, 0, 4}}); !reflect.DeepEqual(ws.Missing, want) || ws.Complete() {
+		t.Errorf("Missing = %x, want %x", ws.Missing, want)
+	}
+
+	ws = wallet.Lookup(set[:3], now, AtEpoch(2))
+	if want := (RawElementSlice{a, b}); !reflect.DeepEqual(ws.Missing, want) || len(ws.Set) != 1 || ws.Set[0] != c {
+		t.Errorf("Lookup for epoch 2 = %x, missing %x", ws.Set, ws.Missing)
+	}
+
+	if pruned := wallet.Prune(now.Add(2 * time.Minute)); pruned != 1 {
+		t.Errorf("Prune dropped %d, want 1", pruned)
+	}
+	if len(wallet.Certificates(c)) != 1 {
+		t.Errorf("c holds %d certificates after pruning, want 1", len(wallet.Certificates(c)))
+	}
+}
+
+func TestWalletRefusesOtherParty(t *testing.T) {
+	_, authority := NewAuthority()
+	wallet, err := NewWallet(authority.Pairing(), authority.PublicKey(), ClientParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elt := RawElement{0, 0, 0, 1}
+	_, cert, err := authority.IssueCertificate(elt, ServerParty, CertificateInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallet.Add(elt, cert, time.Now()); err != ErrWrongParty {
+		t.Errorf("Add of a server certificate = %v, want %v", err, ErrWrongParty)
+	}
+}
@@ -0,0 +1,671 @@
+package apsi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+// Every protocol message travels in a frame:
+//
+//	magic "AP" (2) | version (1) | type (1) | payload length (4) | payload
+//
+// All integers are big endian. Until the Hello/HelloAck exchange has agreed
+// on a protocol version, frames carry version 0 and only Hello, HelloAck and
+// Error may be sent. Afterwards every frame must carry the agreed version.
+//
+// The version changes whenever a payload encoding does, so that peers that
+// would misparse each other fail the handshake instead:
+//
+//   - Version 1 has Blinding messages without extensions.
+//   - Version 2 adds the Blinding extensions for epochs, revocation lists,
+//     key versions, client keys and contexts, and the Issue and Issued
+//     messages of the signing service.
+const (
+	// MinProtocolVersion and MaxProtocolVersion bound the protocol versions
+	// this release speaks.
+	MinProtocolVersion = 2
+	MaxProtocolVersion = 2
+
+	// MaxTags is the largest tag set a client accepts, which bounds the
+	// size of the server's set.
+	MaxTags = 1 << 21
+
+	// MaxFrameSize is the largest payload either side reads, that of a
+	// TagSet message. The other message types have tighter limits.
+	MaxFrameSize = 8 + MaxTags*sha256.Size
+
+	// DefaultFrameTimeout bounds how long a Server or SigningService waits
+	// to read or write one frame when it sets no Timeout of its own.
+	DefaultFrameTimeout = 30 * time.Second
+
+	frameHeaderSize = 8
+	maxErrorLength  = 1024
+
+	// maxElementLength bounds the encoding of one group element, far above
+	// what any pairing in use produces.
+	maxElementLength = 512
+
+	// A Blinding message is a point and at most one extension of each
+	// known type, each of which holds at most 0xffff bytes.
+	maxBlindingSize = 2 + maxElementLength + 2 + 6*(4+0xffff)
+
+	// An Issue message is the context, the elements and the justification;
+	// an Issued message is one signature per element.
+	maxIssueSize  = 1<<20 + MaxIssueElements*uint32(len(RawElement{}))
+	maxIssuedSize = 12 + MaxIssueElements*(2+maxElementLength)
+)
+
+var frameMagic = [2]byte{'A', 'P'}
+
+// MessageType identifies the payload of a frame.
+type MessageType uint8
+
+const (
+	MessageHello    MessageType = 1
+	MessageHelloAck MessageType = 2
+	MessageBlinding MessageType = 3
+	MessageTagSet   MessageType = 4
+	MessageError    MessageType = 5
+	MessageIssue    MessageType = 6
+	MessageIssued   MessageType = 7
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageHello:
+		return "hello"
+	case MessageHelloAck:
+		return "hello-ack"
+	case MessageBlinding:
+		return "blinding"
+	case MessageTagSet:
+		return "tag-set"
+	case MessageError:
+		return "error"
+	case MessageIssue:
+		return "issue"
+	case MessageIssued:
+		return "issued"
+	}
+	return fmt.Sprintf("message(%d)", uint8(t))
+}
+
+var (
+	// ErrVersionMismatch is returned when the two sides share no protocol
+	// version.
+	ErrVersionMismatch = errors.New("apsi: no common protocol version")
+
+	// ErrFrameTooLarge is returned when a frame or a count inside it is
+	// larger than the decoding limits allow.
+	ErrFrameTooLarge = errors.New("apsi: frame exceeds size limit")
+)
+
+// Error codes carried by Error messages.
+const (
+	CodeInternal   uint16 = 1
+	CodeVersion    uint16 = 2
+	CodeMalformed  uint16 = 3
+	CodeUnexpected uint16 = 4
+	CodeEpoch      uint16 = 5
+	CodeRevocation uint16 = 6
+	CodeKey        uint16 = 7
+	CodeClient     uint16 = 8
+	CodeContext    uint16 = 9
+	CodeForbidden  uint16 = 10
+	CodePolicy     uint16 = 11
+)
+
+// ProtocolError is an error reported by the peer in an Error message.
+type ProtocolError struct {
+	Code    uint16
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("apsi: peer error %d: %s", e.Code, e.Message)
+}
+
+// maxPayload returns the largest payload a frame of type msgType may
+// declare. Frames of unknown types may carry none.
+func maxPayload(msgType MessageType) uint32 {
+	switch msgType {
+	case MessageHello:
+		return 2
+	case MessageHelloAck:
+		return 1
+	case MessageBlinding:
+		return maxBlindingSize
+	case MessageTagSet:
+		return MaxFrameSize
+	case MessageError:
+		return 4 + maxErrorLength
+	case MessageIssue:
+		return maxIssueSize
+	case MessageIssued:
+		return maxIssuedSize
+	}
+	return 0
+}
+
+// frameTimeout returns timeout, or DefaultFrameTimeout if it is zero.
+func frameTimeout(timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return DefaultFrameTimeout
+	}
+	return timeout
+}
+
+// deadliner is the part of net.Conn that wireConn uses for timeouts.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// wireConn reads and writes frames on one connection. With timeout set
+// and a connection that supports deadlines, every frame must be read or
+// written within timeout.
+type wireConn struct {
+	rw      io.ReadWriter
+	version uint8
+	timeout time.Duration
+}
+
+func (c *wireConn) writeFrame(msgType MessageType, payload []byte) error {
+	if uint64(len(payload)) > uint64(maxPayload(msgType)) {
+		return ErrFrameTooLarge
+	}
+	if d, ok := c.rw.(deadliner); ok && c.timeout > 0 {
+		d.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	copy(frame, frameMagic[:])
+	frame[2] = c.version
+	frame[3] = byte(msgType)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame, which must be of type want. An Error
+// frame from the peer is returned as a *ProtocolError. The payload is read
+// as it arrives rather than allocated from the declared length, so a peer
+// that announces a large frame and sends nothing costs nothing.
+func (c *wireConn) readFrame(want MessageType) ([]byte, error) {
+	if d, ok := c.rw.(deadliner); ok && c.timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != frameMagic[0] || header[1] != frameMagic[1] {
+		return nil, ErrMalformedMessage
+	}
+	msgType := MessageType(header[3])
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > maxPayload(msgType) {
+		return nil, ErrFrameTooLarge
+	}
+	var payload bytes.Buffer
+	if _, err := payload.ReadFrom(io.LimitReader(c.rw, int64(length))); err != nil {
+		return nil, err
+	}
+	if payload.Len() != int(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if msgType == MessageError {
+		return nil, decodeError(payload.Bytes())
+	}
+	if header[2] != c.version {
+		return nil, fmt.Errorf("apsi: frame version %d, negotiated %d", header[2], c.version)
+	}
+	if msgType != want {
+		return nil, fmt.Errorf("apsi: got %s message, want %s", msgType, want)
+	}
+	return payload.Bytes(), nil
+}
+
+// sendError reports err to the peer. Failures to send are ignored since the
+// session is being torn down anyway.
+func (c *wireConn) sendError(code uint16, err error) {
+	message := err.Error()
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength]
+	}
+	payload := make([]byte, 4+len(message))
+	binary.BigEndian.PutUint16(payload, code)
+	binary.BigEndian.PutUint16(payload[2:], uint16(len(message)))
+	copy(payload[4:], message)
+	c.writeFrame(MessageError, payload)
+}
+
+func decodeError(payload []byte) error {
+	if len(payload) < 4 {
+		return ErrMalformedMessage
+	}
+	length := int(binary.BigEndian.Uint16(payload[2:]))
+	if length > maxErrorLength || len(payload) != 4+length {
+		return ErrMalformedMessage
+	}
+	return &ProtocolError{
+		Code:    binary.BigEndian.Uint16(payload),
+		Message: string(payload[4:]),
+	}
+}
+
+// clientHandshake offers [MinProtocolVersion, MaxProtocolVersion] and
+// adopts the version the server picks.
+func (c *wireConn) clientHandshake() error {
+	c.version = 0
+	if err := c.writeFrame(MessageHello, []byte{MinProtocolVersion, MaxProtocolVersion}); err != nil {
+		return err
+	}
+	payload, err := c.readFrame(MessageHelloAck)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 1 {
+		return ErrMalformedMessage
+	}
+	version := payload[0]
+	if version < MinProtocolVersion || version > MaxProtocolVersion {
+		return ErrVersionMismatch
+	}
+	c.version = version
+	return nil
+}
+
+// serverHandshake picks the highest version both sides speak, or reports
+// ErrVersionMismatch to the client.
+func (c *wireConn) serverHandshake() error {
+	c.version = 0
+	payload, err := c.readFrame(MessageHello)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 2 || payload[0] > payload[1] {
+		c.sendError(CodeMalformed, ErrMalformedMessage)
+		return ErrMalformedMessage
+	}
+	low, high := payload[0], payload[1]
+	if low < MinProtocolVersion {
+		low = MinProtocolVersion
+	}
+	if high > MaxProtocolVersion {
+		high = MaxProtocolVersion
+	}
+	if low > high {
+		c.sendError(CodeVersion, ErrVersionMismatch)
+		return ErrVersionMismatch
+	}
+	if err := c.writeFrame(MessageHelloAck, []byte{high}); err != nil {
+		return err
+	}
+	c.version = high
+	return nil
+}
+
+// wireReader consumes a payload front to back, remembering the first
+// decoding error.
+type wireReader struct {
+	buf []byte
+	err error
+}
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = ErrMalformedMessage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *wireReader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *wireReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *wireReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// bytes16 reads a uint16 length followed by that many bytes.
+func (r *wireReader) bytes16() []byte {
+	return r.next(int(r.uint16()))
+}
+
+// done fails unless the whole payload has been consumed.
+func (r *wireReader) done() error {
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = ErrMalformedMessage
+	}
+	return r.err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendBytes16(b []byte, v []byte) []byte {
+	return append(appendUint16(b, uint16(len(v))), v...)
+}
+
+// decodeG1 reads a point of G1, rejecting encodings of the wrong length,
+// the identity and anything outside the order-r subgroup. PBC takes any
+// pair of coordinates as a point, so a peer could otherwise send one of
+// small order and learn from the reply what it did to a secret.
+func decodeG1(pairing *pbc.Pairing, b []byte) (*pbc.Element, error) {
+	if len(b) != int(pairing.G1Length()) {
+		return nil, ErrMalformedMessage
+	}
+	point := pairing.NewG1().SetBytes(b)
+	if point.Is0() || !pairing.NewG1().MulBig(point, groupOrder(pairing)).Is0() {
+		return nil, ErrMalformedMessage
+	}
+	return point, nil
+}
+
+// groupOrder returns r, the order of G1 and of Zr, as -1 + 1 in Zr.
+func groupOrder(pairing *pbc.Pairing) *big.Int {
+	minusOne := pairing.NewZr().Set1()
+	minusOne.Neg(minusOne)
+	r := minusOne.BigInt()
+	return r.Add(r, big.NewInt(1))
+}
+
+// MarshalBinary encodes the message as a Blinding payload:
+//
+//	len(rxP) (2) | rxP | extension count (2) | extensions
+//
+// Each extension is type (2) | len (2) | value, and each type appears at
+// most once:
+//
+//   - extensionEpoch carries the 8-byte epoch and is left out for NoEpoch.
+//   - extensionRevocation carries the 8-byte revocation list version and is
+//     left out for version 0.
+//   - extensionKey carries the 8-byte key ID rxP was computed under and
+//     is required.
+//   - extensionAlternates carries key ID (8) | len(rxP) (2) | rxP for each
+//     alternate key version.
+//   - extensionClient carries len(client ID) (1) | client ID followed by a
+//     proof len(T) (2) | T | len(S) (2) | S for rxP and then for each
+//     alternate in order.
+//   - extensionContext carries the 32-byte context digest and is left out
+//     when there is no context.
+//
+// Unknown extensions are rejected.
+func (msg *BlindingMessage) MarshalBinary() ([]byte, error) {
+	if msg.RxP == nil || msg.KeyID == (KeyID{}) {
+		return nil, ErrMalformedMessage
+	}
+	var extensions []byte
+	var numExtensions uint16
+	if msg.Epoch != NoEpoch {
+		extensions = appendUint64Extension(extensions, extensionEpoch, uint64(msg.Epoch))
+		numExtensions++
+	}
+	if msg.Context != (ContextDigest{}) {
+		extensions = appendBytes16(appendUint16(extensions, extensionContext), msg.Context[:])
+		numExtensions++
+	}
+	if msg.RevocationVersion != 0 {
+		extensions = appendUint64Extension(extensions, extensionRevocation, msg.RevocationVersion)
+		numExtensions++
+	}
+	extensions = appendBytes16(appendUint16(extensions, extensionKey), msg.KeyID[:])
+	numExtensions++
+	if len(msg.Alternates) > 0 {
+		var value []byte
+		for _, alternate := range msg.Alternates {
+			if alternate.RxP == nil {
+				return nil, ErrMalformedMessage
+			}
+			value = appendBytes16(append(value, alternate.KeyID[:]...), alternate.RxP.Bytes())
+		}
+		if len(value) > 0xffff {
+			return nil, ErrFrameTooLarge
+		}
+		extensions = appendBytes16(appendUint16(extensions, extensionAlternates), value)
+		numExtensions++
+	}
+	if msg.ClientID != "" {
+		if msg.ClientID.check() != nil {
+			return nil, ErrClientID
+		}
+		value := append([]byte{byte(len(msg.ClientID))}, msg.ClientID...)
+		proofs := []*KeyProof{msg.Proof}
+		for _, alternate := range msg.Alternates {
+			proofs = append(proofs, alternate.Proof)
+		}
+		for _, proof := range proofs {
+			if proof == nil || proof.T == nil || proof.S == nil {
+				return nil, ErrMalformedMessage
+			}
+			value = append(value, encodeElements(proof.T, proof.S)...)
+		}
+		if len(value) > 0xffff {
+			return nil, ErrFrameTooLarge
+		}
+		extensions = appendBytes16(appendUint16(extensions, extensionClient), value)
+		numExtensions++
+	}
+	b := appendBytes16(nil, msg.RxP.Bytes())
+	b = appendUint16(b, numExtensions)
+	return append(b, extensions...), nil
+}
+
+const (
+	extensionEpoch      uint16 = 1
+	extensionRevocation uint16 = 2
+	extensionKey        uint16 = 3
+	extensionAlternates uint16 = 4
+	extensionClient     uint16 = 5
+	extensionContext    uint16 = 6
+)
+
+func appendUint64Extension(b []byte, extType uint16, v uint64) []byte {
+	return appendBytes16(appendUint16(b, extType), appendUint64(nil, v))
+}
+
+// UnmarshalBlindingMessage decodes a Blinding payload produced by
+// MarshalBinary.
+func UnmarshalBlindingMessage(pairing *pbc.Pairing, data []byte) (*BlindingMessage, error) {
+	r := &wireReader{buf: data}
+	point := r.bytes16()
+	msg := &BlindingMessage{}
+	var proofs []*KeyProof
+	seen := make(map[uint16]bool)
+	for n := r.uint16(); n > 0 && r.err == nil; n-- {
+		extType, value := r.uint16(), r.bytes16()
+		if r.err != nil {
+			break
+		}
+		if seen[extType] {
+			return nil, ErrMalformedMessage
+		}
+		seen[extType] = true
+		switch {
+		case extType == extensionEpoch && len(value) == 8:
+			msg.Epoch = Epoch(binary.BigEndian.Uint64(value))
+		case extType == extensionContext && len(value) == len(ContextDigest{}):
+			copy(msg.Context[:], value)
+		case extType == extensionRevocation && len(value) == 8:
+			msg.RevocationVersion = binary.BigEndian.Uint64(value)
+		case extType == extensionKey && len(value) == len(KeyID{}):
+			copy(msg.KeyID[:], value)
+		case extType == extensionAlternates:
+			alternates, err := decodeAlternates(pairing, value)
+			if err != nil {
+				return nil, err
+			}
+			msg.Alternates = alternates
+		case extType == extensionClient:
+			id, clientProofs, err := decodeClientExtension(pairing, value)
+			if err != nil {
+				return nil, err
+			}
+			msg.ClientID, proofs = id, clientProofs
+		default:
+			return nil, ErrMalformedMessage
+		}
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	if msg.KeyID == (KeyID{}) {
+		return nil, ErrMalformedMessage
+	}
+	if msg.ClientID != "" {
+		if len(proofs) != 1+len(msg.Alternates) {
+			return nil, ErrMalformedMessage
+		}
+		msg.Proof = proofs[0]
+		for i := range msg.Alternates {
+			msg.Alternates[i].Proof = proofs[1+i]
+		}
+	}
+	rxP, err := decodeG1(pairing, point)
+	if err != nil {
+		return nil, err
+	}
+	msg.RxP = rxP
+	return msg, nil
+}
+
+func decodeAlternates(pairing *pbc.Pairing, value []byte) ([]KeyBlinding, error) {
+	r := &wireReader{buf: value}
+	var alternates []KeyBlinding
+	for len(r.buf) > 0 && r.err == nil {
+		var alternate KeyBlinding
+		copy(alternate.KeyID[:], r.next(len(alternate.KeyID)))
+		point := r.bytes16()
+		if r.err != nil {
+			break
+		}
+		rxP, err := decodeG1(pairing, point)
+		if err != nil {
+			return nil, err
+		}
+		alternate.RxP = rxP
+		alternates = append(alternates, alternate)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return alternates, nil
+}
+
+func decodeClientExtension(pairing *pbc.Pairing, value []byte) (ClientID, []*KeyProof, error) {
+	r := &wireReader{buf: value}
+	idLen := r.next(1)
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	id := ClientID(r.next(int(idLen[0])))
+	if r.err != nil || id.check() != nil {
+		return "", nil, ErrMalformedMessage
+	}
+	proofSize := 2 + int(pairing.G1Length()) + 2 + pairing.NewZr().BytesLen()
+	var proofs []*KeyProof
+	for len(r.buf) > 0 && r.err == nil {
+		fields := r.next(proofSize)
+		if r.err != nil {
+			break
+		}
+		elements, err := decodeElements(fields, pairing.NewG1, pairing.NewZr)
+		if err != nil {
+			return "", nil, err
+		}
+		proofs = append(proofs, &KeyProof{T: elements[0], S: elements[1]})
+	}
+	if err := r.done(); err != nil {
+		return "", nil, err
+	}
+	return id, proofs, nil
+}
+
+// MarshalBinary encodes the message as a TagSet payload:
+//
+//	tag count (4) | tags (32 each) | key ID (8)
+func (msg *TagSetMessage) MarshalBinary() ([]byte, error) {
+	if len(msg.Tags) > MaxTags {
+		return nil, ErrFrameTooLarge
+	}
+	if msg.KeyID == (KeyID{}) {
+		return nil, ErrMalformedMessage
+	}
+	b := make([]byte, 0, 4+len(msg.Tags)*sha256.Size+len(msg.KeyID))
+	b = appendUint32(b, uint32(len(msg.Tags)))
+	for _, tag := range msg.Tags {
+		b = append(b, tag[:]...)
+	}
+	return append(b, msg.KeyID[:]...), nil
+}
+
+// UnmarshalTagSetMessage decodes a TagSet payload produced by
+// MarshalBinary.
+func UnmarshalTagSetMessage(data []byte) (*TagSetMessage, error) {
+	r := &wireReader{buf: data}
+	count := r.uint32()
+	if r.err == nil && count > MaxTags {
+		return nil, ErrFrameTooLarge
+	}
+	msg := &TagSetMessage{}
+	switch {
+	case r.err != nil:
+	case len(r.buf) == int(count)*sha256.Size+len(msg.KeyID):
+		copy(msg.KeyID[:], r.buf[len(r.buf)-len(msg.KeyID):])
+		r.buf = r.buf[:len(r.buf)-len(msg.KeyID)]
+	default:
+		return nil, ErrMalformedMessage
+	}
+	if msg.KeyID == (KeyID{}) {
+		return nil, ErrMalformedMessage
+	}
+	msg.Tags = make([]Tag, count)
+	for i := range msg.Tags {
+		copy(msg.Tags[i][:], r.next(sha256.Size))
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,103 @@
+package apsi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// frame encodes a frame header claiming length bytes of payload, followed
+// by payload, which may be shorter.
+func frame(magic [2]byte, version uint8, msgType MessageType, length uint32, payload []byte) []byte {
+	b := []byte{magic[0], magic[1], version, byte(msgType), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[4:], length)
+	return append(b, payload...)
+}
+
+func TestReadFrame(t *testing.T) {
+	hello := []byte{MinProtocolVersion, MaxProtocolVersion}
+	tests := []struct {
+		name    string
+		version uint8
+		want    MessageType
+		data    []byte
+		err     error
+	}{
+		{"ok", 0, MessageHello, frame(frameMagic, 0, MessageHello, 2, hello), nil},
+		{"bad magic", 0, MessageHello, frame([2]byte{'X', 'P'}, 0, MessageHello, 2, hello), ErrMalformedMessage},
+		{"oversized", 0, MessageHello, frame(frameMagic, 0, MessageHello, 3, append(hello, 0)), ErrFrameTooLarge},
+		{"oversized unknown type", 0, MessageHello, frame(frameMagic, 0, MessageType(99), 1, []byte{0}), ErrFrameTooLarge},
+		{"short header", 0, MessageHello, frame(frameMagic, 0, MessageHello, 2, nil)[:5], io.ErrUnexpectedEOF},
+		{"short payload", 0, MessageHello, frame(frameMagic, 0, MessageHello, 2, hello[:1]), io.ErrUnexpectedEOF},
+		{"empty", 0, MessageHello, nil, io.EOF},
+		{"malformed error", 0, MessageHello, frame(frameMagic, 0, MessageError, 2, []byte{0, 1}), ErrMalformedMessage},
+	}
+	for _, tt := range tests {
+		wire := &wireConn{rw: bytes.NewBuffer(tt.data), version: tt.version}
+		payload, err := wire.readFrame(tt.want)
+		if err != tt.err {
+			t.Errorf("%s: readFrame = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && !bytes.Equal(payload, hello) {
+			t.Errorf("%s: payload = %x, want %x", tt.name, payload, hello)
+		}
+	}
+}
+
+func TestReadFrameRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		want MessageType
+		data []byte
+	}{
+		{"wrong version", MessageBlinding, frame(frameMagic, 0, MessageBlinding, 0, nil)},
+		{"wrong type", MessageTagSet, frame(frameMagic, MaxProtocolVersion, MessageBlinding, 0, nil)},
+	}
+	for _, tt := range tests {
+		wire := &wireConn{rw: bytes.NewBuffer(tt.data), version: MaxProtocolVersion}
+		if _, err := wire.readFrame(tt.want); err == nil {
+			t.Errorf("%s: readFrame succeeded", tt.name)
+		}
+	}
+}
+
+func TestReadFramePeerError(t *testing.T) {
+	var buf bytes.Buffer
+	(&wireConn{rw: &buf}).sendError(CodeEpoch, ErrEpochOver)
+	_, err := (&wireConn{rw: &buf, version: MaxProtocolVersion}).readFrame(MessageTagSet)
+	perr, ok := err.(*ProtocolError)
+	if !ok || perr.Code != CodeEpoch || perr.Message != ErrEpochOver.Error() {
+		t.Errorf("readFrame = %v, want peer error %d", err, CodeEpoch)
+	}
+}
+
+func TestDecodeG1(t *testing.T) {
+	_, scheme := NewDualAPSIScheme()
+	pairing := scheme.Pairing()
+	elt := pairing.NewG1().Rand()
+	b := elt.Bytes()
+
+	decoded, err := decodeG1(pairing, b)
+	if err != nil {
+		t.Fatalf("decodeG1: %v", err)
+	}
+	if !decoded.Equals(elt) {
+		t.Errorf("decodeG1 returned another element")
+	}
+	for _, bad := range [][]byte{nil, b[:len(b)-1], append(append([]byte(nil), b...), 0)} {
+		if _, err := decodeG1(pairing, bad); err != ErrMalformedMessage {
+			t.Errorf("decodeG1 of %d bytes = %v, want %v", len(bad), err, ErrMalformedMessage)
+		}
+	}
+
+	if _, err := decodeG1(pairing, pairing.NewG1().Set0().Bytes()); err != ErrMalformedMessage {
+		t.Errorf("decodeG1 of the identity = %v, want %v", err, ErrMalformedMessage)
+	}
+	// All ones is no point of the subgroup: its coordinates exceed q.
+	offGroup := bytes.Repeat([]byte{0xff}, len(b))
+	if _, err := decodeG1(pairing, offGroup); err != ErrMalformedMessage {
+		t.Errorf("decodeG1 of a point outside the subgroup = %v, want %v", err, ErrMalformedMessage)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// authorize, reissue, serve and query take -audit to record what they do
+// in an audit log; apsi audit checks the log afterwards.
+var auditCommands = []command{
+	{"verify", "check an audit log's hash chain against its head", runAuditVerify},
+	{"head", "print an audit log's head to keep somewhere else", runAuditHead},
+}
+
+func runAudit(args []string) error {
+	return dispatch("audit", auditCommands, args)
+}
+
+func addAuditFlag(flags *flag.FlagSet) *string {
+	return flags.String("audit", "", "audit log to record in, created if missing")
+}
+
+// openAuditLog opens the audit log at path, or returns nil if path is
+// empty.
+func openAuditLog(path string) (*apsi.AuditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	log, err := apsi.OpenAuditLog(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return log, nil
+}
+
+func runAuditVerify(args []string) error {
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	logPath := flags.String("log", "", "audit log to verify")
+	headPath := flags.String("head", "", "head file to check the log against (default the -log path with .head)")
+	expect := flags.String("expect", "", "head printed earlier by apsi audit head, as seq:hash, to check as well")
+	flags.Parse(args)
+
+	if *logPath == "" {
+		return errors.New("-log is required")
+	}
+	if *headPath == "" {
+		*headPath = apsi.AuditHeadPath(*logPath)
+	}
+	var heads []*apsi.AuditHead
+	head, err := apsi.ReadAuditHead(*headPath)
+	if err == nil {
+		heads = append(heads, &head)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %v", *headPath, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: no head file; truncation cannot be detected\n", *headPath)
+	}
+	if *expect != "" {
+		expected, err := apsi.ParseAuditHead(*expect)
+		if err != nil {
+			return err
+		}
+		heads = append(heads, &expected)
+	}
+	if len(heads) == 0 {
+		heads = append(heads, nil)
+	}
+
+	var last apsi.AuditHead
+	for _, expected := range heads {
+		f, err := os.Open(*logPath)
+		if err != nil {
+			return err
+		}
+		last, err = apsi.VerifyAuditLog(f, expected)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", *logPath, err)
+		}
+	}
+	fmt.Printf("%s: %d entries verified, head %s\n", *logPath, last.Seq, last)
+	return nil
+}
+
+func runAuditHead(args []string) error {
+	flags := flag.NewFlagSet("audit head", flag.ExitOnError)
+	logPath := flags.String("log", "", "audit log to print the head of")
+	flags.Parse(args)
+
+	if *logPath == "" {
+		return errors.New("-log is required")
+	}
+	f, err := os.Open(*logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head, err := apsi.VerifyAuditLog(f, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *logPath, err)
+	}
+	fmt.Println(head)
+	return nil
+}
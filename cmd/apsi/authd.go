@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// apsi authd keeps the authority's key on one host and signs sets for the
+// parties over mutual TLS; apsi issue is the party's side. Each peer is
+// named by the common name of its certificate and may only ask for the
+// parties it was granted with -grant.
+
+// grantList is a repeatable flag of name=client, name=server or name=both.
+type grantList map[string][]apsi.Party
+
+func (grants grantList) String() string {
+	names := make([]string, 0, len(grants))
+	for name := range grants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (grants grantList) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q is not name=client, name=server or name=both", s)
+	}
+	name, parties := s[:i], s[i+1:]
+	if parties == "both" {
+		grants[name] = append(grants[name], apsi.ClientParty, apsi.ServerParty)
+		return nil
+	}
+	party, err := parseParty(parties)
+	if err != nil {
+		return err
+	}
+	grants[name] = append(grants[name], party)
+	return nil
+}
+
+// tlsFlags are the certificate flags both sides of the signing service
+// take.
+type tlsFlags struct {
+	cert *string
+	key  *string
+	ca   *string
+}
+
+func addTLSFlags(flags *flag.FlagSet, name string) *tlsFlags {
+	return &tlsFlags{
+		cert: flags.String("cert", name+".crt", "certificate to present to the peer"),
+		key:  flags.String("tls-key", name+".key", "private key of -cert"),
+		ca:   flags.String("ca", "ca.crt", "CA certificate to verify the peer against"),
+	}
+}
+
+// config returns a TLS configuration presenting -cert and trusting only
+// the -ca certificates, on either side.
+func (tf *tlsFlags) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(*tf.cert, *tf.key)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(*tf.ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", *tf.ca)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func runAuthd(args []string) error {
+	flags := flag.NewFlagSet("authd", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file written by apsi keystore create")
+	addr := flags.String("addr", ":7400", "address to listen on")
+	tf := addTLSFlags(flags, "authd")
+	grants := make(grantList)
+	flags.Var(grants, "grant", "let the peer whose certificate names name request client, server or both authorizations, as name=party (repeatable)")
+	pf := addPolicyFlags(flags)
+	timeout := flags.Duration("timeout", apsi.DefaultFrameTimeout, "how long to wait for a peer's TLS handshake and for it to send or take each message")
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	if len(grants) == 0 {
+		return errors.New("at least one -grant is required")
+	}
+	if len(pf.justified) > 0 {
+		return errors.New("the justification comes from each request, not -justify")
+	}
+	if *pf.requester != "local" {
+		return errors.New("each peer is named by its certificate, not -requester")
+	}
+	config, err := tf.config()
+	if err != nil {
+		return err
+	}
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	engine, err := pf.engine(authority)
+	if err != nil {
+		return err
+	}
+	var service *apsi.SigningService
+	if engine != nil {
+		service = apsi.NewSigningService(engine, authority.PublicKey())
+	} else {
+		service = apsi.NewSigningService(authority, authority.PublicKey())
+	}
+	for name, parties := range grants {
+		service.Grant(name, parties...)
+	}
+	service.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+	service.Timeout = *timeout
+
+	listener, err := apsi.TLSTransport{Addr: *addr, Config: config}.Listen()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	// SIGHUP reloads the policy; SIGINT and SIGTERM stop the service once
+	// the batches being signed are answered. The quota usage needs no
+	// saving, as the engine writes it before each batch is signed.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				close(stopped)
+				listener.Close()
+				return
+			}
+			if engine == nil {
+				continue
+			}
+			if err := engine.Reload(*pf.policy); err != nil {
+				log.Printf("%s: %v; keeping the previous policy", *pf.policy, err)
+			} else {
+				log.Printf("reloaded %s", *pf.policy)
+			}
+		}
+	}()
+
+	log.Printf("signing for %d peers on %s", len(grants), listener.Addr())
+	err = service.Serve(listener)
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return err
+	}
+}
+
+func runIssue(args []string) error {
+	flags := flag.NewFlagSet("issue", flag.ExitOnError)
+	addr := flags.String("authd", "localhost:7400", "address of the signing service")
+	tf := addTLSFlags(flags, "party")
+	partyName := flags.String("party", "", "party to authorize the set for: client or server")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	pubPath := flags.String("pub", "authority.pub", "public key file of the authority")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	justified := make(justification)
+	flags.Var(justified, "justify", "justification field=value for the authority's policy (repeatable)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	if *outPath == "" {
+		*outPath = party.String() + ".apsi"
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	public, err := ioutil.ReadFile(*pubPath)
+	if err != nil {
+		return err
+	}
+	pairing, pk, err := loadPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	config, err := tf.config()
+	if err != nil {
+		return err
+	}
+
+	conn, err := apsi.TLSTransport{Addr: *addr, Config: config}.Dial()
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	_, signatures, err := apsi.RequestIssue(conn, pairing, pk, apsi.RequestSubmission{
+		Party:         party,
+		Elements:      set,
+		Justification: justified,
+		Epoch:         v.Epoch,
+		Context:       v.Context,
+	})
+	if err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, publicKeyBytes(public), party, set, signatures, v, "", nil)
+}
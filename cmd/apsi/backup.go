@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// A backup is made once, offline, and its shares handed to custodians.
+// apsi backup verify lets the custodians check their shares against the
+// published key without rebuilding it; apsi backup recover rebuilds it.
+var backupCommands = []command{
+	{"split", "split the authority's key into backup shares", runBackupSplit},
+	{"verify", "check backup shares against the public key", runBackupVerify},
+	{"recover", "rebuild the authority's key from backup shares", runBackupRecover},
+}
+
+func runBackup(args []string) error {
+	return dispatch("backup", backupCommands, args)
+}
+
+func runBackupSplit(args []string) error {
+	flags := flag.NewFlagSet("backup split", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file to back up")
+	threshold := flags.Int("t", 2, "number of shares needed to recover")
+	shares := flags.Int("n", 3, "number of shares")
+	dir := flags.String("dir", ".", "directory to write backup-<i>.pem to")
+	flags.Parse(args)
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	backup, err := authority.Backup(*threshold, *shares)
+	if err != nil {
+		return err
+	}
+	for _, share := range backup {
+		path := filepath.Join(*dir, fmt.Sprintf("backup-%d.pem", share.Index))
+		if err := writeFileWith(path, 0600, func(f *os.File) error {
+			return share.Write(f)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverShares checks every share file against the public key at pubPath,
+// printing one line per share.
+func recoverShares(pubPath string, sharePaths fileList) (*apsi.Recovery, error) {
+	if len(sharePaths) == 0 {
+		return nil, errors.New("at least one -share is required")
+	}
+	f, err := os.Open(pubPath)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := apsi.NewRecovery(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pubPath, err)
+	}
+	for _, path := range sharePaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		share, err := rec.Add(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: share %d of %d ok\n", path, share.Index, share.Shares)
+	}
+	return rec, nil
+}
+
+func runBackupVerify(args []string) error {
+	flags := flag.NewFlagSet("backup verify", flag.ExitOnError)
+	pubPath := flags.String("pub", "authority.pub", "published public key file of the authority")
+	var sharePaths fileList
+	flags.Var(&sharePaths, "share", "backup share written by apsi backup split (repeatable)")
+	flags.Parse(args)
+
+	rec, err := recoverShares(*pubPath, sharePaths)
+	if err != nil {
+		return err
+	}
+	if needed := rec.Needed(); needed > 0 {
+		fmt.Printf("%d more shares needed to recover\n", needed)
+	} else {
+		fmt.Println("enough shares to recover")
+	}
+	return nil
+}
+
+func runBackupRecover(args []string) error {
+	flags := flag.NewFlagSet("backup recover", flag.ExitOnError)
+	pubPath := flags.String("pub", "authority.pub", "published public key file of the authority")
+	var sharePaths fileList
+	flags.Var(&sharePaths, "share", "backup share written by apsi backup split (repeatable)")
+	keyPath := flags.String("key", "authority.key", "file to write the recovered keys to")
+	plaintext := flags.Bool("plaintext", false, "write the secret key unencrypted (testing only)")
+	flags.Parse(args)
+
+	rec, err := recoverShares(*pubPath, sharePaths)
+	if err != nil {
+		return err
+	}
+	authority, err := rec.Authority()
+	if err != nil {
+		return err
+	}
+	if *plaintext {
+		return writeFileWith(*keyPath, 0600, func(f *os.File) error {
+			return authority.WriteKeys(f)
+		})
+	}
+	return writeEncryptedAuthority(*keyPath, authority, false)
+}
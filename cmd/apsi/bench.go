@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Nik-U/pbc"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	cpuprofile := flags.String("cpuprofile", "", "write cpu profile to file")
+	flags.Parse(args)
+
+	if *cpuprofile != "" {
+		fmt.Println("Running with profiling mode.")
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			return err
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	fmt.Println("Testing Dual-APSI...")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Size", "Insecure", "Naive", "Setup",
+		"Signing (Client)", "Signing (Server)",
+		"Interaction", "Interact (Thr)", "Interact (Pre)",
+		"Verify (Each)", "Verify (Batch)", "Sign (Agg)", "Aggregate", "Verify (Agg)",
+		"Size (Sigs)", "Size (Agg)"})
+
+	setSizes := []int{10, 100, 1000, 10000, 100000}
+	for _, size := range setSizes {
+		fmt.Println("Running benchmark for", size, "elements...")
+
+		benchmark, err := BenchmarkDualPSIInteraction(true, true, size, size)
+		if err != nil {
+			return err
+		}
+
+		table.Append([]string{
+			strconv.Itoa(size),
+			benchmark.insecureTime.String(),
+			benchmark.naiveTime.String(),
+			benchmark.setupTime.String(),
+			benchmark.clientSigningTime.String(),
+			benchmark.serverSigningTime.String(),
+			benchmark.interactionTime.String(),
+			benchmark.threadedTime.String(),
+			benchmark.precomputeInteractionTime.String(),
+			benchmark.verifyTime.String(),
+			benchmark.batchVerifyTime.String(),
+			benchmark.aggregateSigningTime.String(),
+			benchmark.aggregateTime.String(),
+			benchmark.aggregateVerifyTime.String(),
+			strconv.Itoa(benchmark.signaturesSize),
+			strconv.Itoa(benchmark.aggregateSize),
+		})
+	}
+	table.Render()
+
+	fmt.Println("Testing Joux Benchmark...")
+	BenchmarkJouxKeyExchange(false)
+
+	totalRuns := 100
+	fmt.Println("Running full benchmark with", totalRuns, "runs...")
+	var totalSetup time.Duration
+	var totalOnline time.Duration
+
+	for i := 1; i <= totalRuns; i++ {
+		setupTime, onlineTime := BenchmarkJouxKeyExchange(false)
+		totalSetup += setupTime
+		totalOnline += onlineTime
+	}
+
+	fmt.Println("Done! Average time elapsed: ")
+	fmt.Println("   (setup) ", totalSetup/time.Duration(totalRuns))
+	fmt.Println("  (online) ", totalOnline/time.Duration(totalRuns))
+	return nil
+}
+
+type DualPSIBenchmark struct {
+	clientCardinality int
+	serverCardinality int
+
+	insecureTime time.Duration
+	naiveTime    time.Duration
+
+	setupTime time.Duration
+
+	clientSigningTime time.Duration
+	serverSigningTime time.Duration
+
+	interactionTime           time.Duration
+	threadedTime              time.Duration
+	precomputeInteractionTime time.Duration
+
+	// Verifying the client's set one signature at a time, in one batch and
+	// as one aggregate; issuing the aggregate directly and forming it from
+	// the per-element signatures; and the bytes the signatures and the
+	// aggregate take to send.
+	verifyTime           time.Duration
+	batchVerifyTime      time.Duration
+	aggregateSigningTime time.Duration
+	aggregateTime        time.Duration
+	aggregateVerifyTime  time.Duration
+	signaturesSize       int
+	aggregateSize        int
+}
+
+func BenchmarkDualPSIInteraction(isDebug bool, doGarbageCollectBetweenRuns bool, clientCardinality int, serverCardinality int) (DualPSIBenchmark, error) {
+	clientSet := generateRandomSet(clientCardinality)
+	serverSet := generateRandomSet(serverCardinality)
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	insecureTime, realIntersection := findInsecureIntersection(clientSet, serverSet)
+	sort.Sort(realIntersection)
+	if isDebug {
+		fmt.Println("Insecure intersection: ", realIntersection)
+		fmt.Println("Insecure time:", insecureTime)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	naiveTime, naiveIntersection := findNaiveHashingIntersection(clientSet, serverSet)
+	sort.Sort(naiveIntersection)
+	if isDebug {
+		fmt.Println("Naive hashing intersection:", naiveIntersection)
+		fmt.Println("Naive hashing time:", naiveTime)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	setupTime, scheme := apsi.NewDualAPSIScheme()
+	if isDebug {
+		fmt.Println("Setup time:", setupTime)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	clientSigningTime, clientSignatures, err := scheme.AuthorizeSet(clientSet, apsi.ClientParty)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	serverSigningTime, serverSignatures, err := scheme.AuthorizeSet(serverSet, apsi.ServerParty)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	if isDebug {
+		fmt.Println("Client signing time (avg):", clientSigningTime/time.Duration(clientCardinality))
+		fmt.Println("Server signing time (avg):", serverSigningTime/time.Duration(serverCardinality))
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	interactionTime, protocolIntersection, err := scheme.Interaction(clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Interaction time:", interactionTime)
+		fmt.Println("Protocol intersection: ", protocolIntersection)
+	}
+
+	// Verify equality:
+	isEqual := sameRawElementSlice(realIntersection, protocolIntersection)
+	if isDebug {
+		fmt.Println("Correct?", isEqual)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	threadedTime, protocolIntersection, err := scheme.ThreadedInteraction(clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Threaded interaction time:", threadedTime)
+		fmt.Println("Protocol threaded intersection: ", protocolIntersection)
+	}
+
+	// Verify equality:
+	isEqual = sameRawElementSlice(realIntersection, protocolIntersection)
+	if isDebug {
+		fmt.Println("Correct?", isEqual)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	precomputeInteractionTime, protocolIntersection, err := scheme.PrecomputeThreadedInteraction(clientSet, clientSignatures, serverSet, serverSignatures)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Precompute interaction time:", precomputeInteractionTime)
+		fmt.Println("Protocol threaded intersection: ", protocolIntersection)
+	}
+
+	for numThreads := 1; numThreads < clientCardinality; numThreads = numThreads * 2 {
+		if err := benchmarkThreadedVariants(scheme, isDebug, doGarbageCollectBetweenRuns,
+			clientSet, clientSignatures, serverSet, serverSignatures, numThreads); err != nil {
+			return DualPSIBenchmark{}, err
+		}
+	}
+
+	// Re-run with clientCardinality threads.
+	if err := benchmarkThreadedVariants(scheme, isDebug, doGarbageCollectBetweenRuns,
+		clientSet, clientSignatures, serverSet, serverSignatures, clientCardinality); err != nil {
+		return DualPSIBenchmark{}, err
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	startVerify := time.Now()
+	for i, elt := range clientSet {
+		if _, err := apsi.Verify(scheme.Pairing(), scheme.PublicKey(), elt, clientSignatures[i], apsi.ClientParty); err != nil {
+			return DualPSIBenchmark{}, err
+		}
+	}
+	verifyTime := time.Since(startVerify)
+	if isDebug {
+		fmt.Println("Per-element verification time:", verifyTime)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	startBatch := time.Now()
+	invalid, err := apsi.BatchVerify(scheme.Pairing(), scheme.PublicKey(), clientSet, clientSignatures, apsi.ClientParty)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	batchVerifyTime := time.Since(startBatch)
+	if isDebug {
+		fmt.Println("Batch verification time:", batchVerifyTime)
+		fmt.Println("Invalid signatures:", invalid)
+	}
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	aggregateSigningTime, issued, err := scheme.Authority().AuthorizeAggregate(clientSet, apsi.ClientParty)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	startAggregate := time.Now()
+	aggregate := apsi.AggregateSignatures(scheme.Pairing(), clientSignatures)
+	aggregateTime := time.Since(startAggregate)
+	startAggregateVerify := time.Now()
+	isValid, err := apsi.VerifyAggregate(scheme.Pairing(), scheme.PublicKey(), clientSet, aggregate, apsi.ClientParty)
+	if err != nil {
+		return DualPSIBenchmark{}, err
+	}
+	aggregateVerifyTime := time.Since(startAggregateVerify)
+	if isDebug {
+		fmt.Println("Aggregate signing time:", aggregateSigningTime)
+		fmt.Println("Issued aggregate matches?", issued.Equals(aggregate))
+		fmt.Println("Aggregation time:", aggregateTime)
+		fmt.Println("Aggregate verification time:", aggregateVerifyTime)
+		fmt.Println("Aggregate valid?", isValid)
+	}
+
+	signatureSize := int(scheme.Pairing().G1Length())
+
+	return DualPSIBenchmark{
+		len(clientSignatures), len(serverSignatures),
+		insecureTime, naiveTime,
+		setupTime,
+		clientSigningTime, serverSigningTime,
+		interactionTime, threadedTime, precomputeInteractionTime,
+		verifyTime, batchVerifyTime, aggregateSigningTime, aggregateTime, aggregateVerifyTime,
+		len(clientSignatures) * signatureSize, signatureSize,
+	}, nil
+}
+
+func benchmarkThreadedVariants(scheme *apsi.DualAPSIScheme, isDebug bool, doGarbageCollectBetweenRuns bool,
+	clientSet apsi.RawElementSlice, clientSignatures []*pbc.Element,
+	serverSet apsi.RawElementSlice, serverSignatures []*pbc.Element,
+	numThreads int) error {
+
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	smartInterTime, protocolIntersection, err := scheme.SmarterThreadedInteraction(clientSet, clientSignatures, serverSet, serverSignatures, numThreads)
+	if err != nil {
+		return err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Channel job queue interaction time with", numThreads, "threads:", smartInterTime)
+		fmt.Println("Intersection:", protocolIntersection)
+	}
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	atomicsTime, protocolIntersection, err := scheme.AtomicsThreadedInteraction(clientSet, clientSignatures, serverSet, serverSignatures, numThreads)
+	if err != nil {
+		return err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Atomic job queue interaction time with", numThreads, "threads:", atomicsTime)
+		fmt.Println("Intersection:", protocolIntersection)
+	}
+	if doGarbageCollectBetweenRuns {
+		runtime.GC()
+	}
+	divisionTime, protocolIntersection, err := scheme.DivisionThreadedInteraction(clientSet, clientSignatures, serverSet, serverSignatures, numThreads)
+	if err != nil {
+		return err
+	}
+	sort.Sort(protocolIntersection)
+	if isDebug {
+		fmt.Println("Division job queue interaction time with", numThreads, "threads:", divisionTime)
+		fmt.Println("Intersection:", protocolIntersection)
+	}
+	return nil
+}
+
+func findInsecureIntersection(clientSet apsi.RawElementSlice, serverSet apsi.RawElementSlice) (time.Duration, apsi.RawElementSlice) {
+	startTime := time.Now()
+
+	lookupTable := make(map[apsi.RawElement]bool)
+	for _, element := range clientSet {
+		lookupTable[element] = true
+	}
+
+	var intersection apsi.RawElementSlice
+	for _, element := range serverSet {
+		_, isInLookup := lookupTable[element]
+		if isInLookup {
+			intersection = append(intersection, element)
+		}
+	}
+
+	totalTime := time.Since(startTime)
+	return totalTime, intersection
+}
+
+func findNaiveHashingIntersection(clientSet apsi.RawElementSlice, serverSet apsi.RawElementSlice) (time.Duration, apsi.RawElementSlice) {
+	startTime := time.Now()
+
+	// Server sends hashes to client:
+	lookupTable := make(map[[32]byte]bool)
+	for _, element := range serverSet {
+		hashed := sha256.Sum256(element[:])
+		lookupTable[hashed] = true
+	}
+
+	// Client does a naive lookup on hashes:
+	var intersection apsi.RawElementSlice
+	for _, element := range clientSet {
+		hashed := sha256.Sum256(element[:])
+		_, isInLookup := lookupTable[hashed]
+		if isInLookup {
+			intersection = append(intersection, element)
+		}
+	}
+
+	totalTime := time.Since(startTime)
+	return totalTime, intersection
+}
+
+func generateRandomSet(size int) apsi.RawElementSlice {
+	result := make(apsi.RawElementSlice, size)
+	for i := 0; i < size; i++ {
+		rand.Read(result[i][:])
+	}
+	return removeDuplicateValues(result)
+}
+
+func removeDuplicateValues(elementSlice apsi.RawElementSlice) apsi.RawElementSlice {
+	keys := make(map[apsi.RawElement]bool)
+	list := apsi.RawElementSlice{}
+
+	// If the key(values of the slice) is not equal
+	// to the already present value in new slice (list)
+	// then we append it. else we jump on another element.
+	for _, entry := range elementSlice {
+		if _, value := keys[entry]; !value {
+			keys[entry] = true
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func sameRawElementSlice(x, y []apsi.RawElement) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	// create a map of RawElement -> int
+	diff := make(map[apsi.RawElement]int, len(x))
+	for _, _x := range x {
+		// 0 value for int is 0, so just increment a counter for the RawElement
+		diff[_x]++
+	}
+	for _, _y := range y {
+		// If the RawElement _y is not in diff bail out early
+		if _, ok := diff[_y]; !ok {
+			return false
+		}
+		diff[_y] -= 1
+		if diff[_y] == 0 {
+			delete(diff, _y)
+		}
+	}
+	if len(diff) == 0 {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// Blind issuance takes three steps. The party runs request, which writes
+// the blinded requests for the authority and keeps the blinding factors in
+// a private state file; the authority runs sign on the requests alone; the
+// party runs finish on the answers to unblind them into a state file for
+// serve or query.
+var blindCommands = []command{
+	{"request", "blind a set for authorization", runBlindRequest},
+	{"sign", "authorize blinded requests without seeing the elements", runBlindSign},
+	{"finish", "unblind the authority's answers into a state file", runBlindFinish},
+}
+
+func runBlind(args []string) error {
+	return dispatch("blind", blindCommands, args)
+}
+
+// blindRequestFile is what the party sends to the authority.
+type blindRequestFile struct {
+	Party    apsi.Party
+	Requests [][]byte
+}
+
+// blindStateFile is what the party keeps while the authority signs.
+type blindStateFile struct {
+	Party     apsi.Party
+	PublicKey []byte
+	Set       apsi.RawElementSlice
+	Factors   [][]byte
+	Validity  validity
+}
+
+// blindAnswerFile is the authority's reply to a blindRequestFile, with the
+// encoded blind key its signatures verify under.
+type blindAnswerFile struct {
+	Party      apsi.Party
+	Signatures [][]byte
+	BlindKey   []byte
+}
+
+// publicKeyBytes is a public key file that has already been written.
+type publicKeyBytes []byte
+
+func (b publicKeyBytes) WritePublicKey(w io.Writer) error {
+	_, err := w.Write(b)
+	return err
+}
+
+func writeGobFile(path string, perm os.FileMode, v interface{}) error {
+	return writeFileWith(path, perm, func(f *os.File) error {
+		return gob.NewEncoder(f).Encode(v)
+	})
+}
+
+func readGobFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func runBlindRequest(args []string) error {
+	flags := flag.NewFlagSet("blind request", flag.ExitOnError)
+	pubPath := flags.String("pub", "authority.pub", "authority public key")
+	partyName := flags.String("party", "client", "party to request authorization for; only client is signed blindly")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "request file for the authority (default <party>.blindreq)")
+	statePath := flags.String("state", "", "private file for the blinding factors (default <party>.blindstate)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if party != apsi.ClientParty {
+		return apsi.ErrBlindServer
+	}
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	if *outPath == "" {
+		*outPath = party.String() + ".blindreq"
+	}
+	if *statePath == "" {
+		*statePath = party.String() + ".blindstate"
+	}
+
+	publicKey, err := ioutil.ReadFile(*pubPath)
+	if err != nil {
+		return err
+	}
+	pairing, _, err := apsi.ReadPublicKey(bytes.NewReader(publicKey))
+	if err != nil {
+		return fmt.Errorf("%s: %v", *pubPath, err)
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	_, requests, factors, err := apsi.BlindSet(pairing, set, v.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	state := blindStateFile{Party: party, PublicKey: publicKey, Set: set, Validity: v}
+	for _, factor := range factors {
+		state.Factors = append(state.Factors, factor.Factor.Bytes())
+	}
+	request := blindRequestFile{Party: party}
+	for _, r := range requests {
+		b, err := r.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		request.Requests = append(request.Requests, b)
+	}
+
+	if err := writeGobFile(*statePath, 0600, &state); err != nil {
+		return err
+	}
+	return writeGobFile(*outPath, 0644, &request)
+}
+
+func runBlindSign(args []string) error {
+	flags := flag.NewFlagSet("blind sign", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file")
+	partyName := flags.String("party", "client", "party the requests must be for; only client is signed blindly")
+	inPath := flags.String("in", "", "request file written by apsi blind request")
+	outPath := flags.String("out", "", "answer file to write (default <party>.blindsig)")
+	period := flags.Duration("period", 0, "sign for the current epoch of this length, e.g. 24h (required)")
+	pf := addPolicyFlags(flags)
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *inPath == "" {
+		return errors.New("-in is required")
+	}
+	if *period <= 0 {
+		return errors.New("-period is required")
+	}
+	if *outPath == "" {
+		*outPath = party.String() + ".blindsig"
+	}
+
+	var request blindRequestFile
+	if err := readGobFile(*inPath, &request); err != nil {
+		return err
+	}
+	if request.Party != party {
+		return fmt.Errorf("%s: requests %s authorizations, not %s", *inPath, request.Party, party)
+	}
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	authority.EnableBlindIssuance(*period)
+	requests := make([]*apsi.BlindRequest, len(request.Requests))
+	for i, b := range request.Requests {
+		requests[i], err = apsi.UnmarshalBlindRequest(authority.Pairing(), b)
+		if err != nil {
+			return fmt.Errorf("%s: request %d: %v", *inPath, i, err)
+		}
+	}
+	engine, err := pf.engine(authority)
+	if err != nil {
+		return err
+	}
+	var bk *apsi.BlindKey
+	var blindSignatures []*pbc.Element
+	if engine != nil {
+		if _, bk, blindSignatures, err = engine.AuthorizeBlindedSet(pf.request(party, nil, validity{}), requests); err != nil {
+			return err
+		}
+	} else if _, bk, blindSignatures, err = authority.AuthorizeBlindedSet(requests, party); err != nil {
+		return err
+	}
+
+	answer := blindAnswerFile{Party: party}
+	if answer.BlindKey, err = bk.MarshalBinary(); err != nil {
+		return err
+	}
+	for _, blindSignature := range blindSignatures {
+		answer.Signatures = append(answer.Signatures, blindSignature.Bytes())
+	}
+	return writeGobFile(*outPath, 0644, &answer)
+}
+
+func runBlindFinish(args []string) error {
+	flags := flag.NewFlagSet("blind finish", flag.ExitOnError)
+	statePath := flags.String("state", "", "state file written by apsi blind request")
+	inPath := flags.String("in", "", "answer file written by apsi blind sign")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	flags.Parse(args)
+
+	if *statePath == "" || *inPath == "" {
+		return errors.New("-state and -in are required")
+	}
+
+	var state blindStateFile
+	if err := readGobFile(*statePath, &state); err != nil {
+		return err
+	}
+	var answer blindAnswerFile
+	if err := readGobFile(*inPath, &answer); err != nil {
+		return err
+	}
+	if answer.Party != state.Party {
+		return fmt.Errorf("%s: answers %s requests, not %s", *inPath, answer.Party, state.Party)
+	}
+	if len(state.Set) != len(state.Factors) || len(answer.Signatures) != len(state.Set) {
+		return fmt.Errorf("%s: %v", *inPath, apsi.ErrSignatureCount)
+	}
+	if *outPath == "" {
+		*outPath = state.Party.String() + ".apsi"
+	}
+
+	pairing, pk, err := apsi.ReadPublicKey(bytes.NewReader(state.PublicKey))
+	if err != nil {
+		return fmt.Errorf("%s: %v", *statePath, err)
+	}
+	factors := make([]*apsi.BlindingFactor, len(state.Set))
+	blindSignatures := make([]*pbc.Element, len(state.Set))
+	for i, elt := range state.Set {
+		if len(state.Factors[i]) != pairing.NewZr().BytesLen() {
+			return fmt.Errorf("%s: factor %d is malformed", *statePath, i)
+		}
+		if len(answer.Signatures[i]) != int(pairing.G1Length()) {
+			return fmt.Errorf("%s: signature %d is malformed", *inPath, i)
+		}
+		factors[i] = &apsi.BlindingFactor{Element: elt, Factor: pairing.NewZr().SetBytes(state.Factors[i])}
+		blindSignatures[i] = pairing.NewG1().SetBytes(answer.Signatures[i])
+	}
+	bk, err := apsi.UnmarshalBlindKey(pairing, answer.BlindKey)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *inPath, err)
+	}
+	_, signatures, err := apsi.UnblindSet(pairing, pk, bk, factors, blindSignatures, state.Party,
+		state.Validity.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+	party, err := newPartyFile(publicKeyBytes(state.PublicKey), state.Party, state.Set, signatures,
+		state.Validity, "", nil)
+	if err != nil {
+		return err
+	}
+	party.BlindKey = answer.BlindKey
+	if err := party.write(*outPath); err != nil {
+		return err
+	}
+	return os.Remove(*statePath)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// A certificate file holds one sealed authorization certificate per
+// element. Export turns a state file into one so the authorizations can be
+// handed to another team or stored next to the data; import checks them
+// against the authority's public key and the holder's own elements and
+// turns them back into a state file.
+var certCommands = []command{
+	{"export", "write a state file's authorizations as certificates", runCertExport},
+	{"import", "build a state file from certificates and their elements", runCertImport},
+}
+
+func runCert(args []string) error {
+	return dispatch("cert", certCommands, args)
+}
+
+func runCertExport(args []string) error {
+	flags := flag.NewFlagSet("cert export", flag.ExitOnError)
+	partyName := flags.String("party", "", "party the state file is for: client or server")
+	keyPath := flags.String("key", "authority.key", "authority key file that issued the state file")
+	statePath := flags.String("state", "", "state file to export (default <party>.apsi)")
+	outPath := flags.String("out", "", "certificate file to write")
+	issuer := flags.String("issuer", "", "name of the issuing team or authority to record")
+	expires := flags.Duration("expires", 0, "how long the certificates are valid (0 for no expiry)")
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		return errors.New("-out is required")
+	}
+	if *statePath == "" {
+		*statePath = party.String() + ".apsi"
+	}
+	state, err := readPartyFile(*statePath, party)
+	if err != nil {
+		return err
+	}
+	if state.clientID != "" || state.delegation != nil {
+		return fmt.Errorf("%s: only authorizations under the authority's own key can be certified", *statePath)
+	}
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	info := apsi.CertificateInfo{
+		Issuer:  *issuer,
+		Epoch:   state.validity.Epoch,
+		Context: state.validity.Context,
+	}
+	if *expires > 0 {
+		info.Expires = time.Now().Add(*expires)
+	}
+	pairing := authority.Pairing()
+	certs := make([]*apsi.AuthorizationCertificate, len(state.set))
+	for i, elt := range state.set {
+		signature := pairing.NewG1().SetBytes(state.signatures[i].Bytes())
+		if certs[i], err = authority.Certify(elt, signature, party, info); err != nil {
+			return fmt.Errorf("%s: element %x: %v", *statePath, elt, err)
+		}
+	}
+	return writeFileWith(*outPath, 0644, func(f *os.File) error {
+		return apsi.WriteCertificates(f, certs)
+	})
+}
+
+func runCertImport(args []string) error {
+	flags := flag.NewFlagSet("cert import", flag.ExitOnError)
+	certsPath := flags.String("certs", "", "certificate file written by apsi cert export")
+	setPath := flags.String("set", "", "file of the certified elements, one hex element per line")
+	pubPath := flags.String("pub", "authority.pub", "public key file of the issuing authority")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	flags.Parse(args)
+
+	if *certsPath == "" || *setPath == "" {
+		return errors.New("-certs and -set are required")
+	}
+	public, err := ioutil.ReadFile(*pubPath)
+	if err != nil {
+		return err
+	}
+	pairing, pk, err := loadPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	certs, err := loadCertificates(*certsPath, pairing)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("%s: no certificates", *certsPath)
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+
+	// Every element needs a valid certificate, and a state file holds one
+	// party's authorizations for one epoch and context.
+	first := certs[0]
+	now := time.Now()
+	signatures := make([]*pbc.Element, len(set))
+	for i, elt := range set {
+		var cert *apsi.AuthorizationCertificate
+		for _, c := range certs {
+			if c.Opens(elt) {
+				cert = c
+				break
+			}
+		}
+		if cert == nil {
+			return fmt.Errorf("%s: no certificate for element %x", *certsPath, elt)
+		}
+		if err := cert.Verify(pairing, pk, elt, now); err != nil {
+			return fmt.Errorf("%s: element %x: %v", *certsPath, elt, err)
+		}
+		if cert.Party != first.Party || cert.Epoch != first.Epoch || cert.Context != first.Context {
+			return fmt.Errorf("%s: certificates are for different parties, epochs or contexts", *certsPath)
+		}
+		signatures[i] = cert.Signature
+	}
+	if *outPath == "" {
+		*outPath = first.Party.String() + ".apsi"
+	}
+	v := validity{Epoch: first.Epoch, Context: first.Context}
+	return writePartyFile(*outPath, publicKeyBytes(public), first.Party, set, signatures, v, "", nil)
+}
+
+func loadCertificates(path string, pairing *pbc.Pairing) ([]*apsi.AuthorizationCertificate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	certs, err := apsi.ReadCertificates(f, pairing)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return certs, nil
+}
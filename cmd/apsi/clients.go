@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+var clientsCommands = []command{
+	{"add", "give a client its own key and list it in the registry", runClientsAdd},
+	{"remove", "take a client out of the registry", runClientsRemove},
+	{"list", "print the clients in a registry", runClientsList},
+}
+
+func runClients(args []string) error {
+	return dispatch("clients", clientsCommands, args)
+}
+
+func runClientsAdd(args []string) error {
+	return updateClientRegistry("clients add", args, func(authority *apsi.Authority, registry *apsi.ClientRegistry, id apsi.ClientID) error {
+		return authority.RegisterClient(registry, id)
+	})
+}
+
+func runClientsRemove(args []string) error {
+	return updateClientRegistry("clients remove", args, func(authority *apsi.Authority, registry *apsi.ClientRegistry, id apsi.ClientID) error {
+		return authority.RemoveClient(registry, id)
+	})
+}
+
+// updateClientRegistry loads the registry, creating it if missing, applies
+// update for the client named by -id and writes it back signed.
+func updateClientRegistry(name string, args []string,
+	update func(*apsi.Authority, *apsi.ClientRegistry, apsi.ClientID) error) error {
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file written by apsi keystore create")
+	registryPath := flags.String("registry", "clients.pem", "client registry to update, created if missing")
+	id := flags.String("id", "", "client ID")
+	flags.Parse(args)
+
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	registry := authority.NewClientRegistry()
+	if _, err := os.Stat(*registryPath); err == nil {
+		if registry, err = loadClientRegistry(*registryPath, authority.Pairing()); err != nil {
+			return err
+		}
+		if !registry.Verify(authority.Pairing(), authority.PublicKey()) {
+			return fmt.Errorf("%s: %v", *registryPath, apsi.ErrRegistrySignature)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := update(authority, registry, apsi.ClientID(*id)); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := registry.Write(&out); err != nil {
+		return err
+	}
+	return replaceFile(*registryPath, out.Bytes())
+}
+
+func runClientsList(args []string) error {
+	flags := flag.NewFlagSet("clients list", flag.ExitOnError)
+	pubPath := flags.String("pub", "authority.pub", "public key file of the registry's key version")
+	registryPath := flags.String("registry", "clients.pem", "client registry written by apsi clients add")
+	flags.Parse(args)
+
+	pairing, pk, err := loadPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	registry, err := loadClientRegistry(*registryPath, pairing)
+	if err != nil {
+		return err
+	}
+	if !registry.Verify(pairing, pk) {
+		return fmt.Errorf("%s: %v", *registryPath, apsi.ErrRegistrySignature)
+	}
+	fmt.Printf("key %s, version %d\n", registry.KeyID, registry.Version)
+	for _, id := range registry.Clients() {
+		fmt.Println(id)
+	}
+	return nil
+}
+
+func loadClientRegistry(path string, pairing *pbc.Pairing) (*apsi.ClientRegistry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	registry, err := apsi.ReadClientRegistry(f, pairing)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return registry, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// A sub-authority's key file holds the root's public key, its delegation
+// chain and its secret. State files it authorizes carry the chain, and the
+// chain file lets clients use the key of a server's sub-authority.
+var delegateCommands = []command{
+	{"create", "create a sub-authority for part of the element space", runDelegateCreate},
+	{"authorize", "authorize a set with a sub-authority", runDelegateAuthorize},
+}
+
+func runDelegate(args []string) error {
+	return dispatch("delegate", delegateCommands, args)
+}
+
+func runDelegateCreate(args []string) error {
+	flags := flag.NewFlagSet("delegate create", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file to delegate from")
+	parentPath := flags.String("parent", "", "sub-authority key file to delegate from instead of -key")
+	partyName := flags.String("party", "", "party to delegate issuance for: client or server (not with -parent)")
+	prefixHex := flags.String("prefix", "", "hex prefix every element the sub-authority signs must start with")
+	expires := flags.Duration("expires", 0, "how long the delegation lasts (0 for as long as its parent)")
+	outPath := flags.String("out", "", "file to write the sub-authority's keys to")
+	chainPath := flags.String("chain", "", "file to write the delegation chain to (default the -out path with .chain)")
+	plaintext := flags.Bool("plaintext", false, "write the secret unencrypted (testing only)")
+	flags.Parse(args)
+
+	if *outPath == "" {
+		return errors.New("-out is required")
+	}
+	if *chainPath == "" {
+		*chainPath = *outPath + ".chain"
+	}
+	prefix, err := hex.DecodeString(*prefixHex)
+	if err != nil {
+		return fmt.Errorf("-prefix: %v", err)
+	}
+	scope := apsi.DelegationScope{Prefix: prefix}
+	if *expires > 0 {
+		scope.Expires = time.Now().Add(*expires).Truncate(time.Second)
+	}
+
+	var sub *apsi.SubAuthority
+	if *parentPath != "" {
+		if *partyName != "" {
+			return errors.New("-party comes from the parent with -parent")
+		}
+		parent, err := loadSubAuthority(*parentPath)
+		if err != nil {
+			return err
+		}
+		if scope.Expires.IsZero() {
+			scope.Expires = parent.Chain().Scope().Expires
+		}
+		if sub, err = parent.Delegate(scope); err != nil {
+			return err
+		}
+	} else {
+		party, err := parseParty(*partyName)
+		if err != nil {
+			return err
+		}
+		authority, err := loadAuthority(*keyPath)
+		if err != nil {
+			return err
+		}
+		if sub, err = authority.Delegate(party, scope); err != nil {
+			return err
+		}
+	}
+
+	if *plaintext {
+		err = writeFileWith(*outPath, 0600, func(f *os.File) error {
+			return sub.WriteKeys(f)
+		})
+	} else {
+		var passphrase []byte
+		if passphrase, err = readNewPassphrase("New passphrase for " + *outPath); err != nil {
+			return err
+		}
+		err = writeFileWith(*outPath, 0600, func(f *os.File) error {
+			return sub.WriteEncryptedKeys(f, passphrase, apsi.DefaultKDFParams)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileWith(*chainPath, 0644, func(f *os.File) error {
+		return sub.Chain().Write(f)
+	})
+}
+
+func runDelegateAuthorize(args []string) error {
+	flags := flag.NewFlagSet("delegate authorize", flag.ExitOnError)
+	keyPath := flags.String("key", "", "sub-authority key file written by apsi delegate create")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	if *keyPath == "" || *setPath == "" {
+		return errors.New("-key and -set are required")
+	}
+	sub, err := loadSubAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	party := sub.Chain().Party()
+	if *outPath == "" {
+		*outPath = party.String() + ".apsi"
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	_, signatures, err := sub.AuthorizeSet(set, party, v.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, sub, party, set, signatures, v, "", sub.Chain())
+}
+
+// loadSubAuthority reads a sub-authority key file, asking for the
+// passphrase if the secret is encrypted.
+func loadSubAuthority(path string) (*apsi.SubAuthority, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sub *apsi.SubAuthority
+	if apsi.IsEncryptedKeyFile(data) {
+		var passphrase []byte
+		passphrase, err = readPassphrase("Passphrase for " + path)
+		if err != nil {
+			return nil, err
+		}
+		sub, err = apsi.ReadEncryptedSubAuthority(bytes.NewReader(data), passphrase)
+	} else {
+		sub, err = apsi.ReadSubAuthority(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sub, nil
+}
+
+func loadDelegationChain(path string, pairing *pbc.Pairing) (*apsi.DelegationChain, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chain, err := apsi.ReadDelegationChain(f, pairing)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return chain, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// loadAuthority reads an authority key file, asking for the passphrase if
+// the secret key is encrypted.
+func loadAuthority(path string) (*apsi.Authority, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var authority *apsi.Authority
+	if apsi.IsEncryptedKeyFile(data) {
+		var passphrase []byte
+		passphrase, err = readPassphrase("Passphrase for " + path)
+		if err != nil {
+			return nil, err
+		}
+		authority, err = apsi.ReadEncryptedAuthority(bytes.NewReader(data), passphrase)
+	} else {
+		authority, err = apsi.ReadAuthority(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return authority, nil
+}
+
+func loadPublicKey(path string) (*pbc.Pairing, apsi.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, apsi.PublicKey{}, err
+	}
+	defer f.Close()
+
+	pairing, pk, err := apsi.ReadPublicKey(f)
+	if err != nil {
+		return nil, apsi.PublicKey{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return pairing, pk, nil
+}
+
+// createFile creates path for writing, refusing to overwrite an existing
+// file since key files are not recoverable once lost.
+func createFile(path string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func writeFileWith(path string, perm os.FileMode, write func(f *os.File) error) error {
+	f, err := createFile(path, perm)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func parseParty(s string) (apsi.Party, error) {
+	switch s {
+	case "client":
+		return apsi.ClientParty, nil
+	case "server":
+		return apsi.ServerParty, nil
+	}
+	return 0, fmt.Errorf("unknown party %q (want client or server)", s)
+}
+
+func runAuthorize(args []string) error {
+	flags := flag.NewFlagSet("authorize", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file written by apsi keystore create")
+	partyName := flags.String("party", "", "party to authorize the set for: client or server")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	clientName := flags.String("client", "", "authorize under this client's own key (see apsi clients)")
+	bindingFlags := addBindingFlags(flags)
+	pf := addPolicyFlags(flags)
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	clientID := apsi.ClientID(*clientName)
+	if clientID != "" && party != apsi.ClientParty {
+		return errors.New("-client only applies to -party client")
+	}
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	if *outPath == "" {
+		*outPath = party.String() + ".apsi"
+	}
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	if clientID != "" {
+		if authority, err = authority.ForClient(clientID); err != nil {
+			return err
+		}
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	engine, err := pf.engine(authority)
+	if err != nil {
+		return err
+	}
+	var signatures []*pbc.Element
+	if engine != nil {
+		if _, signatures, err = engine.AuthorizeSet(pf.request(party, set, v)); err != nil {
+			return err
+		}
+	} else if _, signatures, err = authority.AuthorizeSet(set, party, v.authorizeOptions()...); err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, authority, party, set, signatures, v, clientID, nil)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+var keystoreCommands = []command{
+	{"create", "create an authority and write its encrypted keys", runKeystoreCreate},
+	{"import", "encrypt a plaintext authority key file", runKeystoreImport},
+	{"unlock", "check that the passphrase opens the keystore", runKeystoreUnlock},
+	{"passwd", "change the keystore passphrase", runKeystorePasswd},
+	{"export-public", "write the public parameters only", runKeystoreExportPublic},
+	{"rotate", "replace the authority's keys with a new version", runKeystoreRotate},
+}
+
+func runKeystore(args []string) error {
+	return dispatch("keystore", keystoreCommands, args)
+}
+
+func runKeystoreCreate(args []string) error {
+	flags := flag.NewFlagSet("keystore create", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "file to write the authority's keys to")
+	pubPath := flags.String("pub", "authority.pub", "file to write the public parameters to")
+	plaintext := flags.Bool("plaintext", false, "write the secret key unencrypted (testing only)")
+	flags.Parse(args)
+
+	_, authority := apsi.NewAuthority()
+	if *plaintext {
+		if err := writeFileWith(*keyPath, 0600, func(f *os.File) error {
+			return authority.WriteKeys(f)
+		}); err != nil {
+			return err
+		}
+	} else if err := writeEncryptedAuthority(*keyPath, authority, false); err != nil {
+		return err
+	}
+	return writeFileWith(*pubPath, 0644, func(f *os.File) error {
+		return authority.WritePublicKey(f)
+	})
+}
+
+func runKeystoreImport(args []string) error {
+	flags := flag.NewFlagSet("keystore import", flag.ExitOnError)
+	inPath := flags.String("in", "", "plaintext authority key file to encrypt")
+	keyPath := flags.String("key", "authority.key", "encrypted key file to write")
+	flags.Parse(args)
+
+	if *inPath == "" {
+		return fmt.Errorf("-in is required")
+	}
+	authority, err := loadAuthority(*inPath)
+	if err != nil {
+		return err
+	}
+	return writeEncryptedAuthority(*keyPath, authority, *inPath == *keyPath)
+}
+
+func runKeystoreUnlock(args []string) error {
+	flags := flag.NewFlagSet("keystore unlock", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "encrypted authority key file")
+	flags.Parse(args)
+
+	if _, err := loadAuthority(*keyPath); err != nil {
+		return err
+	}
+	fmt.Println(*keyPath + ": unlocked; secret key matches public key")
+	return nil
+}
+
+func runKeystorePasswd(args []string) error {
+	flags := flag.NewFlagSet("keystore passwd", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "encrypted authority key file")
+	flags.Parse(args)
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	return writeEncryptedAuthority(*keyPath, authority, true)
+}
+
+func runKeystoreExportPublic(args []string) error {
+	flags := flag.NewFlagSet("keystore export-public", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file")
+	pubPath := flags.String("pub", "authority.pub", "file to write the public parameters to")
+	flags.Parse(args)
+
+	f, err := os.Open(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var public bytes.Buffer
+	if err := apsi.ExtractPublicKey(f, &public); err != nil {
+		return fmt.Errorf("%s: %v", *keyPath, err)
+	}
+	return writeFileWith(*pubPath, 0644, func(out *os.File) error {
+		_, err := out.Write(public.Bytes())
+		return err
+	})
+}
+
+// writeEncryptedAuthority asks for a new passphrase and writes the
+// authority's encrypted keys to path. With replace set, an existing file is
+// swapped out atomically so a failure never leaves a half-written keystore.
+func writeEncryptedAuthority(path string, authority *apsi.Authority, replace bool) error {
+	passphrase, err := readNewPassphrase("New passphrase for " + path)
+	if err != nil {
+		return err
+	}
+
+	var encrypted bytes.Buffer
+	if err := authority.WriteEncryptedKeys(&encrypted, passphrase, apsi.DefaultKDFParams); err != nil {
+		return err
+	}
+	if !replace {
+		return writeFileWith(path, 0600, func(f *os.File) error {
+			_, err := f.Write(encrypted.Bytes())
+			return err
+		})
+	}
+
+	return replaceFile(path, encrypted.Bytes())
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// over path, so readers see either the old contents or the new ones.
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,97 @@
+// Command apsi runs the Dual-APSI protocol implemented in package apsi.
+//
+// Usage:
+//
+//	apsi [bench] [-cpuprofile file]
+//	apsi keystore create|import|unlock|passwd|export-public|rotate [flags]
+//	apsi authorize -party client|server -set file [-key authority.key] [-out file] [-client id] [-period d | -epoch n] [-case c] [-purpose p] [-counterparty s] [-policy file -requester name -justify field=value...] [-audit file]
+//	apsi policy check -policy file [-party client|server -set file -justify field=value...]
+//	apsi request submit|list|show|approve|deny|fetch [-queue requests] [flags]
+//	apsi authd -grant name=client|server|both... [-key authority.key] [-addr :7400] [-cert authd.crt -tls-key authd.key -ca ca.crt] [-policy file] [-timeout d] [-audit file]
+//	apsi issue -party client|server -set file [-authd localhost:7400] [-cert party.crt -tls-key party.key -ca ca.crt] [-pub authority.pub] [-out file] [-period d | -epoch n] [-case c] [-purpose p] [-counterparty s] [-justify field=value...]
+//	apsi reissue -party client|server -state file -out file [-key authority.key] [-keyring keyring.pem] [-revoked file] [-audit file]
+//	apsi backup split|verify|recover [-key authority.key] [-pub authority.pub] [-t 2 -n 3] [-share file...]
+//	apsi threshold setup|enroll|deal|finish|split|sign|combine [flags]
+//	apsi blind request|sign|finish [flags]
+//	apsi multi setup|create|join|authorize [flags]
+//	apsi delegate create|authorize [flags]
+//	apsi cert export|import [flags]
+//	apsi wallet add|status|export|prune -party client|server [flags]
+//	apsi clients add|remove|list -id client [-key authority.key] [-registry clients.pem]
+//	apsi audit verify|head -log file [-head file] [-expect seq:hash]
+//	apsi revoke -party client|server -element hex | -set file [-key authority.key] [-list revoked.pem] [-epoch n] [-audit file]
+//	apsi serve -state server.apsi... [-network tcp|unix] [-addr addr] [-revoked file] [-keyring file] [-clients file...] [-timeout d] [-audit file]
+//	apsi query -state client.apsi... [-network tcp|unix] [-addr addr] [-revoked file] [-keyring file] [-server-chain file] [-timeout d] [-audit file]
+//
+// With no command, apsi runs the benchmarks.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Nik-U/pbc"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"bench", "run the benchmark table", runBench},
+	{"keystore", "create and manage the authority's encrypted keys", runKeystore},
+	{"authorize", "authorize a set for one party with a stored authority", runAuthorize},
+	{"policy", "check authorization policies", runPolicy},
+	{"request", "queue sets for a reviewer to approve before signing", runRequest},
+	{"authd", "sign sets for parties over mutual TLS", runAuthd},
+	{"issue", "have a set authorized by a remote apsi authd", runIssue},
+	{"reissue", "authorize a state file again under the current key version", runReissue},
+	{"backup", "back up the authority's key in shares and recover it", runBackup},
+	{"threshold", "issue authorizations with a t-of-n split authority", runThreshold},
+	{"blind", "authorize a set without showing it to the authority", runBlind},
+	{"multi", "issue with separate client and server authorities", runMulti},
+	{"delegate", "delegate issuance for part of the element space", runDelegate},
+	{"cert", "export and import authorization certificates", runCert},
+	{"wallet", "keep a party's certificates and export sets from them", runWallet},
+	{"clients", "manage per-client keys and the client registry", runClients},
+	{"revoke", "add authorizations to the signed revocation list", runRevoke},
+	{"audit", "verify the audit log of authorizations and sessions", runAudit},
+	{"serve", "answer client sessions for a server set", runServe},
+	{"query", "run one session against a server and print the intersection", runQuery},
+}
+
+// dispatch runs the command in cmds named by args[0], printing usage for
+// the command group prefix if there is none.
+func dispatch(prefix string, cmds []command, args []string) error {
+	if len(args) > 0 {
+		for _, cmd := range cmds {
+			if cmd.name == args[0] {
+				return cmd.run(args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\n", prefix)
+	for _, cmd := range cmds {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+	return nil
+}
+
+func main() {
+	pbc.SetLogging(false)
+
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"bench"}, args...)
+	}
+
+	if err := dispatch("apsi", commands, args); err != nil {
+		fmt.Fprintf(os.Stderr, "apsi %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
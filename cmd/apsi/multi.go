@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// With independent authorities, the client's and the server's authority
+// each keep their own key file. They share a setup file, publish their party
+// keys, and anyone can join the two into the authority.pub that serve and
+// query take.
+var multiCommands = []command{
+	{"setup", "generate the parameters both authorities share", runMultiSetup},
+	{"create", "create the authority for one side", runMultiCreate},
+	{"join", "join the two party keys into one public key file", runMultiJoin},
+	{"authorize", "authorize a set with one side's authority", runMultiAuthorize},
+}
+
+func runMulti(args []string) error {
+	return dispatch("multi", multiCommands, args)
+}
+
+func runMultiSetup(args []string) error {
+	flags := flag.NewFlagSet("multi setup", flag.ExitOnError)
+	label := flags.String("label", "", "public label P is derived from, e.g. the deployment's name")
+	outPath := flags.String("out", "setup.pem", "setup file to write")
+	flags.Parse(args)
+
+	if *label == "" {
+		return errors.New("-label is required")
+	}
+	setup, err := apsi.NewSharedSetup(*label)
+	if err != nil {
+		return err
+	}
+	return writeFileWith(*outPath, 0644, func(f *os.File) error {
+		return setup.Write(f)
+	})
+}
+
+func runMultiCreate(args []string) error {
+	flags := flag.NewFlagSet("multi create", flag.ExitOnError)
+	setupFile := flags.String("setup", "setup.pem", "setup file written by apsi multi setup")
+	partyName := flags.String("party", "", "side the authority issues for: client or server")
+	keyPath := flags.String("key", "", "file to write the authority's keys to (default <party>-authority.key)")
+	pubPath := flags.String("pub", "", "file to write the party key to (default <party>-authority.pub)")
+	plaintext := flags.Bool("plaintext", false, "write the secret unencrypted (testing only)")
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		*keyPath = party.String() + "-authority.key"
+	}
+	if *pubPath == "" {
+		*pubPath = party.String() + "-authority.pub"
+	}
+	f, err := os.Open(*setupFile)
+	if err != nil {
+		return err
+	}
+	setup, err := apsi.ReadSharedSetup(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", *setupFile, err)
+	}
+
+	_, authority, err := apsi.NewPartyAuthority(setup, party)
+	if err != nil {
+		return err
+	}
+	if *plaintext {
+		err = writeFileWith(*keyPath, 0600, func(f *os.File) error {
+			return authority.WriteKeys(f)
+		})
+	} else {
+		var passphrase []byte
+		if passphrase, err = readNewPassphrase("New passphrase for " + *keyPath); err != nil {
+			return err
+		}
+		err = writeFileWith(*keyPath, 0600, func(f *os.File) error {
+			return authority.WriteEncryptedKeys(f, passphrase, apsi.DefaultKDFParams)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileWith(*pubPath, 0644, func(f *os.File) error {
+		return authority.PublicKey().Write(f)
+	})
+}
+
+func runMultiJoin(args []string) error {
+	flags := flag.NewFlagSet("multi join", flag.ExitOnError)
+	clientPath := flags.String("client", "client-authority.pub", "client authority's party key")
+	serverPath := flags.String("server", "server-authority.pub", "server authority's party key")
+	outPath := flags.String("out", "authority.pub", "public key file to write")
+	flags.Parse(args)
+
+	client, err := loadPartyPublicKey(*clientPath)
+	if err != nil {
+		return err
+	}
+	server, err := loadPartyPublicKey(*serverPath)
+	if err != nil {
+		return err
+	}
+	jpk, err := apsi.JoinPublicKeys(client, server)
+	if err != nil {
+		return err
+	}
+	return writeFileWith(*outPath, 0644, func(f *os.File) error {
+		return jpk.WritePublicKey(f)
+	})
+}
+
+func runMultiAuthorize(args []string) error {
+	flags := flag.NewFlagSet("multi authorize", flag.ExitOnError)
+	keyPath := flags.String("key", "", "party authority key file written by apsi multi create")
+	pubPath := flags.String("pub", "authority.pub", "public key file written by apsi multi join")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	if *keyPath == "" || *setPath == "" {
+		return errors.New("-key and -set are required")
+	}
+	authority, err := loadPartyAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	party := authority.Party()
+	if *outPath == "" {
+		*outPath = party.String() + ".apsi"
+	}
+
+	// The state file carries the joint key, so check that it is this
+	// authority's before handing it out.
+	public, err := ioutil.ReadFile(*pubPath)
+	if err != nil {
+		return err
+	}
+	_, pk, err := apsi.ReadPublicKey(bytes.NewReader(public))
+	if err != nil {
+		return fmt.Errorf("%s: %v", *pubPath, err)
+	}
+	key := pk.XP
+	if party == apsi.ServerParty {
+		key = pk.YP
+	}
+	own := authority.PublicKey()
+	if !bytes.Equal(pk.P.Bytes(), own.P.Bytes()) || !bytes.Equal(key.Bytes(), own.Key.Bytes()) {
+		return fmt.Errorf("%s: does not contain the key in %s", *pubPath, *keyPath)
+	}
+
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	_, signatures, err := authority.AuthorizeSet(set, party, v.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, publicKeyBytes(public), party, set, signatures, v, "", nil)
+}
+
+func loadPartyPublicKey(path string) (*apsi.PartyPublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ppk, err := apsi.ReadPartyPublicKey(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ppk, nil
+}
+
+// loadPartyAuthority reads a party authority key file, asking for the
+// passphrase if the secret is encrypted.
+func loadPartyAuthority(path string) (*apsi.PartyAuthority, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var authority *apsi.PartyAuthority
+	if apsi.IsEncryptedKeyFile(data) {
+		var passphrase []byte
+		passphrase, err = readPassphrase("Passphrase for " + path)
+		if err != nil {
+			return nil, err
+		}
+		authority, err = apsi.ReadEncryptedPartyAuthority(bytes.NewReader(data), passphrase)
+	} else {
+		authority, err = apsi.ReadPartyAuthority(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return authority, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// partyFile is everything one party needs to run the protocol on its own:
+// the authority's public key file, its signed set, the epoch the signatures
+// belong to, for a client with its own key its client ID, for a set
+// signed by a sub-authority the encoded delegation chain, and for a set
+// issued blindly the encoded blind key.
+type partyFile struct {
+	Party      apsi.Party
+	PublicKey  []byte
+	Set        apsi.RawElementSlice
+	Signatures [][]byte
+	Validity   validity
+	ClientID   apsi.ClientID
+	Delegation []byte
+	BlindKey   []byte
+}
+
+// partyState is a decoded partyFile.
+type partyState struct {
+	pairing    *pbc.Pairing
+	pk         apsi.PublicKey
+	set        apsi.RawElementSlice
+	signatures []*pbc.Element
+	validity   validity
+	clientID   apsi.ClientID
+
+	// delegation and blindKey are decoded only when needed, in whatever
+	// pairing the state has moved to by then.
+	delegation []byte
+	blindKey   []byte
+}
+
+// validity is the epoch and context a set of authorizations was issued
+// for. Period is zero when the epoch was given by number, in which case
+// nobody can tell when it ends.
+type validity struct {
+	Epoch   apsi.Epoch
+	Period  time.Duration
+	Context apsi.Context
+}
+
+// addBindingFlags adds -epoch and -period along with the context flags
+// -case, -purpose and -counterparty to flags, and returns a function that
+// reads them once flags have been parsed.
+func addBindingFlags(flags *flag.FlagSet) func() validity {
+	epoch := flags.Uint64("epoch", 0, "epoch number to bind the authorizations to (0 for none)")
+	period := flags.Duration("period", 0, "bind the authorizations to the current epoch of this length, e.g. 24h")
+	caseID := flags.String("case", "", "case the authorizations are granted for")
+	purpose := flags.String("purpose", "", "purpose the authorizations may be used for")
+	counterparty := flags.String("counterparty", "", "party the authorizations may be used with, e.g. a server's name")
+	return func() validity {
+		v := validity{Context: apsi.Context{Case: *caseID, Purpose: *purpose, Counterparty: *counterparty}}
+		if *epoch != 0 {
+			v.Epoch, v.Period = apsi.Epoch(*epoch), *period
+		} else if *period > 0 {
+			v.Epoch, v.Period = apsi.EpochOf(time.Now(), *period), *period
+		}
+		return v
+	}
+}
+
+func (v validity) authorizeOptions() []apsi.AuthorizeOption {
+	var opts []apsi.AuthorizeOption
+	if v.Epoch != apsi.NoEpoch {
+		opts = append(opts, apsi.AtEpoch(v.Epoch))
+	}
+	if v.Context != (apsi.Context{}) {
+		opts = append(opts, apsi.InContext(v.Context))
+	}
+	return opts
+}
+
+func (v validity) interactionOptions() []apsi.InteractionOption {
+	var opts []apsi.InteractionOption
+	if v.Epoch != apsi.NoEpoch {
+		opts = append(opts, apsi.WithEpoch(v.Epoch))
+	}
+	if v.Epoch != apsi.NoEpoch && v.Period > 0 {
+		opts = append(opts, apsi.WithEpochPeriod(v.Period))
+	}
+	if v.Context != (apsi.Context{}) {
+		opts = append(opts, apsi.WithContext(v.Context))
+	}
+	return opts
+}
+
+// check fails if the authorizations belong to a period other than the
+// current one.
+func (v validity) check(now time.Time) error {
+	if v.Period <= 0 {
+		return nil
+	}
+	current := apsi.EpochOf(now, v.Period)
+	if current > v.Epoch {
+		return fmt.Errorf("authorizations for epoch %d have expired (now in epoch %d)", v.Epoch, current)
+	}
+	if current < v.Epoch {
+		return fmt.Errorf("authorizations for epoch %d are not valid yet (now in epoch %d)", v.Epoch, current)
+	}
+	return nil
+}
+
+// publicKeyWriter is an authority, or a threshold public key standing in for
+// one.
+type publicKeyWriter interface {
+	WritePublicKey(w io.Writer) error
+}
+
+func writePartyFile(path string, authority publicKeyWriter, party apsi.Party,
+	set apsi.RawElementSlice, signatures []*pbc.Element, v validity, clientID apsi.ClientID,
+	chain *apsi.DelegationChain) error {
+
+	state, err := newPartyFile(authority, party, set, signatures, v, clientID, chain)
+	if err != nil {
+		return err
+	}
+	return state.write(path)
+}
+
+func newPartyFile(authority publicKeyWriter, party apsi.Party,
+	set apsi.RawElementSlice, signatures []*pbc.Element, v validity, clientID apsi.ClientID,
+	chain *apsi.DelegationChain) (*partyFile, error) {
+
+	var publicKey bytes.Buffer
+	if err := authority.WritePublicKey(&publicKey); err != nil {
+		return nil, err
+	}
+	state := &partyFile{
+		Party:     party,
+		PublicKey: publicKey.Bytes(),
+		Set:       set,
+		Validity:  v,
+		ClientID:  clientID,
+	}
+	if chain != nil {
+		var err error
+		if state.Delegation, err = chain.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	for _, signature := range signatures {
+		state.Signatures = append(state.Signatures, signature.Bytes())
+	}
+	return state, nil
+}
+
+func (state *partyFile) write(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(state); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readPartyFile(path string, party apsi.Party) (*partyState, error) {
+	var state partyFile
+	if err := readGobFile(path, &state); err != nil {
+		return nil, err
+	}
+	if state.Party != party {
+		return nil, fmt.Errorf("%s: holds a %s set, want %s", path, state.Party, party)
+	}
+	if len(state.Set) != len(state.Signatures) {
+		return nil, fmt.Errorf("%s: %v", path, apsi.ErrSignatureCount)
+	}
+
+	pairing, pk, err := apsi.ReadPublicKey(bytes.NewReader(state.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	signatures := make([]*pbc.Element, len(state.Signatures))
+	for i, b := range state.Signatures {
+		if len(b) != int(pairing.G1Length()) {
+			return nil, fmt.Errorf("%s: signature %d is malformed", path, i)
+		}
+		signatures[i] = pairing.NewG1().SetBytes(b)
+	}
+	return &partyState{
+		pairing:    pairing,
+		pk:         pk,
+		set:        state.Set,
+		signatures: signatures,
+		validity:   state.Validity,
+		clientID:   state.ClientID,
+		delegation: state.Delegation,
+		blindKey:   state.BlindKey,
+	}, nil
+}
+
+// interactionOptions returns the options a party runs its sessions with:
+// its epoch, its client ID, its delegation chain or blind key, and the
+// revocation list at revokedPath if one is given.
+func (state *partyState) interactionOptions(revokedPath string) ([]apsi.InteractionOption, error) {
+	opts := state.validity.interactionOptions()
+	if state.clientID != "" {
+		opts = append(opts, apsi.AsClient(state.clientID))
+	}
+	opts, err := state.appendKeyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if revokedPath == "" {
+		return opts, nil
+	}
+	list, err := loadRevocationList(revokedPath, state.pairing, state.pk)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, apsi.WithRevocationList(list)), nil
+}
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var statePaths, registryPaths fileList
+	flags.Var(&statePaths, "state", "server state written by apsi authorize (default server.apsi); repeat for each key version, preferred first")
+	network := flags.String("network", "tcp", "transport: tcp or unix")
+	addr := flags.String("addr", ":7000", "address to listen on (socket path for unix)")
+	revokedPath := flags.String("revoked", "", "revocation list written by apsi revoke")
+	keyringPath := flags.String("keyring", "", "keyring written by apsi keystore rotate")
+	flags.Var(&registryPaths, "clients", "client registry written by apsi clients; repeat for each key version")
+	timeout := flags.Duration("timeout", apsi.DefaultFrameTimeout, "how long to wait for a client to send or take each message")
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	states, err := loadParties(statePaths, "server.apsi", apsi.ServerParty, *keyringPath)
+	if err != nil {
+		return err
+	}
+	state := states[0]
+	opts, err := state.interactionOptions(*revokedPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range registryPaths {
+		registry, err := loadClientRegistry(path, state.pairing)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, apsi.WithClientRegistry(registry))
+	}
+	if opts, err = appendDelegations(opts, states[1:]); err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		opts = append(opts, apsi.WithAuditLog(auditLog))
+	}
+	server, err := apsi.NewServer(state.pairing, state.pk, state.set, state.signatures, opts...)
+	if err != nil {
+		return err
+	}
+	for _, other := range states[1:] {
+		if err := server.AddKeyVersion(other.pk, other.set, other.signatures); err != nil {
+			return err
+		}
+	}
+	server.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+	server.Timeout = *timeout
+
+	transport, err := apsi.NewTransport(*network, *addr)
+	if err != nil {
+		return err
+	}
+	listener, err := transport.Listen()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	log.Printf("serving %d elements on %s %s", len(state.set), *network, listener.Addr())
+	return server.Serve(listener)
+}
+
+func runQuery(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	var statePaths, chainPaths fileList
+	flags.Var(&statePaths, "state", "client state written by apsi authorize (default client.apsi); repeat for each key version, preferred first")
+	network := flags.String("network", "tcp", "transport: tcp or unix")
+	addr := flags.String("addr", "localhost:7000", "server address (socket path for unix)")
+	revokedPath := flags.String("revoked", "", "revocation list written by apsi revoke")
+	keyringPath := flags.String("keyring", "", "keyring written by apsi keystore rotate")
+	flags.Var(&chainPaths, "server-chain", "delegation chain of the sub-authority that signed the server's set")
+	timeout := flags.Duration("timeout", apsi.DefaultFrameTimeout, "how long to wait for the server to send or take each message")
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	states, err := loadParties(statePaths, "client.apsi", apsi.ClientParty, *keyringPath)
+	if err != nil {
+		return err
+	}
+	state := states[0]
+	opts, err := state.interactionOptions(*revokedPath)
+	if err != nil {
+		return err
+	}
+	if opts, err = appendDelegations(opts, states[1:]); err != nil {
+		return err
+	}
+	for _, path := range chainPaths {
+		chain, err := loadDelegationChain(path, state.pairing)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, apsi.WithDelegation(chain))
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		opts = append(opts, apsi.WithAuditLog(auditLog))
+	}
+	client, err := apsi.NewClient(state.pairing, state.pk, state.set, state.signatures, opts...)
+	if err != nil {
+		return err
+	}
+	for _, other := range states[1:] {
+		if err := client.AddKeyVersion(other.pk, other.set, other.signatures); err != nil {
+			return err
+		}
+	}
+	client.Timeout = *timeout
+
+	transport, err := apsi.NewTransport(*network, *addr)
+	if err != nil {
+		return err
+	}
+	conn, err := transport.Dial()
+	if err != nil {
+		return err
+	}
+	_, intersection, err := client.Query(conn)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(intersection)
+	for _, elt := range intersection {
+		fmt.Println(formatElement(elt))
+	}
+	return nil
+}
+
+// appendKeyOptions adds the state's delegation chain and blind key to
+// opts.
+func (state *partyState) appendKeyOptions(opts []apsi.InteractionOption) ([]apsi.InteractionOption, error) {
+	if state.delegation != nil {
+		chain, err := apsi.UnmarshalDelegationChain(state.pairing, state.delegation)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, apsi.WithDelegation(chain))
+	}
+	if state.blindKey != nil {
+		bk, err := apsi.UnmarshalBlindKey(state.pairing, state.blindKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, apsi.WithBlindKey(bk))
+	}
+	return opts, nil
+}
+
+// appendDelegations adds the delegation chains and blind keys of a party's
+// other state files to opts. Each only applies to the key version it is
+// for.
+func appendDelegations(opts []apsi.InteractionOption, states []*partyState) ([]apsi.InteractionOption, error) {
+	for _, state := range states {
+		var err error
+		if opts, err = state.appendKeyOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
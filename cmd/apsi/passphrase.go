@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// passphraseEnv and newPassphraseEnv let scripts supply keystore
+// passphrases without a terminal. newPassphraseEnv is only consulted when a
+// new passphrase is being set, and falls back to passphraseEnv.
+const (
+	passphraseEnv    = "APSI_PASSPHRASE"
+	newPassphraseEnv = "APSI_NEW_PASSPHRASE"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase reads a passphrase from $APSI_PASSPHRASE, the terminal
+// (without echo), or the next line of stdin, in that order.
+func readPassphrase(prompt string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+	return promptPassphrase(prompt)
+}
+
+// readNewPassphrase is readPassphrase for a passphrase being set. On a
+// terminal it asks twice.
+func readNewPassphrase(prompt string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(newPassphraseEnv); ok {
+		return checkNewPassphrase([]byte(passphrase))
+	}
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return checkNewPassphrase([]byte(passphrase))
+	}
+
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return checkNewPassphrase(passphrase)
+	}
+
+	confirm, err := promptPassphrase("Repeat " + strings.ToLower(prompt[:1]) + prompt[1:])
+	if err != nil {
+		return nil, err
+	}
+	if string(confirm) != string(passphrase) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return checkNewPassphrase(passphrase)
+}
+
+func checkNewPassphrase(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt+": ")
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// A policy file holds the rules apsi authorize -policy checks before it
+// signs anything; see package apsi for the format. Quota usage is kept
+// next to the policy in its .usage file so that it adds up across runs
+// and across commands running at once, per requester: -requester names who asked for a local request, authd
+// names each peer by its certificate, and the queue by who submitted.
+var policyCommands = []command{
+	{"check", "check a policy file, and a request against it", runPolicyCheck},
+}
+
+func runPolicy(args []string) error {
+	return dispatch("policy", policyCommands, args)
+}
+
+// justification is a repeatable -justify field=value flag.
+type justification map[string]string
+
+func (j justification) String() string {
+	fields := make([]string, 0, len(j))
+	for field, value := range j {
+		fields = append(fields, field+"="+value)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+func (j justification) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q is not field=value", s)
+	}
+	j[s[:i]] = s[i+1:]
+	return nil
+}
+
+// policyFlags are the flags that put a request under a policy.
+type policyFlags struct {
+	policy    *string
+	usage     *string
+	requester *string
+	justified justification
+}
+
+func addPolicyFlags(flags *flag.FlagSet) *policyFlags {
+	pf := &policyFlags{
+		policy:    flags.String("policy", "", "policy file to check the request against before signing"),
+		usage:     flags.String("usage", "", "file keeping quota usage across runs (default the -policy path with .usage)"),
+		requester: flags.String("requester", "local", "whose quota the request counts against"),
+		justified: make(justification),
+	}
+	flags.Var(pf.justified, "justify", "justification field=value for the policy (repeatable)")
+	return pf
+}
+
+func (pf *policyFlags) usagePath() string {
+	if *pf.usage != "" {
+		return *pf.usage
+	}
+	return *pf.policy + ".usage"
+}
+
+// engine returns a policy engine for authority under the -policy file,
+// keeping its usage in the usage file, or nil without -policy.
+func (pf *policyFlags) engine(authority *apsi.Authority) (*apsi.PolicyEngine, error) {
+	if *pf.policy == "" {
+		if len(pf.justified) > 0 {
+			return nil, errors.New("-justify needs -policy")
+		}
+		return nil, nil
+	}
+	policy, err := apsi.LoadPolicy(*pf.policy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", *pf.policy, err)
+	}
+	engine := apsi.NewPolicyEngine(authority, policy)
+	if err := engine.PersistUsage(pf.usagePath()); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// request returns the request for set under the flags.
+func (pf *policyFlags) request(party apsi.Party, set apsi.RawElementSlice, v validity) *apsi.AuthorizationRequest {
+	return &apsi.AuthorizationRequest{
+		Requester:     *pf.requester,
+		Party:         party,
+		Elements:      set,
+		Justification: pf.justified,
+		Options:       v.authorizeOptions(),
+	}
+}
+
+func runPolicyCheck(args []string) error {
+	flags := flag.NewFlagSet("policy check", flag.ExitOnError)
+	pf := addPolicyFlags(flags)
+	partyName := flags.String("party", "", "party of a request to check: client or server")
+	setPath := flags.String("set", "", "file of the request's elements, one hex element per line")
+	flags.Parse(args)
+
+	if *pf.policy == "" {
+		return errors.New("-policy is required")
+	}
+	engine, err := pf.engine(nil)
+	if err != nil {
+		return err
+	}
+	if *partyName == "" && *setPath == "" {
+		fmt.Printf("%s: ok\n", *pf.policy)
+		return nil
+	}
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	if err := engine.Check(pf.request(party, set, validity{}), time.Now()); err != nil {
+		denied, ok := err.(*apsi.PolicyError)
+		if !ok {
+			return err
+		}
+		for _, d := range denied.Denials {
+			if d.Index >= 0 {
+				fmt.Printf("denied %s %x: %s\n", d.Rule, set[d.Index], d.Detail)
+			} else {
+				fmt.Printf("denied %s: %s\n", d.Rule, d.Detail)
+			}
+		}
+		return fmt.Errorf("%d reasons to deny the request", len(denied.Denials))
+	}
+	fmt.Println("allowed")
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// The request queue is a directory the requesters and the authority share.
+// A requester submits a set and later fetches a state file for whatever
+// part of it was approved; a reviewer lists, approves and denies requests
+// with the authority's key.
+var requestCommands = []command{
+	{"submit", "queue a set for the authority to approve", runRequestSubmit},
+	{"list", "list queued requests", runRequestList},
+	{"show", "show one request with its elements", runRequestShow},
+	{"approve", "approve all or part of a request and sign it", runRequestApprove},
+	{"deny", "deny a request", runRequestDeny},
+	{"fetch", "write a state file from an approved request", runRequestFetch},
+}
+
+func runRequest(args []string) error {
+	return dispatch("request", requestCommands, args)
+}
+
+func addQueueFlag(flags *flag.FlagSet) *string {
+	return flags.String("queue", "requests", "request queue directory")
+}
+
+func openRequestQueue(dir string) (*apsi.RequestQueue, error) {
+	queue, err := apsi.OpenRequestQueue(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", dir, err)
+	}
+	return queue, nil
+}
+
+func runRequestSubmit(args []string) error {
+	flags := flag.NewFlagSet("request submit", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	partyName := flags.String("party", "", "party to authorize the set for: client or server")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	requester := flags.String("requester", "", "who is asking, for the reviewer")
+	justified := make(justification)
+	flags.Var(justified, "justify", "justification field=value for the reviewer (repeatable)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	id, err := queue.Submit(apsi.RequestSubmission{
+		Requester:     *requester,
+		Party:         party,
+		Elements:      set,
+		Justification: justified,
+		Epoch:         v.Epoch,
+		Context:       v.Context,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func runRequestList(args []string) error {
+	flags := flag.NewFlagSet("request list", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	all := flags.Bool("all", false, "include decided requests")
+	flags.Parse(args)
+
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	reqs, err := queue.List(*all)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		fmt.Printf("%s  %-18s  %s  %d elements  %s  %s  %s\n", req.ID, req.Status, req.Party,
+			len(req.Elements), req.Submitted.Format("2006-01-02 15:04"), req.Requester, justification(req.Justification))
+	}
+	return nil
+}
+
+func runRequestShow(args []string) error {
+	flags := flag.NewFlagSet("request show", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	id := flags.String("id", "", "request to show")
+	flags.Parse(args)
+
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	req, err := queue.Get(apsi.RequestID(*id))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("request    %s\n", req.ID)
+	fmt.Printf("status     %s\n", req.Status)
+	fmt.Printf("party      %s\n", req.Party)
+	fmt.Printf("requester  %s\n", req.Requester)
+	fmt.Printf("submitted  %s\n", req.Submitted.Format("2006-01-02 15:04:05"))
+	fields := make([]string, 0, len(req.Justification))
+	for field := range req.Justification {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Printf("justify    %s=%s\n", field, req.Justification[field])
+	}
+	if req.Epoch != apsi.NoEpoch {
+		fmt.Printf("epoch      %d\n", req.Epoch)
+	}
+	if req.Context != (apsi.Context{}) {
+		fmt.Printf("context    case=%q purpose=%q counterparty=%q\n", req.Context.Case, req.Context.Purpose, req.Context.Counterparty)
+	}
+	if req.Status != apsi.RequestPending {
+		fmt.Printf("decided    %s by %s\n", req.Decided.Format("2006-01-02 15:04:05"), req.Reviewer)
+	}
+	if req.Reason != "" {
+		fmt.Printf("reason     %s\n", req.Reason)
+	}
+	approved := make(map[int]bool, len(req.Approved))
+	for _, i := range req.Approved {
+		approved[i] = true
+	}
+	for i, elt := range req.Elements {
+		mark := " "
+		if approved[i] {
+			mark = "+"
+		}
+		fmt.Printf("%s %s\n", mark, formatElement(elt))
+	}
+	return nil
+}
+
+func runRequestApprove(args []string) error {
+	flags := flag.NewFlagSet("request approve", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	id := flags.String("id", "", "request to approve")
+	keyPath := flags.String("key", "authority.key", "authority key file written by apsi keystore create")
+	reviewer := flags.String("reviewer", "", "who approved the request")
+	onlyPath := flags.String("only", "", "file of the elements to approve, if not all of them")
+	pf := addPolicyFlags(flags)
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	if *id == "" || *reviewer == "" {
+		return errors.New("-id and -reviewer are required")
+	}
+	if len(pf.justified) > 0 {
+		return errors.New("the justification comes from the request, not -justify")
+	}
+	if *pf.requester != "local" {
+		return errors.New("the quota is the submitter's, not -requester")
+	}
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	req, err := queue.Get(apsi.RequestID(*id))
+	if err != nil {
+		return err
+	}
+	var indices []int
+	if *onlyPath != "" {
+		only, err := readSetFile(*onlyPath)
+		if err != nil {
+			return err
+		}
+		index := make(map[apsi.RawElement]int, len(req.Elements))
+		for i, elt := range req.Elements {
+			index[elt] = i
+		}
+		for _, elt := range only {
+			i, ok := index[elt]
+			if !ok {
+				return fmt.Errorf("%s: %s is not in request %s", *onlyPath, formatElement(elt), req.ID)
+			}
+			indices = append(indices, i)
+		}
+	}
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	engine, err := pf.engine(authority)
+	if err != nil {
+		return err
+	}
+	if engine == nil {
+		return queue.Approve(req.ID, authority, *reviewer, indices)
+	}
+	return queue.Approve(req.ID, engine, *reviewer, indices)
+}
+
+func runRequestDeny(args []string) error {
+	flags := flag.NewFlagSet("request deny", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	id := flags.String("id", "", "request to deny")
+	reviewer := flags.String("reviewer", "", "who denied the request")
+	reason := flags.String("reason", "", "why, for the requester")
+	flags.Parse(args)
+
+	if *id == "" || *reviewer == "" || strings.TrimSpace(*reason) == "" {
+		return errors.New("-id, -reviewer and -reason are required")
+	}
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	return queue.Deny(apsi.RequestID(*id), *reviewer, *reason)
+}
+
+func runRequestFetch(args []string) error {
+	flags := flag.NewFlagSet("request fetch", flag.ExitOnError)
+	queueDir := addQueueFlag(flags)
+	id := flags.String("id", "", "request to fetch")
+	pubPath := flags.String("pub", "authority.pub", "public key file of the authority")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	remove := flags.Bool("remove", false, "remove the request from the queue once fetched")
+	flags.Parse(args)
+
+	queue, err := openRequestQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	req, err := queue.Get(apsi.RequestID(*id))
+	if err != nil {
+		return err
+	}
+	if req.Status == apsi.RequestDenied {
+		return fmt.Errorf("request %s was denied by %s: %s", req.ID, req.Reviewer, req.Reason)
+	}
+	public, err := ioutil.ReadFile(*pubPath)
+	if err != nil {
+		return err
+	}
+	pairing, pk, err := loadPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	set, signatures, err := req.Signatures(pairing, pk)
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		*outPath = req.Party.String() + ".apsi"
+	}
+	v := validity{Epoch: req.Epoch, Context: req.Context}
+	if err := writePartyFile(*outPath, publicKeyBytes(public), req.Party, set, signatures, v, "", nil); err != nil {
+		return err
+	}
+	if req.Status == apsi.RequestPartial {
+		fmt.Printf("%d of %d elements were approved\n", len(set), len(req.Elements))
+	}
+	if *remove {
+		return queue.Remove(req.ID)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+func runRevoke(args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file written by apsi keystore create")
+	listPath := flags.String("list", "revoked.pem", "revocation list to update, created if missing")
+	partyName := flags.String("party", "", "party the authorizations were issued for: client or server")
+	eltHex := flags.String("element", "", "hex element whose authorization to revoke")
+	setPath := flags.String("set", "", "file of elements whose authorizations to revoke, one hex element per line")
+	bindingFlags := addBindingFlags(flags)
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	var set apsi.RawElementSlice
+	switch {
+	case *eltHex != "" && *setPath != "":
+		return errors.New("-element and -set are mutually exclusive")
+	case *eltHex != "":
+		elt, err := parseElement(*eltHex)
+		if err != nil {
+			return err
+		}
+		set = apsi.RawElementSlice{elt}
+	case *setPath != "":
+		if set, err = readSetFile(*setPath); err != nil {
+			return err
+		}
+	default:
+		return errors.New("-element or -set is required")
+	}
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	list := apsi.NewRevocationList()
+	if _, err := os.Stat(*listPath); err == nil {
+		list, err = loadRevocationList(*listPath, authority.Pairing(), authority.PublicKey())
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := authority.RevokeSet(list, set, party, bindingFlags().authorizeOptions()...); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := list.Write(&out); err != nil {
+		return err
+	}
+	return replaceFile(*listPath, out.Bytes())
+}
+
+func loadRevocationList(path string, pairing *pbc.Pairing, pk apsi.PublicKey) (*apsi.RevocationList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list, err := apsi.ReadRevocationList(f, pairing, pk)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return list, nil
+}
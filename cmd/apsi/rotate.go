@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// Rotation replaces the authority's key file with a new version and records
+// both versions in a keyring file, which parties use to tell which of their
+// state files are still accepted. Parties then get their sets re-issued
+// under the new version while the old one overlaps.
+
+func runKeystoreRotate(args []string) error {
+	flags := flag.NewFlagSet("keystore rotate", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file to replace with the new version")
+	pubPath := flags.String("pub", "authority.pub", "public key file to replace with the new version")
+	keyringPath := flags.String("keyring", "keyring.pem", "keyring to update, created if missing")
+	listPath := flags.String("list", "", "revocation list to sign again under the new version")
+	overlap := flags.Duration("overlap", 7*24*time.Hour, "how long the old version stays accepted")
+	flags.Parse(args)
+
+	data, err := ioutil.ReadFile(*keyPath)
+	if err != nil {
+		return err
+	}
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	ring := apsi.NewKeyring(authority)
+	if _, err := os.Stat(*keyringPath); err == nil {
+		if ring, err = loadKeyring(*keyringPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	var list *apsi.RevocationList
+	if *listPath != "" {
+		if list, err = loadRevocationList(*listPath, authority.Pairing(), authority.PublicKey()); err != nil {
+			return err
+		}
+	}
+
+	_, next, err := authority.Rotate(ring, *overlap)
+	if err != nil {
+		return err
+	}
+
+	// The new key goes first: if a later write fails, rerunning finds the
+	// authority ahead of the keyring rather than a keyring naming a key
+	// that was never saved.
+	if apsi.IsEncryptedKeyFile(data) {
+		err = writeEncryptedAuthority(*keyPath, next, true)
+	} else {
+		var keys bytes.Buffer
+		if err = next.WriteKeys(&keys); err == nil {
+			err = replaceFile(*keyPath, keys.Bytes())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	var public, keyring bytes.Buffer
+	if err := next.WritePublicKey(&public); err != nil {
+		return err
+	}
+	if err := replaceFile(*pubPath, public.Bytes()); err != nil {
+		return err
+	}
+	if err := ring.Write(&keyring); err != nil {
+		return err
+	}
+	if err := replaceFile(*keyringPath, keyring.Bytes()); err != nil {
+		return err
+	}
+	if list != nil {
+		var signed bytes.Buffer
+		next.SignRevocationList(list)
+		if err := list.Write(&signed); err != nil {
+			return err
+		}
+		if err := replaceFile(*listPath, signed.Bytes()); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("rotated %s -> %s\n", authority.PublicKey().ID(), next.PublicKey().ID())
+	return nil
+}
+
+func runReissue(args []string) error {
+	flags := flag.NewFlagSet("reissue", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file holding the current version")
+	keyringPath := flags.String("keyring", "keyring.pem", "keyring written by apsi keystore rotate")
+	partyName := flags.String("party", "", "party the state file belongs to: client or server")
+	statePath := flags.String("state", "", "state file issued under an older version")
+	revokedPath := flags.String("revoked", "", "revocation list; revoked elements are left out")
+	outPath := flags.String("out", "", "state file to write under the current version")
+	auditPath := addAuditFlag(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *statePath == "" || *outPath == "" {
+		return errors.New("-state and -out are required")
+	}
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*auditPath)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+		authority.SetAuditLog(auditLog)
+	}
+	ring, err := loadKeyring(*keyringPath)
+	if err != nil {
+		return err
+	}
+	if ring.Current().ID() != authority.PublicKey().ID() {
+		return fmt.Errorf("%s: is not the current version in %s", *keyPath, *keyringPath)
+	}
+	state, err := readPartyFile(*statePath, party)
+	if err != nil {
+		return err
+	}
+	if err := state.onKeyring(ring, time.Now()); err != nil {
+		return fmt.Errorf("%s: %v", *statePath, err)
+	}
+	if *revokedPath != "" {
+		list, err := loadRevocationList(*revokedPath, ring.Pairing(), ring.Current().PublicKey)
+		if err != nil {
+			return err
+		}
+		var set apsi.RawElementSlice
+		var signatures []*pbc.Element
+		for i, signature := range state.signatures {
+			if !list.IsRevoked(party, signature) {
+				set = append(set, state.set[i])
+				signatures = append(signatures, signature)
+			}
+		}
+		state.set, state.signatures = set, signatures
+	}
+
+	if state.clientID != "" {
+		if authority, err = authority.ForClient(state.clientID); err != nil {
+			return err
+		}
+	}
+	// Authorizations from a sub-authority are reissued by the authority
+	// itself, so the new state file needs no delegation chain.
+	old := state.pk
+	if state.delegation != nil {
+		chain, err := apsi.UnmarshalDelegationChain(state.pairing, state.delegation)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *statePath, err)
+		}
+		old = chain.PublicKey(old)
+	}
+	pairing := authority.Pairing()
+	_, signatures, err := authority.Reissue(rebasePublicKey(pairing, old), state.set,
+		rebaseG1(pairing, state.signatures), party, state.validity.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, authority, party, state.set, signatures, state.validity, state.clientID, nil)
+}
+
+func loadKeyring(path string) (*apsi.Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ring, err := apsi.ReadKeyring(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ring, nil
+}
+
+// rebaseG1 decodes elements of G1 again in pairing. Every file carries its
+// own copy of the parameters, and PBC refuses to mix elements of pairings
+// built from different copies even when they are the same.
+func rebaseG1(pairing *pbc.Pairing, elements []*pbc.Element) []*pbc.Element {
+	rebased := make([]*pbc.Element, len(elements))
+	for i, element := range elements {
+		rebased[i] = pairing.NewG1().SetBytes(element.Bytes())
+	}
+	return rebased
+}
+
+func rebasePublicKey(pairing *pbc.Pairing, pk apsi.PublicKey) apsi.PublicKey {
+	elements := rebaseG1(pairing, []*pbc.Element{pk.P, pk.XP, pk.YP})
+	rebased := apsi.PublicKey{P: elements[0], XP: elements[1], YP: elements[2]}
+	if pk.ZP != nil {
+		rebased.ZP = rebaseG1(pairing, []*pbc.Element{pk.ZP})[0]
+	}
+	return rebased
+}
+
+// onKeyring moves state onto ring's pairing, failing unless ring accepts
+// the state's key version at now.
+func (state *partyState) onKeyring(ring *apsi.Keyring, now time.Time) error {
+	if _, err := ring.Lookup(state.pk.ID(), now); err != nil {
+		return err
+	}
+	state.pairing = ring.Pairing()
+	state.pk = rebasePublicKey(state.pairing, state.pk)
+	state.signatures = rebaseG1(state.pairing, state.signatures)
+	return nil
+}
+
+// fileList is a repeatable flag naming files, such as -state with the most
+// preferred file first.
+type fileList []string
+
+func (files *fileList) String() string {
+	return strings.Join(*files, ",")
+}
+
+func (files *fileList) Set(path string) error {
+	*files = append(*files, path)
+	return nil
+}
+
+// loadParties reads a party's state files, one per key version. With more
+// than one file, or with keyringPath set, the files are checked against the
+// keyring and those whose version has been retired are skipped.
+func loadParties(files fileList, defaultPath string, party apsi.Party, keyringPath string) ([]*partyState, error) {
+	if len(files) == 0 {
+		files = fileList{defaultPath}
+	}
+	if len(files) > 1 && keyringPath == "" {
+		return nil, errors.New("-keyring is required with more than one -state")
+	}
+	var ring *apsi.Keyring
+	if keyringPath != "" {
+		var err error
+		if ring, err = loadKeyring(keyringPath); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	var states []*partyState
+	var firstPath string
+	for _, path := range files {
+		state, err := readPartyFile(path, party)
+		if err != nil {
+			return nil, err
+		}
+		if err := state.validity.check(now); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(states) > 0 && state.validity.Epoch != states[0].validity.Epoch {
+			return nil, fmt.Errorf("%s: is for epoch %d, %s for epoch %d",
+				path, state.validity.Epoch, firstPath, states[0].validity.Epoch)
+		}
+		if len(states) > 0 && state.validity.Context != states[0].validity.Context {
+			return nil, fmt.Errorf("%s: is for a different context than %s", path, firstPath)
+		}
+		if ring != nil {
+			err := state.onKeyring(ring, now)
+			if err == apsi.ErrKeyRetired {
+				log.Printf("%s: key version %s has been retired; skipping", path, state.pk.ID())
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		if len(states) == 0 {
+			firstPath = path
+		}
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		return nil, errors.New("every state file's key version has been retired")
+	}
+	return states, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// readSetFile reads a set of elements, one hex-encoded element per line.
+// Blank lines and lines starting with '#' are skipped.
+func readSetFile(path string) (apsi.RawElementSlice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var set apsi.RawElementSlice
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		elt, err := parseElement(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		set = append(set, elt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return removeDuplicateValues(set), nil
+}
+
+// parseElement decodes a hex-encoded element.
+func parseElement(s string) (apsi.RawElement, error) {
+	var elt apsi.RawElement
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return elt, err
+	}
+	if len(b) != len(elt) {
+		return elt, fmt.Errorf("element %q is %d bytes, want %d", s, len(b), len(elt))
+	}
+	copy(elt[:], b)
+	return elt, nil
+}
+
+// formatElement hex-encodes an element the way parseElement reads it.
+func formatElement(elt apsi.RawElement) string {
+	return hex.EncodeToString(elt[:])
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// Distributed key generation runs through a shared directory:
+//
+//	setup.pem                 pairing parameters, P, t and n
+//	recipient-<j>.pem         the public key signer j receives shares under
+//	commitment-<i>.pem        dealer i's public commitment
+//	share-<i>-to-<j>.pem      dealer i's share for signer j, sealed to j
+//	threshold.pub             the resulting public key
+//
+// Each signer runs enroll, then deal once every signer has enrolled, then
+// finish once every dealer has dealt, in its own process. Enroll keeps the
+// private half of the recipient key outside the directory, so reading the
+// directory reveals no share. Share files and the private recipient key are
+// removed once used.
+var thresholdCommands = []command{
+	{"setup", "generate the parameters for a new t-of-n key", runThresholdSetup},
+	{"enroll", "publish the key this signer receives its shares under", runThresholdEnroll},
+	{"deal", "deal this signer's shares for key generation", runThresholdDeal},
+	{"finish", "collect this signer's shares and write its key", runThresholdFinish},
+	{"split", "split an existing authority key into signer keys", runThresholdSplit},
+	{"sign", "partially authorize a set with one signer's key", runThresholdSign},
+	{"combine", "combine partial authorizations into a state file", runThresholdCombine},
+}
+
+func runThreshold(args []string) error {
+	return dispatch("threshold", thresholdCommands, args)
+}
+
+func setupPath(dir string) string {
+	return filepath.Join(dir, "setup.pem")
+}
+
+func commitmentPath(dir string, dealer int) string {
+	return filepath.Join(dir, fmt.Sprintf("commitment-%d.pem", dealer))
+}
+
+func recipientPath(dir string, recipient int) string {
+	return filepath.Join(dir, fmt.Sprintf("recipient-%d.pem", recipient))
+}
+
+func dealtSharePath(dir string, dealer, recipient int) string {
+	return filepath.Join(dir, fmt.Sprintf("share-%d-to-%d.pem", dealer, recipient))
+}
+
+func loadThresholdSetup(dir string) (*apsi.ThresholdSetup, error) {
+	f, err := os.Open(setupPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	setup, err := apsi.ReadThresholdSetup(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", setupPath(dir), err)
+	}
+	return setup, nil
+}
+
+func runThresholdSetup(args []string) error {
+	flags := flag.NewFlagSet("threshold setup", flag.ExitOnError)
+	threshold := flags.Int("t", 2, "number of signers needed to authorize")
+	signers := flags.Int("n", 3, "number of signers")
+	dir := flags.String("dir", "dkg", "directory shared by the signers")
+	flags.Parse(args)
+
+	setup, err := apsi.NewThresholdSetup(*threshold, *signers)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	return writeFileWith(setupPath(*dir), 0644, func(f *os.File) error {
+		return setup.Write(f)
+	})
+}
+
+func runThresholdEnroll(args []string) error {
+	flags := flag.NewFlagSet("threshold enroll", flag.ExitOnError)
+	dir := flags.String("dir", "dkg", "directory shared by the signers")
+	index := flags.Int("index", 0, "this signer's index, from 1 to n")
+	keyPath := flags.String("recipient-key", "", "private recipient key to write, outside -dir (default recipient-<index>.key)")
+	flags.Parse(args)
+
+	if *keyPath == "" {
+		*keyPath = fmt.Sprintf("recipient-%d.key", *index)
+	}
+	setup, err := loadThresholdSetup(*dir)
+	if err != nil {
+		return err
+	}
+	recipient, err := setup.NewDKGRecipient(*index)
+	if err != nil {
+		return err
+	}
+	if err := writeFileWith(*keyPath, 0600, func(f *os.File) error {
+		return recipient.WriteKey(f)
+	}); err != nil {
+		return err
+	}
+	return writeFileWith(recipientPath(*dir, *index), 0644, func(f *os.File) error {
+		return recipient.Write(f)
+	})
+}
+
+// loadRecipient reads the recipient key of signer index from path, which
+// is either the published recipient-<index>.pem or the signer's private
+// key file.
+func loadRecipient(setup *apsi.ThresholdSetup, path string, index int) (*apsi.DKGRecipient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := setup.ReadDKGRecipient(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if recipient.Index != index {
+		return nil, fmt.Errorf("%s: key of signer %d, not %d", path, recipient.Index, index)
+	}
+	return recipient, nil
+}
+
+func runThresholdDeal(args []string) error {
+	flags := flag.NewFlagSet("threshold deal", flag.ExitOnError)
+	dir := flags.String("dir", "dkg", "directory shared by the signers")
+	index := flags.Int("index", 0, "this signer's index, from 1 to n")
+	flags.Parse(args)
+
+	setup, err := loadThresholdSetup(*dir)
+	if err != nil {
+		return err
+	}
+	recipients := make([]*apsi.DKGRecipient, setup.Signers)
+	for i := range recipients {
+		path := recipientPath(*dir, i+1)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("waiting for signer %d to enroll: %v", i+1, err)
+		}
+		if recipients[i], err = loadRecipient(setup, path, i+1); err != nil {
+			return err
+		}
+	}
+	deal, err := setup.NewDeal(*index)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		share, err := deal.Share(recipient.Index)
+		if err != nil {
+			return err
+		}
+		if err := writeFileWith(dealtSharePath(*dir, *index, recipient.Index), 0644, func(f *os.File) error {
+			return share.WriteSealed(f, recipient)
+		}); err != nil {
+			return err
+		}
+	}
+	return writeFileWith(commitmentPath(*dir, *index), 0644, func(f *os.File) error {
+		return deal.Commitment().Write(f)
+	})
+}
+
+func runThresholdFinish(args []string) error {
+	flags := flag.NewFlagSet("threshold finish", flag.ExitOnError)
+	dir := flags.String("dir", "dkg", "directory shared by the signers")
+	index := flags.Int("index", 0, "this signer's index, from 1 to n")
+	keyPath := flags.String("key", "", "signer key file to write (default signer-<index>.key)")
+	recipientKeyPath := flags.String("recipient-key", "", "private recipient key written by enroll (default recipient-<index>.key)")
+	plaintext := flags.Bool("plaintext", false, "write the share unencrypted (testing only)")
+	flags.Parse(args)
+
+	if *keyPath == "" {
+		*keyPath = fmt.Sprintf("signer-%d.key", *index)
+	}
+	if *recipientKeyPath == "" {
+		*recipientKeyPath = fmt.Sprintf("recipient-%d.key", *index)
+	}
+	setup, err := loadThresholdSetup(*dir)
+	if err != nil {
+		return err
+	}
+	recipient, err := loadRecipient(setup, *recipientKeyPath, *index)
+	if err != nil {
+		return err
+	}
+
+	var commitments []*apsi.DKGCommitment
+	var shares []*apsi.DKGShare
+	for dealer := 1; dealer <= setup.Signers; dealer++ {
+		data, err := ioutil.ReadFile(commitmentPath(*dir, dealer))
+		if err != nil {
+			return fmt.Errorf("waiting for dealer %d: %v", dealer, err)
+		}
+		commitment, err := setup.ReadDKGCommitment(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", commitmentPath(*dir, dealer), err)
+		}
+		commitments = append(commitments, commitment)
+
+		data, err = ioutil.ReadFile(dealtSharePath(*dir, dealer, *index))
+		if err != nil {
+			return err
+		}
+		share, err := setup.ReadSealedDKGShare(bytes.NewReader(data), recipient)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dealtSharePath(*dir, dealer, *index), err)
+		}
+		shares = append(shares, share)
+	}
+
+	signer, err := setup.FinishDKG(*index, commitments, shares)
+	if err != nil {
+		return err
+	}
+	if err := writePublicOrCompare(filepath.Join(*dir, "threshold.pub"), signer.PublicKey()); err != nil {
+		return err
+	}
+	if err := writeSigner(*keyPath, signer, *plaintext); err != nil {
+		return err
+	}
+	for dealer := 1; dealer <= setup.Signers; dealer++ {
+		os.Remove(dealtSharePath(*dir, dealer, *index))
+	}
+	return os.Remove(*recipientKeyPath)
+}
+
+// writePublicOrCompare writes the threshold public key to path, or, if an
+// earlier signer already did, checks that everyone derived the same key.
+func writePublicOrCompare(path string, tpk *apsi.ThresholdPublicKey) error {
+	var public bytes.Buffer
+	if err := tpk.WritePublicKey(&public); err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return writeFileWith(path, 0644, func(f *os.File) error {
+			_, err := f.Write(public.Bytes())
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, public.Bytes()) {
+		return fmt.Errorf("%s: another signer derived a different public key", path)
+	}
+	return nil
+}
+
+func runThresholdSplit(args []string) error {
+	flags := flag.NewFlagSet("threshold split", flag.ExitOnError)
+	keyPath := flags.String("key", "authority.key", "authority key file to split")
+	threshold := flags.Int("t", 2, "number of signers needed to authorize")
+	signers := flags.Int("n", 3, "number of signers")
+	dir := flags.String("dir", ".", "directory to write signer-<i>.key and threshold.pub to")
+	plaintext := flags.Bool("plaintext", false, "write the shares unencrypted (testing only)")
+	flags.Parse(args)
+
+	authority, err := loadAuthority(*keyPath)
+	if err != nil {
+		return err
+	}
+	tpk, shares, err := authority.Split(*threshold, *signers)
+	if err != nil {
+		return err
+	}
+	if err := writeFileWith(filepath.Join(*dir, "threshold.pub"), 0644, func(f *os.File) error {
+		return tpk.WritePublicKey(f)
+	}); err != nil {
+		return err
+	}
+	for _, signer := range shares {
+		path := filepath.Join(*dir, fmt.Sprintf("signer-%d.key", signer.Index()))
+		if err := writeSigner(path, signer, *plaintext); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s can now be destroyed\n", *keyPath)
+	return nil
+}
+
+func writeSigner(path string, signer *apsi.ThresholdSigner, plaintext bool) error {
+	if plaintext {
+		return writeFileWith(path, 0600, func(f *os.File) error {
+			return signer.WriteShare(f)
+		})
+	}
+	passphrase, err := readNewPassphrase("New passphrase for " + path)
+	if err != nil {
+		return err
+	}
+	return writeFileWith(path, 0600, func(f *os.File) error {
+		return signer.WriteEncryptedShare(f, passphrase, apsi.DefaultKDFParams)
+	})
+}
+
+// loadSigner reads a signer key file, asking for the passphrase if the
+// share is encrypted.
+func loadSigner(path string) (*apsi.ThresholdSigner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer *apsi.ThresholdSigner
+	if apsi.IsEncryptedKeyFile(data) {
+		var passphrase []byte
+		passphrase, err = readPassphrase("Passphrase for " + path)
+		if err != nil {
+			return nil, err
+		}
+		signer, err = apsi.ReadEncryptedThresholdSigner(bytes.NewReader(data), passphrase)
+	} else {
+		signer, err = apsi.ReadThresholdSigner(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return signer, nil
+}
+
+// partialFile holds one signer's partial authorizations of a set.
+type partialFile struct {
+	Signer     int
+	Party      apsi.Party
+	Set        apsi.RawElementSlice
+	Signatures [][]byte
+	Validity   validity
+}
+
+func runThresholdSign(args []string) error {
+	flags := flag.NewFlagSet("threshold sign", flag.ExitOnError)
+	keyPath := flags.String("key", "", "signer key file")
+	partyName := flags.String("party", "", "party to authorize the set for: client or server")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "partial file to write (default <party>-<index>.partial)")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *keyPath == "" || *setPath == "" {
+		return errors.New("-key and -set are required")
+	}
+	signer, err := loadSigner(*keyPath)
+	if err != nil {
+		return err
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	_, partials, err := signer.PartialAuthorizeSet(set, party, v.authorizeOptions()...)
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "" {
+		*outPath = fmt.Sprintf("%s-%d.partial", party, signer.Index())
+	}
+	state := partialFile{Signer: signer.Index(), Party: party, Set: set, Validity: v}
+	for _, partial := range partials {
+		state.Signatures = append(state.Signatures, partial.Signature.Bytes())
+	}
+	return writeGobFile(*outPath, 0644, &state)
+}
+
+func readPartialFile(path string, pairing *pbc.Pairing, party apsi.Party) (map[apsi.RawElement]apsi.PartialAuthorization, validity, error) {
+	var state partialFile
+	if err := readGobFile(path, &state); err != nil {
+		return nil, validity{}, err
+	}
+	if state.Party != party {
+		return nil, validity{}, fmt.Errorf("%s: holds %s partials, want %s", path, state.Party, party)
+	}
+	if len(state.Set) != len(state.Signatures) {
+		return nil, validity{}, fmt.Errorf("%s: %v", path, apsi.ErrSignatureCount)
+	}
+
+	partials := make(map[apsi.RawElement]apsi.PartialAuthorization, len(state.Set))
+	for i, elt := range state.Set {
+		b := state.Signatures[i]
+		if len(b) != int(pairing.G1Length()) {
+			return nil, validity{}, fmt.Errorf("%s: signature %d is malformed", path, i)
+		}
+		partials[elt] = apsi.PartialAuthorization{
+			Signer:    state.Signer,
+			Signature: pairing.NewG1().SetBytes(b),
+		}
+	}
+	return partials, state.Validity, nil
+}
+
+func runThresholdCombine(args []string) error {
+	flags := flag.NewFlagSet("threshold combine", flag.ExitOnError)
+	pubPath := flags.String("pub", "threshold.pub", "threshold public key")
+	partyName := flags.String("party", "", "party the set is authorized for: client or server")
+	setPath := flags.String("set", "", "file of elements, one hex element per line")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	flags.Parse(args)
+
+	party, err := parseParty(*partyName)
+	if err != nil {
+		return err
+	}
+	if *setPath == "" || flags.NArg() == 0 {
+		return errors.New("usage: apsi threshold combine -party p -set file partial-file...")
+	}
+	if *outPath == "" {
+		*outPath = party.String() + ".apsi"
+	}
+
+	f, err := os.Open(*pubPath)
+	if err != nil {
+		return err
+	}
+	tpk, err := apsi.ReadThresholdPublicKey(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", *pubPath, err)
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+
+	partials := make([][]apsi.PartialAuthorization, len(set))
+	var v validity
+	for i, path := range flags.Args() {
+		byElement, partialValidity, err := readPartialFile(path, tpk.Pairing(), party)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			v = partialValidity
+		} else if partialValidity.Epoch != v.Epoch {
+			return fmt.Errorf("%s: signed for epoch %d, not %d like %s", path, partialValidity.Epoch, v.Epoch, flags.Arg(0))
+		} else if partialValidity.Context != v.Context {
+			return fmt.Errorf("%s: signed for a different context than %s", path, flags.Arg(0))
+		}
+		for i, elt := range set {
+			if partial, ok := byElement[elt]; ok {
+				partials[i] = append(partials[i], partial)
+			}
+		}
+	}
+	signatures := make([]*pbc.Element, len(set))
+	for i, elt := range set {
+		_, signatures[i], err = tpk.Combine(elt, party, partials[i], v.authorizeOptions()...)
+		if err != nil {
+			return fmt.Errorf("%s: %v", formatElement(elt), err)
+		}
+	}
+	return writePartyFile(*outPath, tpk, party, set, signatures, v, "", nil)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Nik-U/pbc"
+
+	"github.com/ZacharyEspiritu/apsi-variants/apsi"
+)
+
+// A wallet file keeps every certificate a party has been issued, so that
+// authorizations collected over months can be turned into a state file
+// for whatever set the next session needs.
+var walletCommands = []command{
+	{"add", "add certificates to a party's wallet", runWalletAdd},
+	{"status", "report which elements of a set lack a current authorization", runWalletStatus},
+	{"export", "write a state file for a set from the wallet", runWalletExport},
+	{"prune", "drop expired certificates from the wallet", runWalletPrune},
+}
+
+func runWallet(args []string) error {
+	return dispatch("wallet", walletCommands, args)
+}
+
+// walletFlags are the flags every wallet command takes.
+type walletFlags struct {
+	party  *string
+	wallet *string
+	pub    *string
+}
+
+func addWalletFlags(flags *flag.FlagSet) walletFlags {
+	return walletFlags{
+		party:  flags.String("party", "", "party the wallet is for: client or server"),
+		wallet: flags.String("wallet", "", "wallet file (default <party>.wallet)"),
+		pub:    flags.String("pub", "authority.pub", "public key file of the issuing authority"),
+	}
+}
+
+// open reads the wallet the flags name and returns it with its path. A
+// wallet that does not exist yet opens empty.
+func (wf walletFlags) open() (*apsi.Wallet, string, error) {
+	party, err := parseParty(*wf.party)
+	if err != nil {
+		return nil, "", err
+	}
+	path := *wf.wallet
+	if path == "" {
+		path = party.String() + ".wallet"
+	}
+	pairing, pk, err := loadPublicKey(*wf.pub)
+	if err != nil {
+		return nil, "", err
+	}
+	wallet, err := loadWallet(path, pairing, pk)
+	if os.IsNotExist(err) {
+		wallet, err = apsi.NewWallet(pairing, pk, party)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if wallet.Party() != party {
+		return nil, "", fmt.Errorf("%s: holds %s certificates, want %s", path, wallet.Party(), party)
+	}
+	return wallet, path, nil
+}
+
+func runWalletAdd(args []string) error {
+	flags := flag.NewFlagSet("wallet add", flag.ExitOnError)
+	wf := addWalletFlags(flags)
+	certsPath := flags.String("certs", "", "certificate file written by apsi cert export")
+	setPath := flags.String("set", "", "file of the certified elements, one hex element per line")
+	flags.Parse(args)
+
+	if *certsPath == "" || *setPath == "" {
+		return errors.New("-certs and -set are required")
+	}
+	wallet, walletPath, err := wf.open()
+	if err != nil {
+		return err
+	}
+	certs, err := loadCertificates(*certsPath, wallet.Pairing())
+	if err != nil {
+		return err
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	added := 0
+	for _, elt := range set {
+		for _, cert := range certs {
+			if !cert.Opens(elt) {
+				continue
+			}
+			if err := wallet.Add(elt, cert, now); err != nil {
+				return fmt.Errorf("%s: element %x: %v", *certsPath, elt, err)
+			}
+			added++
+		}
+	}
+	if added < len(certs) {
+		fmt.Fprintf(os.Stderr, "%d of %d certificates are for elements not in %s\n", len(certs)-added, len(certs), *setPath)
+	}
+	return saveWallet(walletPath, wallet)
+}
+
+func runWalletStatus(args []string) error {
+	flags := flag.NewFlagSet("wallet status", flag.ExitOnError)
+	wf := addWalletFlags(flags)
+	setPath := flags.String("set", "", "file of elements to look up, one hex element per line")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	wallet, _, err := wf.open()
+	if err != nil {
+		return err
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	ws := wallet.Lookup(set, time.Now(), bindingFlags().authorizeOptions()...)
+	fmt.Printf("%d authorized, %d missing, %d expired\n", len(ws.Set), len(ws.Missing), len(ws.Expired))
+	for _, elt := range ws.Missing {
+		fmt.Printf("missing %x\n", elt)
+	}
+	for _, elt := range ws.Expired {
+		fmt.Printf("expired %x\n", elt)
+	}
+	return nil
+}
+
+func runWalletExport(args []string) error {
+	flags := flag.NewFlagSet("wallet export", flag.ExitOnError)
+	wf := addWalletFlags(flags)
+	setPath := flags.String("set", "", "file of elements to export, one hex element per line")
+	outPath := flags.String("out", "", "state file to write (default <party>.apsi)")
+	partial := flags.Bool("partial", false, "leave out elements without a current authorization instead of failing")
+	bindingFlags := addBindingFlags(flags)
+	flags.Parse(args)
+
+	if *setPath == "" {
+		return errors.New("-set is required")
+	}
+	wallet, walletPath, err := wf.open()
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		*outPath = wallet.Party().String() + ".apsi"
+	}
+	set, err := readSetFile(*setPath)
+	if err != nil {
+		return err
+	}
+	v := bindingFlags()
+	ws := wallet.Lookup(set, time.Now(), v.authorizeOptions()...)
+	if !ws.Complete() {
+		if !*partial {
+			return fmt.Errorf("%s: %d elements missing and %d expired; see apsi wallet status",
+				walletPath, len(ws.Missing), len(ws.Expired))
+		}
+		fmt.Fprintf(os.Stderr, "leaving out %d missing and %d expired elements\n", len(ws.Missing), len(ws.Expired))
+	}
+	if len(ws.Set) == 0 {
+		return fmt.Errorf("%s: no current authorizations for %s", walletPath, *setPath)
+	}
+	public, err := ioutil.ReadFile(*wf.pub)
+	if err != nil {
+		return err
+	}
+	return writePartyFile(*outPath, publicKeyBytes(public), wallet.Party(), ws.Set, ws.Signatures, v, "", nil)
+}
+
+func runWalletPrune(args []string) error {
+	flags := flag.NewFlagSet("wallet prune", flag.ExitOnError)
+	wf := addWalletFlags(flags)
+	flags.Parse(args)
+
+	wallet, walletPath, err := wf.open()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d expired certificates\n", wallet.Prune(time.Now()))
+	return saveWallet(walletPath, wallet)
+}
+
+func loadWallet(path string, pairing *pbc.Pairing, pk apsi.PublicKey) (*apsi.Wallet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	wallet, err := apsi.ReadWallet(f, pairing, pk)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wallet, nil
+}
+
+func saveWallet(path string, wallet *apsi.Wallet) error {
+	var out bytes.Buffer
+	if err := wallet.Write(&out); err != nil {
+		return err
+	}
+	return replaceFile(path, out.Bytes())
+}
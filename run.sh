@@ -1,8 +1,9 @@
 #!/bin/bash
 
 APSI_DIR=/apsi
+APSI_PKG=github.com/ZacharyEspiritu/apsi-variants
 
-go build -o "${APSI_DIR}/apsi" "${APSI_DIR}/main.go"
+go build -o "${APSI_DIR}/apsi" "${APSI_PKG}/cmd/apsi"
 
 "${APSI_DIR}/apsi" -cpuprofile="apsi.prof"
 